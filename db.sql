@@ -9,6 +9,7 @@ CREATE TABLE "messages" (
   "sender_id" int NOT NULL,
   "content" text NOT NULL,
   "reply_to_id" int,
+  "client_msg_id" varchar(64),
   "is_pinned" bool DEFAULT false,
   "is_edited" bool DEFAULT false,
   "created_at" timestamp DEFAULT (now()),
@@ -16,9 +17,20 @@ CREATE TABLE "messages" (
   "deleted_at" timestamp
 );
 
-CREATE TABLE "message_attachments" (
+CREATE TABLE "message_revisions" (
   "id" SERIAL PRIMARY KEY,
   "message_id" int NOT NULL,
+  "content" text NOT NULL,
+  "edited_by" int NOT NULL,
+  "created_at" timestamp
+);
+
+CREATE TABLE "message_attachments" (
+  "id" SERIAL PRIMARY KEY,
+  "message_id" int,
+  "room_id" int NOT NULL,
+  "uploader_id" int NOT NULL,
+  "file_name" text NOT NULL,
   "file_path" text NOT NULL,
   "file_type" text NOT NULL,
   "file_size" int,
@@ -31,9 +43,20 @@ CREATE TABLE "rooms" (
   "name" text,
   "community_id" int NOT NULL,
   "type" room_type NOT NULL,
+  "is_private" bool DEFAULT false,
+  "last_seq" bigint NOT NULL DEFAULT 0,
   "created_at" timestamp DEFAULT (now())
 );
 
+CREATE TABLE "room_events" (
+  "id" SERIAL PRIMARY KEY,
+  "room_id" int NOT NULL,
+  "seq" bigint NOT NULL,
+  "type" varchar(50) NOT NULL,
+  "data" text NOT NULL,
+  "created_at" timestamp
+);
+
 CREATE TABLE "room_participants" (
   "id" SERIAL PRIMARY KEY,
   "room_id" int NOT NULL,
@@ -57,6 +80,12 @@ CREATE TABLE "message_reactions" (
   "created_at" timestamp DEFAULT (now())
 );
 
+CREATE TABLE "broker_events" (
+  "id" BIGSERIAL PRIMARY KEY,
+  "payload" text NOT NULL,
+  "created_at" timestamp DEFAULT (now())
+);
+
 CREATE TABLE "communities" (
   "id" int PRIMARY KEY
 );
@@ -73,10 +102,24 @@ CREATE INDEX idx_messages_room_created_at ON messages (room_id, created_at DESC)
 
 CREATE INDEX idx_messages_room_pinned ON messages (room_id, is_pinned);
 
-CREATE INDEX idx_messages_reply_to ON messages (reply_to_id);
+CREATE INDEX idx_messages_reply_to_id ON messages (reply_to_id);
+
+CREATE UNIQUE INDEX idx_messages_sender_client_msg_id ON messages (sender_id, client_msg_id);
+
+CREATE INDEX idx_messages_content_search ON messages USING GIN (to_tsvector('english', content));
+
+CREATE INDEX idx_message_revisions_message ON message_revisions (message_id);
+
+CREATE INDEX idx_message_attachments_message ON message_attachments (message_id);
+CREATE INDEX idx_message_attachments_room ON message_attachments (room_id);
 
 CREATE INDEX idx_rooms_community ON rooms (community_id);
 
+CREATE UNIQUE INDEX idx_room_events_room_seq ON room_events (room_id, seq);
+CREATE INDEX idx_room_events_created_at ON room_events (created_at);
+
+CREATE INDEX idx_broker_events_created_at ON broker_events (created_at);
+
 
 CREATE INDEX idx_room_participants_room ON room_participants (room_id);
 CREATE INDEX idx_room_participants_user ON room_participants (user_id);
@@ -92,12 +135,16 @@ ALTER TABLE "messages" ADD FOREIGN KEY ("room_id") REFERENCES "rooms" ("id");
 
 ALTER TABLE "messages" ADD FOREIGN KEY ("sender_id") REFERENCES "users" ("id");
 
+ALTER TABLE "message_revisions" ADD FOREIGN KEY ("message_id") REFERENCES "messages" ("id");
+
 ALTER TABLE "message_attachments" ADD FOREIGN KEY ("message_id") REFERENCES "messages" ("id");
 
 ALTER TABLE "messages" ADD FOREIGN KEY ("reply_to_id") REFERENCES "messages" ("id");
 
 ALTER TABLE "rooms" ADD FOREIGN KEY ("community_id") REFERENCES "communities" ("id");
 
+ALTER TABLE "room_events" ADD FOREIGN KEY ("room_id") REFERENCES "rooms" ("id");
+
 ALTER TABLE "room_participants" ADD FOREIGN KEY ("room_id") REFERENCES "rooms" ("id");
 
 ALTER TABLE "room_participants" ADD FOREIGN KEY ("user_id") REFERENCES "users" ("id");
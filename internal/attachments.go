@@ -0,0 +1,218 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+	"ws-whatever/internal/storage"
+	"ws-whatever/ws"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo"
+)
+
+var allowedAttachmentMimes = map[string]bool{
+	"image/png":       true,
+	"image/jpeg":      true,
+	"image/gif":       true,
+	"image/webp":      true,
+	"application/pdf": true,
+	"text/plain":      true,
+	"video/mp4":       true,
+	"audio/mpeg":      true,
+}
+
+const (
+	attachmentSweepInterval = time.Hour
+	attachmentSweepBatch    = 100
+)
+
+// UploadAttachment accepts files of up to maxSize bytes.
+func UploadAttachment(s ws.Stores, store storage.Storage, maxSize int64) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		roomID, err := strconv.Atoi(c.Param("id"))
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "invalid room id")
+		}
+
+		userID := c.Get("user_id")
+		if userID == nil {
+			return echo.NewHTTPError(http.StatusUnauthorized, "unauthorized")
+		}
+
+		if _, err := ws.Authorize(s, userID.(int), roomID, ws.ActionPost); err != nil {
+			return httpError(err, "failed to upload attachment")
+		}
+
+		// Leave some headroom for the multipart framing around the file.
+		c.Request().Body = http.MaxBytesReader(c.Response(), c.Request().Body, maxSize+1<<20)
+
+		header, err := c.FormFile("file")
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "file is required")
+		}
+
+		if header.Size == 0 {
+			return echo.NewHTTPError(http.StatusBadRequest, "file is empty")
+		}
+		if header.Size > maxSize {
+			return echo.NewHTTPError(http.StatusRequestEntityTooLarge, "file is too large")
+		}
+
+		file, err := header.Open()
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "failed to read file")
+		}
+		defer file.Close()
+
+		// Trust the content, not the client supplied Content-Type.
+		sniff := make([]byte, 512)
+		n, err := io.ReadFull(file, sniff)
+		if err != nil && err != io.ErrUnexpectedEOF {
+			return echo.NewHTTPError(http.StatusBadRequest, "failed to read file")
+		}
+		fileMime, _, _ := mime.ParseMediaType(http.DetectContentType(sniff[:n]))
+		if !allowedAttachmentMimes[fileMime] {
+			return echo.NewHTTPError(http.StatusUnsupportedMediaType, "file type not allowed")
+		}
+
+		if _, err := file.Seek(0, io.SeekStart); err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "failed to read file")
+		}
+
+		fileName := filepath.Base(header.Filename)
+		key := "rooms/" + strconv.Itoa(roomID) + "/" + uuid.New().String() + strings.ToLower(filepath.Ext(fileName))
+
+		ctx := c.Request().Context()
+		if err := store.Put(ctx, key, file, header.Size, fileMime); err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "failed to store file")
+		}
+
+		attachment := ws.MessageAttachment{
+			RoomID:     roomID,
+			UploaderID: userID.(int),
+			FileName:   fileName,
+			FilePath:   key,
+			FileType:   attachmentType(fileMime),
+			FileSize:   int(header.Size),
+			FileMime:   fileMime,
+		}
+		if err := s.Messages.CreateAttachment(&attachment); err != nil {
+			store.Delete(ctx, key)
+			return echo.NewHTTPError(http.StatusInternalServerError, "failed to save attachment")
+		}
+
+		return c.JSON(http.StatusCreated, ws.NewAttachmentPayload(attachment))
+	}
+}
+
+func GetAttachment(s ws.Stores, store storage.Storage) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		attachmentID, err := strconv.Atoi(c.Param("id"))
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "invalid attachment id")
+		}
+
+		userID := c.Get("user_id")
+		if userID == nil {
+			return echo.NewHTTPError(http.StatusUnauthorized, "unauthorized")
+		}
+
+		attachment, err := s.Messages.GetAttachment(attachmentID)
+		if err != nil {
+			return httpError(err, "failed to fetch attachment")
+		}
+
+		if _, err := ws.Authorize(s, userID.(int), attachment.RoomID, ws.ActionRead); err != nil {
+			return httpError(err, "failed to fetch attachment")
+		}
+
+		body, err := store.Open(c.Request().Context(), attachment.FilePath)
+		if err != nil {
+			if err == storage.ErrNotFound {
+				return echo.NewHTTPError(http.StatusNotFound, "attachment not found")
+			}
+			return echo.NewHTTPError(http.StatusInternalServerError, "failed to read attachment")
+		}
+		defer body.Close()
+
+		disposition := mime.FormatMediaType("inline", map[string]string{"filename": attachment.FileName})
+		c.Response().Header().Set("Content-Disposition", disposition)
+		c.Response().Header().Set("Content-Length", strconv.Itoa(attachment.FileSize))
+		return c.Stream(http.StatusOK, attachment.FileMime, body)
+	}
+}
+
+// SweepAttachments deletes uploads that no message claimed within ttl,
+// files included, every attachmentSweepInterval until ctx is cancelled.
+func SweepAttachments(ctx context.Context, s ws.Stores, store storage.Storage, ttl time.Duration, logger *slog.Logger) {
+	ticker := time.NewTicker(attachmentSweepInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			deleted, err := deleteUnclaimedAttachments(ctx, s, store, time.Now().Add(-ttl))
+			if err != nil {
+				logger.Error("failed to delete unclaimed attachments", "error", err, "deleted", deleted)
+			} else if deleted > 0 {
+				logger.Info("deleted unclaimed attachments", "deleted", deleted)
+			}
+		}
+	}
+}
+
+// deleteUnclaimedAttachments deletes uploads created before before that no
+// message has claimed and returns how many it deleted. Each row goes before
+// its file, so a message can never claim an upload whose file is gone.
+func deleteUnclaimedAttachments(ctx context.Context, s ws.Stores, store storage.Storage, before time.Time) (int, error) {
+	deleted := 0
+	for {
+		attachments, err := s.Messages.UnclaimedAttachments(before, attachmentSweepBatch)
+		if err != nil {
+			return deleted, err
+		}
+
+		for _, attachment := range attachments {
+			ok, err := s.Messages.DeleteUnclaimedAttachment(attachment.ID)
+			if err != nil {
+				return deleted, err
+			}
+			// Claimed since it was listed.
+			if !ok {
+				continue
+			}
+
+			if err := store.Delete(ctx, attachment.FilePath); err != nil {
+				return deleted, fmt.Errorf("delete %s: %w", attachment.FilePath, err)
+			}
+			deleted++
+		}
+
+		if len(attachments) < attachmentSweepBatch {
+			return deleted, nil
+		}
+	}
+}
+
+func attachmentType(fileMime string) string {
+	switch {
+	case strings.HasPrefix(fileMime, "image/"):
+		return "image"
+	case strings.HasPrefix(fileMime, "video/"):
+		return "video"
+	case strings.HasPrefix(fileMime, "audio/"):
+		return "audio"
+	default:
+		return "file"
+	}
+}
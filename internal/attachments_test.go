@@ -0,0 +1,86 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+	"ws-whatever/internal/storage"
+	"ws-whatever/internal/store"
+	"ws-whatever/ws"
+)
+
+func TestDeleteUnclaimedAttachments(t *testing.T) {
+	tests := []struct {
+		name        string
+		age         time.Duration
+		claimed     bool
+		wantDeleted bool
+	}{
+		{name: "old unclaimed upload", age: 2 * time.Hour, wantDeleted: true},
+		{name: "old claimed upload", age: 2 * time.Hour, claimed: true},
+		{name: "recent upload", age: time.Minute},
+	}
+
+	ctx := context.Background()
+	stores := store.NewMemory().Stores()
+	files, err := storage.NewLocal(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	room := ws.Room{Name: "room", CommunityID: 1, Type: ws.RoomTypeGroup}
+	if err := stores.Rooms.CreateRoom(&room, []ws.RoomParticipant{{UserID: 1, Role: ws.RoleMember}}); err != nil {
+		t.Fatal(err)
+	}
+
+	attachments := make([]ws.MessageAttachment, len(tests))
+	wantDeleted := 0
+	for i, tt := range tests {
+		key := "rooms/1/" + strings.ReplaceAll(tt.name, " ", "-") + ".txt"
+		if err := files.Put(ctx, key, strings.NewReader(tt.name), int64(len(tt.name)), "text/plain"); err != nil {
+			t.Fatal(err)
+		}
+
+		attachments[i] = ws.MessageAttachment{
+			RoomID: room.ID, UploaderID: 1, FileName: "a.txt", FilePath: key,
+			FileType: "file", FileSize: len(tt.name), FileMime: "text/plain", CreatedAt: time.Now().Add(-tt.age),
+		}
+		if err := stores.Messages.CreateAttachment(&attachments[i]); err != nil {
+			t.Fatal(err)
+		}
+
+		if tt.claimed {
+			message := ws.Message{RoomID: room.ID, SenderID: 1, Content: tt.name}
+			if _, err := stores.Messages.CreateMessage(&message, []int{attachments[i].ID}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if tt.wantDeleted {
+			wantDeleted++
+		}
+	}
+
+	deleted, err := deleteUnclaimedAttachments(ctx, stores, files, time.Now().Add(-time.Hour))
+	if err != nil || deleted != wantDeleted {
+		t.Fatalf("deleteUnclaimedAttachments() = %d, %v; want %d", deleted, err, wantDeleted)
+	}
+
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := stores.Messages.GetAttachment(attachments[i].ID)
+			if gone := errors.Is(err, ws.ErrAttachmentNotFound); gone != tt.wantDeleted {
+				t.Fatalf("GetAttachment() error = %v, want deleted %v", err, tt.wantDeleted)
+			}
+
+			body, err := files.Open(ctx, attachments[i].FilePath)
+			if err == nil {
+				body.Close()
+			}
+			if gone := errors.Is(err, storage.ErrNotFound); gone != tt.wantDeleted {
+				t.Fatalf("Open() error = %v, want deleted %v", err, tt.wantDeleted)
+			}
+		})
+	}
+}
@@ -0,0 +1,217 @@
+package auth
+
+import (
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/labstack/echo"
+)
+
+var (
+	ErrMissingToken = errors.New("missing token")
+	ErrUnknownKey   = errors.New("no key for token")
+)
+
+// Claims are the token claims the server relies on. The user ID is carried
+// in the standard "sub" claim.
+type Claims struct {
+	jwt.RegisteredClaims
+	CommunityID int `json:"community_id"`
+}
+
+type Options struct {
+	// Secret verifies HS256 tokens without a "kid" header.
+	Secret []byte
+	// JWKSFile is a JSON Web Key Set with RSA keys for RS256 and "oct" keys
+	// for HS256, selected by the token's "kid" header.
+	JWKSFile string
+	Issuer   string
+	Audience string
+}
+
+type Verifier struct {
+	secret   []byte
+	hmacKeys map[string][]byte
+	rsaKeys  map[string]*rsa.PublicKey
+	parser   *jwt.Parser
+}
+
+func NewVerifier(opts Options) (*Verifier, error) {
+	v := &Verifier{
+		secret:   opts.Secret,
+		hmacKeys: make(map[string][]byte),
+		rsaKeys:  make(map[string]*rsa.PublicKey),
+	}
+
+	if opts.JWKSFile != "" {
+		if err := v.loadJWKS(opts.JWKSFile); err != nil {
+			return nil, fmt.Errorf("failed to load JWKS: %w", err)
+		}
+	}
+
+	if len(v.secret) == 0 && len(v.hmacKeys) == 0 && len(v.rsaKeys) == 0 {
+		return nil, errors.New("auth requires a secret or a JWKS file")
+	}
+
+	parserOpts := []jwt.ParserOption{
+		jwt.WithValidMethods([]string{"HS256", "RS256"}),
+		jwt.WithExpirationRequired(),
+	}
+	if opts.Issuer != "" {
+		parserOpts = append(parserOpts, jwt.WithIssuer(opts.Issuer))
+	}
+	if opts.Audience != "" {
+		parserOpts = append(parserOpts, jwt.WithAudience(opts.Audience))
+	}
+	v.parser = jwt.NewParser(parserOpts...)
+
+	return v, nil
+}
+
+// Verify checks the token signature and standard claims and returns the
+// authenticated user ID with the token claims.
+func (v *Verifier) Verify(tokenString string) (int, *Claims, error) {
+	claims := &Claims{}
+	if _, err := v.parser.ParseWithClaims(tokenString, claims, v.key); err != nil {
+		return 0, nil, err
+	}
+
+	userID, err := strconv.Atoi(claims.Subject)
+	if err != nil || userID <= 0 {
+		return 0, nil, errors.New("token subject is not a valid user id")
+	}
+
+	return userID, claims, nil
+}
+
+func (v *Verifier) key(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+
+	switch token.Method.(type) {
+	case *jwt.SigningMethodHMAC:
+		if key, ok := v.hmacKeys[kid]; ok {
+			return key, nil
+		}
+		if kid == "" && len(v.secret) > 0 {
+			return v.secret, nil
+		}
+	case *jwt.SigningMethodRSA:
+		if key, ok := v.rsaKeys[kid]; ok {
+			return key, nil
+		}
+		if kid == "" && len(v.rsaKeys) == 1 {
+			for _, key := range v.rsaKeys {
+				return key, nil
+			}
+		}
+	}
+
+	return nil, ErrUnknownKey
+}
+
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	K   string `json:"k"`
+}
+
+func (v *Verifier) loadJWKS(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &set); err != nil {
+		return err
+	}
+
+	for _, key := range set.Keys {
+		switch key.Kty {
+		case "RSA":
+			n, err := base64.RawURLEncoding.DecodeString(key.N)
+			if err != nil {
+				return fmt.Errorf("key %q: invalid modulus: %w", key.Kid, err)
+			}
+			e, err := base64.RawURLEncoding.DecodeString(key.E)
+			if err != nil {
+				return fmt.Errorf("key %q: invalid exponent: %w", key.Kid, err)
+			}
+			v.rsaKeys[key.Kid] = &rsa.PublicKey{
+				N: new(big.Int).SetBytes(n),
+				E: int(new(big.Int).SetBytes(e).Int64()),
+			}
+		case "oct":
+			k, err := base64.RawURLEncoding.DecodeString(key.K)
+			if err != nil {
+				return fmt.Errorf("key %q: invalid secret: %w", key.Kid, err)
+			}
+			v.hmacKeys[key.Kid] = k
+		default:
+			return fmt.Errorf("key %q: unsupported key type %q", key.Kid, key.Kty)
+		}
+	}
+
+	return nil
+}
+
+// Middleware authenticates requests with a bearer token. Browsers cannot set
+// headers on WebSocket upgrades, so the token is also accepted in the
+// "access_token" query parameter.
+func Middleware(v *Verifier) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			token := bearerToken(c)
+			if token == "" {
+				return echo.NewHTTPError(http.StatusUnauthorized, ErrMissingToken.Error())
+			}
+
+			userID, claims, err := v.Verify(token)
+			if err != nil {
+				return echo.NewHTTPError(http.StatusUnauthorized, "invalid token")
+			}
+
+			c.Set("user_id", userID)
+			c.Set("community_id", claims.CommunityID)
+			return next(c)
+		}
+	}
+}
+
+func bearerToken(c echo.Context) string {
+	header := c.Request().Header.Get("Authorization")
+	if len(header) > 7 && strings.EqualFold(header[:7], "Bearer ") {
+		return header[7:]
+	}
+	return c.QueryParam("access_token")
+}
+
+// DevMiddleware trusts the user_id (and optional community_id) query
+// parameters. It must only be enabled for local development.
+func DevMiddleware(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		userID, err := strconv.Atoi(c.QueryParam("user_id"))
+		if err != nil || userID <= 0 {
+			return echo.NewHTTPError(http.StatusUnauthorized, "user_id is required")
+		}
+
+		communityID, _ := strconv.Atoi(c.QueryParam("community_id"))
+
+		c.Set("user_id", userID)
+		c.Set("community_id", communityID)
+		return next(c)
+	}
+}
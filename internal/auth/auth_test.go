@@ -0,0 +1,252 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/labstack/echo"
+)
+
+var (
+	secret    = []byte("secret")
+	octSecret = []byte("oct-secret")
+)
+
+// newTestVerifier verifies HS256 tokens signed with secret, and through a
+// JWKS file RS256 tokens signed with rsaKey under kid "rsa" and HS256
+// tokens signed with octSecret under kid "oct".
+func newTestVerifier(t *testing.T, rsaKey *rsa.PrivateKey, opts Options) *Verifier {
+	t.Helper()
+
+	b64 := base64.RawURLEncoding.EncodeToString
+	jwks, err := json.Marshal(map[string]any{"keys": []jwk{
+		{Kty: "RSA", Kid: "rsa", N: b64(rsaKey.N.Bytes()), E: b64(big.NewInt(int64(rsaKey.E)).Bytes())},
+		{Kty: "oct", Kid: "oct", K: b64(octSecret)},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	opts.Secret = secret
+	opts.JWKSFile = filepath.Join(t.TempDir(), "jwks.json")
+	if err := os.WriteFile(opts.JWKSFile, jwks, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	v, err := NewVerifier(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return v
+}
+
+func sign(t *testing.T, method jwt.SigningMethod, kid string, key any, claims jwt.MapClaims) string {
+	t.Helper()
+
+	token := jwt.NewWithClaims(method, claims)
+	if kid != "" {
+		token.Header["kid"] = kid
+	}
+	signed, err := token.SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed
+}
+
+func TestVerify(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	valid := func() jwt.MapClaims {
+		return jwt.MapClaims{
+			"sub":          "7",
+			"exp":          time.Now().Add(time.Hour).Unix(),
+			"iss":          "issuer",
+			"aud":          "audience",
+			"community_id": 3,
+		}
+	}
+	with := func(key string, value any) jwt.MapClaims {
+		claims := valid()
+		if value == nil {
+			delete(claims, key)
+		} else {
+			claims[key] = value
+		}
+		return claims
+	}
+
+	tests := []struct {
+		name    string
+		token   func(t *testing.T) string
+		wantErr bool
+	}{
+		{
+			name:  "HS256 with the secret",
+			token: func(t *testing.T) string { return sign(t, jwt.SigningMethodHS256, "", secret, valid()) },
+		},
+		{
+			name:  "HS256 with a JWKS key",
+			token: func(t *testing.T) string { return sign(t, jwt.SigningMethodHS256, "oct", octSecret, valid()) },
+		},
+		{
+			name:  "RS256 with a JWKS key",
+			token: func(t *testing.T) string { return sign(t, jwt.SigningMethodRS256, "rsa", rsaKey, valid()) },
+		},
+		{
+			name:  "RS256 without a kid and a single key",
+			token: func(t *testing.T) string { return sign(t, jwt.SigningMethodRS256, "", rsaKey, valid()) },
+		},
+		{
+			name:    "wrong secret",
+			token:   func(t *testing.T) string { return sign(t, jwt.SigningMethodHS256, "", []byte("wrong"), valid()) },
+			wantErr: true,
+		},
+		{
+			name:    "secret under a JWKS kid",
+			token:   func(t *testing.T) string { return sign(t, jwt.SigningMethodHS256, "oct", secret, valid()) },
+			wantErr: true,
+		},
+		{
+			name:    "RS256 signed by another key",
+			token:   func(t *testing.T) string { return sign(t, jwt.SigningMethodRS256, "rsa", otherKey, valid()) },
+			wantErr: true,
+		},
+		{
+			name:    "unknown kid",
+			token:   func(t *testing.T) string { return sign(t, jwt.SigningMethodRS256, "other", rsaKey, valid()) },
+			wantErr: true,
+		},
+		{
+			name:    "HS384 is not accepted",
+			token:   func(t *testing.T) string { return sign(t, jwt.SigningMethodHS384, "", secret, valid()) },
+			wantErr: true,
+		},
+		{
+			name: "unsigned",
+			token: func(t *testing.T) string {
+				return sign(t, jwt.SigningMethodNone, "", jwt.UnsafeAllowNoneSignatureType, valid())
+			},
+			wantErr: true,
+		},
+		{
+			name: "expired",
+			token: func(t *testing.T) string {
+				return sign(t, jwt.SigningMethodHS256, "", secret, with("exp", time.Now().Add(-time.Minute).Unix()))
+			},
+			wantErr: true,
+		},
+		{
+			name:    "no expiry",
+			token:   func(t *testing.T) string { return sign(t, jwt.SigningMethodHS256, "", secret, with("exp", nil)) },
+			wantErr: true,
+		},
+		{
+			name:    "wrong issuer",
+			token:   func(t *testing.T) string { return sign(t, jwt.SigningMethodHS256, "", secret, with("iss", "other")) },
+			wantErr: true,
+		},
+		{
+			name:    "wrong audience",
+			token:   func(t *testing.T) string { return sign(t, jwt.SigningMethodHS256, "", secret, with("aud", "other")) },
+			wantErr: true,
+		},
+		{
+			name:    "subject is not a user id",
+			token:   func(t *testing.T) string { return sign(t, jwt.SigningMethodHS256, "", secret, with("sub", "alice")) },
+			wantErr: true,
+		},
+		{
+			name:    "malformed",
+			token:   func(t *testing.T) string { return "not.a.token" },
+			wantErr: true,
+		},
+	}
+
+	v := newTestVerifier(t, rsaKey, Options{Issuer: "issuer", Audience: "audience"})
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			userID, claims, err := v.Verify(tt.token(t))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Verify() error = %v, want error %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if userID != 7 || claims.CommunityID != 3 {
+				t.Fatalf("Verify() = user %d in community %d, want 7 in 3", userID, claims.CommunityID)
+			}
+		})
+	}
+}
+
+func TestNewVerifierRequiresAKey(t *testing.T) {
+	if _, err := NewVerifier(Options{}); err == nil {
+		t.Fatal("NewVerifier() without a secret or JWKS file succeeded")
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	v, err := NewVerifier(Options{Secret: secret})
+	if err != nil {
+		t.Fatal(err)
+	}
+	token := sign(t, jwt.SigningMethodHS256, "", secret, jwt.MapClaims{
+		"sub": "7",
+		"exp": time.Now().Add(time.Hour).Unix(),
+	})
+
+	tests := []struct {
+		name       string
+		header     string
+		query      string
+		wantStatus int
+	}{
+		{name: "bearer header", header: "Bearer " + token, wantStatus: http.StatusOK},
+		{name: "lowercase scheme", header: "bearer " + token, wantStatus: http.StatusOK},
+		{name: "access_token query", query: "?access_token=" + token, wantStatus: http.StatusOK},
+		{name: "missing", wantStatus: http.StatusUnauthorized},
+		{name: "invalid", header: "Bearer " + token + "x", wantStatus: http.StatusUnauthorized},
+		{name: "user_id is not trusted", query: "?user_id=7", wantStatus: http.StatusUnauthorized},
+	}
+
+	e := echo.New()
+	e.GET("/", func(c echo.Context) error {
+		if c.Get("user_id") != 7 {
+			return echo.NewHTTPError(http.StatusInternalServerError, "user_id not set")
+		}
+		return c.NoContent(http.StatusOK)
+	}, Middleware(v))
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/"+tt.query, nil)
+			if tt.header != "" {
+				req.Header.Set("Authorization", tt.header)
+			}
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+		})
+	}
+}
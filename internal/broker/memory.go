@@ -0,0 +1,43 @@
+package broker
+
+import (
+	"sync"
+	"ws-whatever/ws"
+)
+
+// Memory is an in-process Broker. Managers sharing one Memory behave like
+// separate nodes, which makes it useful for tests.
+type Memory struct {
+	mu       sync.RWMutex
+	handlers []func(ws.BrokerMessage)
+}
+
+func NewMemory() *Memory {
+	return &Memory{}
+}
+
+func (b *Memory) Publish(msg ws.BrokerMessage) error {
+	b.mu.RLock()
+	handlers := append([]func(ws.BrokerMessage){}, b.handlers...)
+	b.mu.RUnlock()
+
+	for _, handler := range handlers {
+		handler(msg)
+	}
+	return nil
+}
+
+func (b *Memory) Subscribe(handler func(ws.BrokerMessage)) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.handlers = append(b.handlers, handler)
+}
+
+func (b *Memory) Close() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.handlers = nil
+	return nil
+}
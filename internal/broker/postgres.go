@@ -0,0 +1,181 @@
+package broker
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"sync"
+	"time"
+	"ws-whatever/ws"
+
+	"github.com/jackc/pgx/v5"
+	"gorm.io/gorm"
+)
+
+const (
+	DefaultChannel = "room_events"
+
+	// Postgres rejects NOTIFY payloads of 8000 bytes or more. Larger
+	// messages are stored in broker_events and only their ID is sent.
+	maxNotifyPayload = 7900
+
+	// spilledRetention is how long spilled payloads are kept for listeners
+	// to fetch.
+	spilledRetention = time.Minute
+)
+
+// BrokerEvent holds a message too large to fit in a NOTIFY payload.
+type BrokerEvent struct {
+	ID        int64     `gorm:"primaryKey"`
+	Payload   string    `gorm:"type:text;not null"`
+	CreatedAt time.Time `gorm:"default:CURRENT_TIMESTAMP;index:idx_broker_events_created_at"`
+}
+
+type notification struct {
+	Ref     int64             `json:"ref,omitempty"`
+	Message *ws.BrokerMessage `json:"message,omitempty"`
+}
+
+// Postgres fans room events out between nodes with LISTEN/NOTIFY. Listening
+// needs its own connection, so it dials dsn directly; publishing goes
+// through the shared GORM pool.
+type Postgres struct {
+	db      *gorm.DB
+	dsn     string
+	channel string
+	logger  *slog.Logger
+
+	mu       sync.RWMutex
+	handlers []func(ws.BrokerMessage)
+
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+func NewPostgres(db *gorm.DB, dsn, channel string, logger *slog.Logger) *Postgres {
+	ctx, cancel := context.WithCancel(context.Background())
+	b := &Postgres{
+		db:      db,
+		dsn:     dsn,
+		channel: channel,
+		logger:  logger,
+		cancel:  cancel,
+		done:    make(chan struct{}),
+	}
+
+	go b.listen(ctx)
+	return b
+}
+
+func (b *Postgres) Publish(msg ws.BrokerMessage) error {
+	payload, err := json.Marshal(notification{Message: &msg})
+	if err != nil {
+		return err
+	}
+
+	if len(payload) > maxNotifyPayload {
+		event := BrokerEvent{Payload: string(payload)}
+		if err := b.db.Create(&event).Error; err != nil {
+			return err
+		}
+
+		if err := b.db.Where("created_at < ?", time.Now().Add(-spilledRetention)).Delete(&BrokerEvent{}).Error; err != nil {
+			b.logger.Warn("failed to prune broker events", "error", err)
+		}
+
+		payload, err = json.Marshal(notification{Ref: event.ID})
+		if err != nil {
+			return err
+		}
+	}
+
+	return b.db.Exec("SELECT pg_notify(?, ?)", b.channel, string(payload)).Error
+}
+
+func (b *Postgres) Subscribe(handler func(ws.BrokerMessage)) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.handlers = append(b.handlers, handler)
+}
+
+func (b *Postgres) Close() error {
+	b.cancel()
+	<-b.done
+	return nil
+}
+
+// listen keeps a LISTEN connection open, reconnecting with backoff. Events
+// published while it is reconnecting are not delivered to this node.
+func (b *Postgres) listen(ctx context.Context) {
+	defer close(b.done)
+
+	backoff := time.Second
+	for {
+		err := b.listenOnce(ctx, func() { backoff = time.Second })
+		if ctx.Err() != nil {
+			return
+		}
+
+		b.logger.Error("broker listener disconnected", "error", err, "retryIn", backoff)
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return
+		}
+		backoff = min(backoff*2, 30*time.Second)
+	}
+}
+
+func (b *Postgres) listenOnce(ctx context.Context, connected func()) error {
+	conn, err := pgx.Connect(ctx, b.dsn)
+	if err != nil {
+		return err
+	}
+	defer conn.Close(context.Background())
+
+	if _, err := conn.Exec(ctx, "LISTEN "+pgx.Identifier{b.channel}.Sanitize()); err != nil {
+		return err
+	}
+	connected()
+
+	for {
+		n, err := conn.WaitForNotification(ctx)
+		if err != nil {
+			return err
+		}
+		b.dispatch(n.Payload)
+	}
+}
+
+func (b *Postgres) dispatch(payload string) {
+	var n notification
+	if err := json.Unmarshal([]byte(payload), &n); err != nil {
+		b.logger.Warn("invalid broker notification", "error", err)
+		return
+	}
+
+	if n.Ref != 0 {
+		var event BrokerEvent
+		if err := b.db.First(&event, n.Ref).Error; err != nil {
+			b.logger.Error("failed to load spilled broker event", "error", err, "ref", n.Ref)
+			return
+		}
+		if err := json.Unmarshal([]byte(event.Payload), &n); err != nil {
+			b.logger.Warn("invalid spilled broker event", "error", err, "ref", n.Ref)
+			return
+		}
+	}
+
+	if n.Message == nil {
+		return
+	}
+
+	b.mu.RLock()
+	handlers := append([]func(ws.BrokerMessage){}, b.handlers...)
+	b.mu.RUnlock()
+
+	for _, handler := range handlers {
+		handler(*n.Message)
+	}
+}
@@ -0,0 +1,440 @@
+// Package config loads the server configuration. Values are layered, each
+// source overriding the previous one: built-in defaults, an optional YAML
+// file, environment variables and finally command line flags.
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"ws-whatever/internal/broker"
+	"ws-whatever/ws"
+
+	"gopkg.in/yaml.v3"
+)
+
+type Config struct {
+	Server    Server    `yaml:"server"`
+	Database  Database  `yaml:"database"`
+	Auth      Auth      `yaml:"auth"`
+	Storage   Storage   `yaml:"storage"`
+	Broker    Broker    `yaml:"broker"`
+	Log       Log       `yaml:"log"`
+	WebSocket ws.Config `yaml:"websocket"`
+}
+
+type Server struct {
+	Addr string `yaml:"addr"`
+	// DevAuth trusts the user_id query parameter instead of verifying
+	// tokens. Development only.
+	DevAuth bool `yaml:"dev_auth"`
+	// ShutdownTimeout bounds how long draining connections may take.
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout"`
+	// ReconnectAfter is the delay suggested to clients when draining.
+	ReconnectAfter time.Duration `yaml:"reconnect_after"`
+}
+
+type Database struct {
+	// Driver is postgres or sqlite.
+	Driver string `yaml:"driver"`
+	// Path is the SQLite database file, or :memory:.
+	Path     string `yaml:"path"`
+	Host     string `yaml:"host"`
+	Port     int    `yaml:"port"`
+	User     string `yaml:"user"`
+	Password string `yaml:"password"`
+	Name     string `yaml:"name"`
+	SSLMode  string `yaml:"sslmode"`
+	// IgnoreMigrationErrors keeps the server starting when migrations
+	// fail at startup, instead of exiting.
+	IgnoreMigrationErrors bool `yaml:"ignore_migration_errors"`
+}
+
+// DSN returns the connection string for the driver. Postgres gets a URL
+// so empty values and values with spaces or quotes survive parsing.
+func (d Database) DSN() string {
+	if d.Driver == "sqlite" {
+		return fmt.Sprintf("file:%v?_foreign_keys=on&_busy_timeout=5000", d.Path)
+	}
+
+	dsn := url.URL{
+		Scheme:   "postgres",
+		User:     url.UserPassword(d.User, d.Password),
+		Host:     net.JoinHostPort(d.Host, strconv.Itoa(d.Port)),
+		Path:     "/" + d.Name,
+		RawQuery: url.Values{"sslmode": {d.SSLMode}}.Encode(),
+	}
+	return dsn.String()
+}
+
+type Auth struct {
+	Secret   string `yaml:"secret"`
+	JWKSFile string `yaml:"jwks_file"`
+	Issuer   string `yaml:"issuer"`
+	Audience string `yaml:"audience"`
+}
+
+type Storage struct {
+	// Backend is local or gcs.
+	Backend string `yaml:"backend"`
+	Dir     string `yaml:"dir"`
+	// GCSEndpoint points gcs storage at an emulator. When empty, Cloud
+	// Storage is used with Application Default Credentials.
+	GCSEndpoint       string `yaml:"gcs_endpoint"`
+	GCSBucket         string `yaml:"gcs_bucket"`
+	MaxAttachmentSize int64  `yaml:"max_attachment_size"`
+	// UploadTTL is how long an upload may wait for a message to claim it
+	// before it is deleted.
+	UploadTTL time.Duration `yaml:"upload_ttl"`
+}
+
+type Broker struct {
+	// Backend is none or postgres.
+	Backend string `yaml:"backend"`
+	Channel string `yaml:"channel"`
+}
+
+type Log struct {
+	// Level is debug, info, warn or error.
+	Level string `yaml:"level"`
+	// Format is json or text.
+	Format string `yaml:"format"`
+}
+
+func Default() Config {
+	return Config{
+		Server: Server{
+			Addr:            ":6969",
+			ShutdownTimeout: 15 * time.Second,
+			ReconnectAfter:  2 * time.Second,
+		},
+		Database: Database{
+			Driver:  "postgres",
+			Path:    "messaging.db",
+			Host:    "localhost",
+			Port:    5432,
+			User:    "postgres",
+			Name:    "messaging",
+			SSLMode: "disable",
+		},
+		Storage: Storage{
+			Backend:           "local",
+			Dir:               "uploads",
+			MaxAttachmentSize: 10 << 20,
+			UploadTTL:         24 * time.Hour,
+		},
+		Broker: Broker{
+			Backend: "none",
+			Channel: broker.DefaultChannel,
+		},
+		Log: Log{
+			Level:  "info",
+			Format: "json",
+		},
+		WebSocket: ws.DefaultConfig(),
+	}
+}
+
+// Load builds the configuration from defaults, the YAML file named by
+// -config or CONFIG_FILE, the environment and the flags in args. The
+// arguments left after the flags are returned as the command to run.
+func Load(args []string) (Config, []string, error) {
+	cfg := Default()
+
+	fs := flag.NewFlagSet("ws-whatever", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: ws-whatever [flags] [migrate up | down [N] | status | create NAME]")
+		fs.PrintDefaults()
+	}
+	configFile := fs.String("config", os.Getenv("CONFIG_FILE"), "path to a YAML config file")
+	addr := fs.String("addr", "", "address to listen on")
+	devAuth := fs.Bool("dev-auth", false, "trust the user_id query parameter instead of verifying tokens (development only)")
+	logLevel := fs.String("log-level", "", "log level: debug, info, warn or error")
+	logFormat := fs.String("log-format", "", "log format: json or text")
+	dbDriver := fs.String("db-driver", "", "database driver: postgres or sqlite")
+	dbHost := fs.String("db-host", "", "database host")
+	dbName := fs.String("db-name", "", "database name")
+	ignoreMigrationErrors := fs.Bool("ignore-migration-errors", false, "keep starting when migrations fail")
+	if err := fs.Parse(args); err != nil {
+		return Config{}, nil, err
+	}
+
+	if *configFile != "" {
+		if err := loadFile(&cfg, *configFile); err != nil {
+			return Config{}, nil, err
+		}
+	}
+
+	if err := loadEnv(&cfg); err != nil {
+		return Config{}, nil, err
+	}
+
+	// Only flags given on the command line override the other sources.
+	fs.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "addr":
+			cfg.Server.Addr = *addr
+		case "dev-auth":
+			cfg.Server.DevAuth = *devAuth
+		case "log-level":
+			cfg.Log.Level = *logLevel
+		case "log-format":
+			cfg.Log.Format = *logFormat
+		case "db-driver":
+			cfg.Database.Driver = *dbDriver
+		case "db-host":
+			cfg.Database.Host = *dbHost
+		case "db-name":
+			cfg.Database.Name = *dbName
+		case "ignore-migration-errors":
+			cfg.Database.IgnoreMigrationErrors = *ignoreMigrationErrors
+		}
+	})
+
+	command := fs.Args()
+	validate := cfg.Validate
+	if len(command) > 0 && command[0] == "migrate" {
+		// Migrations only need the database.
+		validate = cfg.Database.Validate
+	}
+	if err := validate(); err != nil {
+		return Config{}, nil, fmt.Errorf("invalid config: %w", err)
+	}
+
+	return cfg, command, nil
+}
+
+func loadFile(cfg *Config, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to read config file: %w", err)
+	}
+	defer f.Close()
+
+	decoder := yaml.NewDecoder(f)
+	decoder.KnownFields(true)
+	if err := decoder.Decode(cfg); err != nil {
+		return fmt.Errorf("failed to parse config file %s: %w", path, err)
+	}
+
+	return nil
+}
+
+func loadEnv(cfg *Config) error {
+	vars := []struct {
+		key   string
+		apply func(string) error
+	}{
+		{"ADDR", setString(&cfg.Server.Addr)},
+		{"SHUTDOWN_TIMEOUT", setDuration(&cfg.Server.ShutdownTimeout)},
+		{"RECONNECT_AFTER", setDuration(&cfg.Server.ReconnectAfter)},
+
+		{"DB_DRIVER", setString(&cfg.Database.Driver)},
+		{"DB_PATH", setString(&cfg.Database.Path)},
+		{"DB_HOST", setString(&cfg.Database.Host)},
+		{"DB_PORT", setInt(&cfg.Database.Port)},
+		{"DB_USER", setString(&cfg.Database.User)},
+		{"DB_PASSWORD", setString(&cfg.Database.Password)},
+		{"DB_NAME", setString(&cfg.Database.Name)},
+		{"DB_SSLMODE", setString(&cfg.Database.SSLMode)},
+		{"DB_IGNORE_MIGRATION_ERRORS", setBool(&cfg.Database.IgnoreMigrationErrors)},
+
+		{"AUTH_SECRET", setString(&cfg.Auth.Secret)},
+		{"AUTH_JWKS_FILE", setString(&cfg.Auth.JWKSFile)},
+		{"AUTH_ISSUER", setString(&cfg.Auth.Issuer)},
+		{"AUTH_AUDIENCE", setString(&cfg.Auth.Audience)},
+
+		{"STORAGE_BACKEND", setString(&cfg.Storage.Backend)},
+		{"STORAGE_DIR", setString(&cfg.Storage.Dir)},
+		{"GCS_ENDPOINT", setString(&cfg.Storage.GCSEndpoint)},
+		{"GCS_BUCKET", setString(&cfg.Storage.GCSBucket)},
+		{"MAX_ATTACHMENT_SIZE", setInt64(&cfg.Storage.MaxAttachmentSize)},
+		{"UPLOAD_TTL", setDuration(&cfg.Storage.UploadTTL)},
+
+		{"BROKER", setString(&cfg.Broker.Backend)},
+		{"BROKER_CHANNEL", setString(&cfg.Broker.Channel)},
+
+		{"LOG_LEVEL", setString(&cfg.Log.Level)},
+		{"LOG_FORMAT", setString(&cfg.Log.Format)},
+
+		{"WS_SEND_BUFFER_SIZE", setInt(&cfg.WebSocket.SendBufferSize)},
+		{"WS_READ_LIMIT", setInt64(&cfg.WebSocket.ReadLimit)},
+		{"WS_PONG_WAIT", setDuration(&cfg.WebSocket.PongWait)},
+		{"WS_PING_INTERVAL", setDuration(&cfg.WebSocket.PingInterval)},
+		{"WS_WRITE_WAIT", setDuration(&cfg.WebSocket.WriteWait)},
+		{"WS_HISTORY_LIMIT", setInt(&cfg.WebSocket.HistoryLimit)},
+		{"WS_MAX_HISTORY_LIMIT", setInt(&cfg.WebSocket.MaxHistoryLimit)},
+		{"WS_RATE_LIMITS", func(v string) error {
+			limits, err := ws.ParseRateLimits(cfg.WebSocket.RateLimits, v)
+			cfg.WebSocket.RateLimits = limits
+			return err
+		}},
+		{"WS_SLOW_CONSUMER_POLICY", func(v string) error {
+			cfg.WebSocket.SlowConsumerPolicy = ws.SlowConsumerPolicy(v)
+			return nil
+		}},
+		{"WS_OVERFLOW_LIMIT", setInt(&cfg.WebSocket.OverflowLimit)},
+	}
+
+	var errs []error
+	for _, v := range vars {
+		value, ok := os.LookupEnv(v.key)
+		if !ok || value == "" {
+			continue
+		}
+		if err := v.apply(value); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", v.key, err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+func setString(field *string) func(string) error {
+	return func(v string) error {
+		*field = v
+		return nil
+	}
+}
+
+func setBool(field *bool) func(string) error {
+	return func(v string) error {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return err
+		}
+		*field = b
+		return nil
+	}
+}
+
+func setInt(field *int) func(string) error {
+	return func(v string) error {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return err
+		}
+		*field = n
+		return nil
+	}
+}
+
+func setInt64(field *int64) func(string) error {
+	return func(v string) error {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return err
+		}
+		*field = n
+		return nil
+	}
+}
+
+func setDuration(field *time.Duration) func(string) error {
+	return func(v string) error {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return err
+		}
+		*field = d
+		return nil
+	}
+}
+
+func (c Config) Validate() error {
+	var errs []error
+
+	if c.Server.Addr == "" {
+		errs = append(errs, errors.New("server.addr is required"))
+	}
+	if c.Server.ShutdownTimeout <= 0 {
+		errs = append(errs, errors.New("server.shutdown_timeout must be positive"))
+	}
+	if c.Server.ReconnectAfter < 0 {
+		errs = append(errs, errors.New("server.reconnect_after cannot be negative"))
+	}
+
+	if err := c.Database.Validate(); err != nil {
+		errs = append(errs, err)
+	}
+
+	if !c.Server.DevAuth && c.Auth.Secret == "" && c.Auth.JWKSFile == "" {
+		errs = append(errs, errors.New("auth needs a secret or a JWKS file unless dev auth is enabled"))
+	}
+
+	switch c.Storage.Backend {
+	case "local":
+		if c.Storage.Dir == "" {
+			errs = append(errs, errors.New("storage.dir is required for local storage"))
+		}
+	case "gcs":
+		if c.Storage.GCSBucket == "" {
+			errs = append(errs, errors.New("storage.gcs_bucket is required for gcs storage"))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("unknown storage backend %q", c.Storage.Backend))
+	}
+	if c.Storage.MaxAttachmentSize <= 0 {
+		errs = append(errs, errors.New("storage.max_attachment_size must be positive"))
+	}
+	if c.Storage.UploadTTL <= 0 {
+		errs = append(errs, errors.New("storage.upload_ttl must be positive"))
+	}
+
+	switch c.Broker.Backend {
+	case "none":
+	case "postgres":
+		if c.Broker.Channel == "" {
+			errs = append(errs, errors.New("broker.channel is required for the postgres broker"))
+		}
+		if c.Database.Driver != "postgres" {
+			errs = append(errs, errors.New("the postgres broker needs the postgres database driver"))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("unknown broker %q", c.Broker.Backend))
+	}
+
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(c.Log.Level)); err != nil {
+		errs = append(errs, fmt.Errorf("invalid log level %q", c.Log.Level))
+	}
+	if f := strings.ToLower(c.Log.Format); f != "json" && f != "text" {
+		errs = append(errs, fmt.Errorf("invalid log format %q", c.Log.Format))
+	}
+
+	if err := c.WebSocket.Validate(); err != nil {
+		errs = append(errs, fmt.Errorf("websocket: %w", err))
+	}
+
+	return errors.Join(errs...)
+}
+
+func (d Database) Validate() error {
+	var errs []error
+
+	switch d.Driver {
+	case "postgres":
+		if d.Host == "" || d.User == "" || d.Name == "" {
+			errs = append(errs, errors.New("database host, user and name are required"))
+		}
+		if d.Port <= 0 {
+			errs = append(errs, errors.New("database.port must be positive"))
+		}
+	case "sqlite":
+		if d.Path == "" {
+			errs = append(errs, errors.New("database.path is required for sqlite"))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("unknown database driver %q", d.Driver))
+	}
+
+	return errors.Join(errs...)
+}
@@ -0,0 +1,214 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+func writeFile(t *testing.T, content string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoad(t *testing.T) {
+	file := writeFile(t, `
+server:
+  addr: ":7000"
+  shutdown_timeout: 5s
+database:
+  host: file-host
+  name: file-name
+auth:
+  secret: file-secret
+websocket:
+  history_limit: 20
+`)
+
+	tests := []struct {
+		name        string
+		env         map[string]string
+		args        []string
+		check       func(t *testing.T, cfg Config)
+		wantCommand []string
+		wantErr     string
+	}{
+		{
+			name: "defaults",
+			env:  map[string]string{"AUTH_SECRET": "secret"},
+			check: func(t *testing.T, cfg Config) {
+				if cfg.Server.Addr != ":6969" || cfg.Database.Port != 5432 || cfg.WebSocket.HistoryLimit != 50 {
+					t.Fatalf("defaults not applied: %+v", cfg)
+				}
+			},
+		},
+		{
+			name: "file over defaults",
+			args: []string{"-config", file},
+			check: func(t *testing.T, cfg Config) {
+				if cfg.Server.Addr != ":7000" || cfg.Server.ShutdownTimeout != 5*time.Second || cfg.WebSocket.HistoryLimit != 20 {
+					t.Fatalf("file values not applied: %+v", cfg)
+				}
+				// Fields the file leaves out keep their defaults.
+				if cfg.Database.Port != 5432 || cfg.Server.ReconnectAfter != 2*time.Second {
+					t.Fatalf("defaults lost: %+v", cfg)
+				}
+			},
+		},
+		{
+			name: "file named by CONFIG_FILE",
+			env:  map[string]string{"CONFIG_FILE": file},
+			check: func(t *testing.T, cfg Config) {
+				if cfg.Server.Addr != ":7000" {
+					t.Fatalf("addr = %q, want the file's", cfg.Server.Addr)
+				}
+			},
+		},
+		{
+			name: "env over file",
+			env:  map[string]string{"ADDR": ":8000", "DB_HOST": "env-host", "WS_HISTORY_LIMIT": "30"},
+			args: []string{"-config", file},
+			check: func(t *testing.T, cfg Config) {
+				if cfg.Server.Addr != ":8000" || cfg.Database.Host != "env-host" || cfg.WebSocket.HistoryLimit != 30 {
+					t.Fatalf("env values not applied: %+v", cfg)
+				}
+				if cfg.Database.Name != "file-name" {
+					t.Fatalf("database name = %q, want the file's", cfg.Database.Name)
+				}
+			},
+		},
+		{
+			name: "empty env is ignored",
+			env:  map[string]string{"ADDR": ""},
+			args: []string{"-config", file},
+			check: func(t *testing.T, cfg Config) {
+				if cfg.Server.Addr != ":7000" {
+					t.Fatalf("addr = %q, want the file's", cfg.Server.Addr)
+				}
+			},
+		},
+		{
+			name: "flags over env",
+			env:  map[string]string{"ADDR": ":8000", "DB_HOST": "env-host"},
+			args: []string{"-config", file, "-addr", ":9000", "-log-level", "debug"},
+			check: func(t *testing.T, cfg Config) {
+				if cfg.Server.Addr != ":9000" || cfg.Log.Level != "debug" {
+					t.Fatalf("flags not applied: %+v", cfg)
+				}
+				// Flags left out do not reset what env set.
+				if cfg.Database.Host != "env-host" {
+					t.Fatalf("database host = %q, want the env's", cfg.Database.Host)
+				}
+			},
+		},
+		{
+			name: "dev auth needs no secret",
+			args: []string{"-dev-auth"},
+			check: func(t *testing.T, cfg Config) {
+				if !cfg.Server.DevAuth {
+					t.Fatal("dev auth not enabled")
+				}
+			},
+		},
+		{
+			name:        "migrate only needs the database",
+			args:        []string{"migrate", "up"},
+			wantCommand: []string{"migrate", "up"},
+		},
+		{
+			name:    "no auth",
+			wantErr: "auth needs a secret",
+		},
+		{
+			name:    "unknown file field",
+			args:    []string{"-config", writeFile(t, "server:\n  port: 1\n")},
+			wantErr: "field port not found",
+		},
+		{
+			name:    "invalid env value",
+			env:     map[string]string{"AUTH_SECRET": "secret", "DB_PORT": "five"},
+			wantErr: "DB_PORT",
+		},
+		{
+			name:    "invalid value",
+			env:     map[string]string{"AUTH_SECRET": "secret", "BROKER": "postgres"},
+			args:    []string{"-db-driver", "sqlite"},
+			wantErr: "the postgres broker needs the postgres database driver",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, key := range []string{"CONFIG_FILE", "ADDR", "DB_HOST", "DB_PORT", "AUTH_SECRET", "BROKER", "WS_HISTORY_LIMIT"} {
+				t.Setenv(key, tt.env[key])
+			}
+
+			cfg, command, err := Load(tt.args)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Load() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Load() error = %v", err)
+			}
+			if strings.Join(command, " ") != strings.Join(tt.wantCommand, " ") {
+				t.Fatalf("command = %q, want %q", command, tt.wantCommand)
+			}
+			if tt.check != nil {
+				tt.check(t, cfg)
+			}
+		})
+	}
+}
+
+func TestDSN(t *testing.T) {
+	tests := []struct {
+		name     string
+		database Database
+	}{
+		{name: "defaults", database: Default().Database},
+		{
+			name: "empty password",
+			database: Database{
+				Driver: "postgres", Host: "db", Port: 5432, User: "app", Name: "chat", SSLMode: "require",
+			},
+		},
+		{
+			name: "spaces and quotes",
+			database: Database{
+				Driver: "postgres", Host: "db", Port: 6543, User: "app user", Password: `p@ss 'word" /?`, Name: "my db", SSLMode: "disable",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := tt.database
+			parsed, err := pgconn.ParseConfig(d.DSN())
+			if err != nil {
+				t.Fatalf("ParseConfig(%q) error = %v", d.DSN(), err)
+			}
+			if parsed.Host != d.Host || int(parsed.Port) != d.Port || parsed.User != d.User ||
+				parsed.Password != d.Password || parsed.Database != d.Name {
+				t.Fatalf("DSN %q parsed as %s:%d user %q password %q database %q",
+					d.DSN(), parsed.Host, parsed.Port, parsed.User, parsed.Password, parsed.Database)
+			}
+		})
+	}
+
+	sqlite := Database{Driver: "sqlite", Path: "/tmp/chat.db"}
+	if got, want := sqlite.DSN(), "file:/tmp/chat.db?_foreign_keys=on&_busy_timeout=5000"; got != want {
+		t.Fatalf("sqlite DSN = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,164 @@
+package db
+
+import (
+	"slices"
+	"time"
+
+	"github.com/go-gormigrate/gormigrate/v2"
+	"gorm.io/gorm"
+	"gorm.io/gorm/schema"
+)
+
+// initRoomType and the init structs below are the schema as it was before
+// versioned migrations.
+type initRoomType string
+
+func (initRoomType) GormDBDataType(db *gorm.DB, field *schema.Field) string {
+	if db.Dialector.Name() == "postgres" {
+		return "room_type"
+	}
+	return "text"
+}
+
+type initCommunity struct {
+	ID int `gorm:"primaryKey"`
+}
+
+func (initCommunity) TableName() string { return "communities" }
+
+type initUser struct {
+	ID int `gorm:"primaryKey"`
+}
+
+func (initUser) TableName() string { return "users" }
+
+type initRoom struct {
+	ID          int          `gorm:"primaryKey"`
+	Name        string       `gorm:"type:text"`
+	CommunityID int          `gorm:"not null;index:idx_rooms_event"`
+	Type        initRoomType `gorm:"not null"`
+	CreatedAt   time.Time
+	Community   initCommunity `gorm:"foreignKey:CommunityID"`
+}
+
+func (initRoom) TableName() string { return "rooms" }
+
+type initRoomParticipant struct {
+	ID       int       `gorm:"primaryKey"`
+	RoomID   int       `gorm:"not null;uniqueIndex:idx_room_participants_room_user;index:idx_room_participants_room"`
+	UserID   int       `gorm:"not null;uniqueIndex:idx_room_participants_room_user;index:idx_room_participants_user"`
+	Role     string    `gorm:"type:varchar(50);not null"`
+	JoinedAt time.Time `gorm:"default:CURRENT_TIMESTAMP"`
+	Room     initRoom  `gorm:"foreignKey:RoomID"`
+	User     initUser  `gorm:"foreignKey:UserID"`
+}
+
+func (initRoomParticipant) TableName() string { return "room_participants" }
+
+type initMessage struct {
+	ID        int       `gorm:"primaryKey"`
+	RoomID    int       `gorm:"not null;index:idx_messages_room_created_at"`
+	SenderID  int       `gorm:"not null"`
+	Content   string    `gorm:"type:text;not null"`
+	ReplyToID *int      `gorm:"index:idx_messages_reply_to_id"`
+	IsPinned  bool      `gorm:"default:false;index:idx_messages_room_pinned"`
+	IsEdited  bool      `gorm:"default:false"`
+	CreatedAt time.Time `gorm:"index:idx_messages_room_created_at"`
+	UpdatedAt *time.Time
+	DeletedAt *time.Time
+	Room      initRoom     `gorm:"foreignKey:RoomID"`
+	Sender    initUser     `gorm:"foreignKey:SenderID"`
+	ReplyTo   *initMessage `gorm:"foreignKey:ReplyToID"`
+}
+
+func (initMessage) TableName() string { return "messages" }
+
+type initMessageAttachment struct {
+	ID        int    `gorm:"primaryKey"`
+	MessageID int    `gorm:"not null"`
+	FilePath  string `gorm:"type:text;not null"`
+	FileType  string `gorm:"type:text;not null"`
+	FileSize  int
+	FileMime  string `gorm:"type:text;not null"`
+	CreatedAt time.Time
+	Message   initMessage `gorm:"foreignKey:MessageID"`
+}
+
+func (initMessageAttachment) TableName() string { return "message_attachments" }
+
+type initMessageReaction struct {
+	ID           int    `gorm:"primaryKey"`
+	MessageID    int    `gorm:"not null;index:idx_message_reactions_message;uniqueIndex:uniq_message_reactions_user_type"`
+	UserID       int    `gorm:"not null;uniqueIndex:idx_message_reactions_message_user;uniqueIndex:uniq_message_reactions_user_type"`
+	ReactionType string `gorm:"type:varchar(50);not null;uniqueIndex:uniq_message_reactions_user_type"`
+	CreatedAt    time.Time
+	Message      initMessage `gorm:"foreignKey:MessageID"`
+	User         initUser    `gorm:"foreignKey:UserID"`
+}
+
+func (initMessageReaction) TableName() string { return "message_reactions" }
+
+type initMessageRead struct {
+	ID        int `gorm:"primaryKey"`
+	MessageID int `gorm:"not null;uniqueIndex:idx_message_reads_message_user"`
+	UserID    int `gorm:"not null;uniqueIndex:idx_message_reads_message_user;index:idx_message_reads_user"`
+	ReadAt    *time.Time
+	Message   initMessage `gorm:"foreignKey:MessageID"`
+	User      initUser    `gorm:"foreignKey:UserID"`
+}
+
+func (initMessageRead) TableName() string { return "message_reads" }
+
+// initSchemaModels are created by 0_0_1, parents before children.
+var initSchemaModels = []any{
+	&initCommunity{},
+	&initUser{},
+	&initRoom{},
+	&initRoomParticipant{},
+	&initMessage{},
+	&initMessageAttachment{},
+	&initMessageReaction{},
+	&initMessageRead{},
+}
+
+func init() {
+	migrations = append(migrations, &gormigrate.Migration{
+		// Databases set up before versioned migrations already have this
+		// schema, so everything here is safe to run again.
+		ID: "20250930225731_0_0_1__init_schema",
+		Migrate: func(tx *gorm.DB) error {
+			if tx.Dialector.Name() == "postgres" {
+				var exists bool
+				if err := tx.Raw("SELECT EXISTS (SELECT 1 FROM pg_type WHERE typname = 'room_type')").Scan(&exists).Error; err != nil {
+					return err
+				}
+				if !exists {
+					if err := tx.Exec("CREATE TYPE room_type AS ENUM ('group', 'direct')").Error; err != nil {
+						return err
+					}
+				}
+			}
+
+			if err := tx.Migrator().AutoMigrate(initSchemaModels...); err != nil {
+				return err
+			}
+
+			if err := tx.FirstOrCreate(&initCommunity{ID: 1}).Error; err != nil {
+				return err
+			}
+			return tx.FirstOrCreate(&initUser{ID: 1}).Error
+		},
+		Rollback: func(tx *gorm.DB) error {
+			models := slices.Clone(initSchemaModels)
+			slices.Reverse(models)
+			if err := tx.Migrator().DropTable(models...); err != nil {
+				return err
+			}
+
+			if tx.Dialector.Name() == "postgres" {
+				return tx.Exec("DROP TYPE IF EXISTS room_type").Error
+			}
+			return nil
+		},
+	})
+}
@@ -0,0 +1,31 @@
+package db
+
+import (
+	"time"
+
+	"github.com/go-gormigrate/gormigrate/v2"
+	"gorm.io/gorm"
+)
+
+type messageRevision struct {
+	ID        int    `gorm:"primaryKey"`
+	MessageID int    `gorm:"not null;index:idx_message_revisions_message"`
+	Content   string `gorm:"type:text;not null"`
+	EditedBy  int    `gorm:"not null"`
+	CreatedAt time.Time
+	Message   messageKey `gorm:"foreignKey:MessageID"`
+}
+
+func (messageRevision) TableName() string { return "message_revisions" }
+
+func init() {
+	migrations = append(migrations, &gormigrate.Migration{
+		ID: "20261018033736_0_0_2__message_revisions",
+		Migrate: func(tx *gorm.DB) error {
+			return tx.Migrator().AutoMigrate(&messageRevision{})
+		},
+		Rollback: func(tx *gorm.DB) error {
+			return tx.Migrator().DropTable(&messageRevision{})
+		},
+	})
+}
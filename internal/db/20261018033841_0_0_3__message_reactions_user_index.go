@@ -0,0 +1,33 @@
+package db
+
+import (
+	"github.com/go-gormigrate/gormigrate/v2"
+	"gorm.io/gorm"
+)
+
+type reactionUserIndex struct {
+	MessageID int `gorm:"index:idx_message_reactions_message_user"`
+	UserID    int `gorm:"index:idx_message_reactions_message_user"`
+}
+
+func (reactionUserIndex) TableName() string { return "message_reactions" }
+
+func init() {
+	migrations = append(migrations, &gormigrate.Migration{
+		// idx_message_reactions_message_user was created as a unique index on
+		// user_id alone, which limited every user to a single reaction.
+		ID: "20261018033841_0_0_3__message_reactions_user_index",
+		Migrate: func(tx *gorm.DB) error {
+			migrator := tx.Migrator()
+			if migrator.HasIndex(&reactionUserIndex{}, "idx_message_reactions_message_user") {
+				if err := migrator.DropIndex(&reactionUserIndex{}, "idx_message_reactions_message_user"); err != nil {
+					return err
+				}
+			}
+			return migrator.CreateIndex(&reactionUserIndex{}, "idx_message_reactions_message_user")
+		},
+		Rollback: func(tx *gorm.DB) error {
+			return tx.Migrator().DropIndex(&reactionUserIndex{}, "idx_message_reactions_message_user")
+		},
+	})
+}
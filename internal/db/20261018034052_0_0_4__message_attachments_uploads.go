@@ -0,0 +1,123 @@
+package db
+
+import (
+	"path"
+	"time"
+
+	"github.com/go-gormigrate/gormigrate/v2"
+	"gorm.io/gorm"
+)
+
+type uploadedAttachment struct {
+	ID         int    `gorm:"primaryKey"`
+	MessageID  *int   `gorm:"index:idx_message_attachments_message"`
+	RoomID     int    `gorm:"not null;index:idx_message_attachments_room"`
+	UploaderID int    `gorm:"not null"`
+	FileName   string `gorm:"type:text;not null"`
+	FilePath   string `gorm:"type:text;not null"`
+	FileType   string `gorm:"type:text;not null"`
+	FileSize   int
+	FileMime   string `gorm:"type:text;not null"`
+	CreatedAt  time.Time
+	Message    *messageKey `gorm:"foreignKey:MessageID"`
+}
+
+func (uploadedAttachment) TableName() string { return "message_attachments" }
+
+// attachmentWithMessage is message_attachments before 0_0_4, when every
+// attachment belonged to a message.
+type attachmentWithMessage struct {
+	MessageID int `gorm:"not null"`
+}
+
+func (attachmentWithMessage) TableName() string { return "message_attachments" }
+
+// attachmentUpload is message_attachments while 0_0_4 fills in the columns
+// it adds, before they become NOT NULL.
+type attachmentUpload struct {
+	ID         int
+	RoomID     *int
+	UploaderID *int
+	FileName   *string `gorm:"type:text"`
+	FilePath   string
+}
+
+func (attachmentUpload) TableName() string { return "message_attachments" }
+
+func init() {
+	migrations = append(migrations, &gormigrate.Migration{
+		// Attachments are uploaded before the message that references them
+		// exists, so message_id becomes nullable and the room and uploader
+		// are recorded instead.
+		ID: "20261018034052_0_0_4__message_attachments_uploads",
+		Migrate: func(tx *gorm.DB) error {
+			if err := backfillAttachmentUploads(tx); err != nil {
+				return err
+			}
+			return tx.Migrator().AutoMigrate(&uploadedAttachment{})
+		},
+		Rollback: func(tx *gorm.DB) error {
+			migrator := tx.Migrator()
+			for _, column := range []string{"RoomID", "UploaderID", "FileName"} {
+				if err := migrator.DropColumn(&uploadedAttachment{}, column); err != nil {
+					return err
+				}
+			}
+			return migrator.AlterColumn(&attachmentWithMessage{}, "MessageID")
+		},
+	})
+}
+
+// backfillAttachmentUploads adds the room, uploader and file name of
+// attachments, fills them in from the message each attachment belongs to
+// and only then makes them NOT NULL, which AutoMigrate never does.
+func backfillAttachmentUploads(tx *gorm.DB) error {
+	migrator := tx.Migrator()
+	for _, column := range []string{"RoomID", "UploaderID", "FileName"} {
+		if migrator.HasColumn(&attachmentUpload{}, column) {
+			continue
+		}
+		if err := migrator.AddColumn(&attachmentUpload{}, column); err != nil {
+			return err
+		}
+	}
+
+	err := tx.Exec(`UPDATE message_attachments SET
+		room_id = (SELECT messages.room_id FROM messages WHERE messages.id = message_attachments.message_id),
+		uploader_id = (SELECT messages.sender_id FROM messages WHERE messages.id = message_attachments.message_id)
+		WHERE room_id IS NULL OR uploader_id IS NULL`).Error
+	if err != nil {
+		return err
+	}
+
+	// The original name was never stored, so the stored file's name stands
+	// in for it.
+	var unnamed []attachmentUpload
+	err = tx.Where("file_name IS NULL").FindInBatches(&unnamed, 500, func(*gorm.DB, int) error {
+		for i := range unnamed {
+			if err := tx.Model(&unnamed[i]).Update("file_name", path.Base(unnamed[i].FilePath)).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	}).Error
+	if err != nil {
+		return err
+	}
+
+	columnTypes, err := migrator.ColumnTypes(&attachmentUpload{})
+	if err != nil {
+		return err
+	}
+	for _, column := range columnTypes {
+		switch column.Name() {
+		case "room_id", "uploader_id", "file_name":
+			if nullable, ok := column.Nullable(); ok && nullable {
+				if err := migrator.AlterColumn(&uploadedAttachment{}, column.Name()); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,27 @@
+package db
+
+import (
+	"github.com/go-gormigrate/gormigrate/v2"
+	"gorm.io/gorm"
+)
+
+type privateRoom struct {
+	IsPrivate bool `gorm:"default:false"`
+}
+
+func (privateRoom) TableName() string { return "rooms" }
+
+func init() {
+	migrations = append(migrations, &gormigrate.Migration{
+		ID: "20261018034502_0_0_5__private_rooms",
+		Migrate: func(tx *gorm.DB) error {
+			if tx.Migrator().HasColumn(&privateRoom{}, "IsPrivate") {
+				return nil
+			}
+			return tx.Migrator().AddColumn(&privateRoom{}, "IsPrivate")
+		},
+		Rollback: func(tx *gorm.DB) error {
+			return tx.Migrator().DropColumn(&privateRoom{}, "IsPrivate")
+		},
+	})
+}
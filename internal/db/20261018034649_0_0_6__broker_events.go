@@ -0,0 +1,28 @@
+package db
+
+import (
+	"time"
+
+	"github.com/go-gormigrate/gormigrate/v2"
+	"gorm.io/gorm"
+)
+
+type brokerEvent struct {
+	ID        int64     `gorm:"primaryKey"`
+	Payload   string    `gorm:"type:text;not null"`
+	CreatedAt time.Time `gorm:"default:CURRENT_TIMESTAMP;index:idx_broker_events_created_at"`
+}
+
+func (brokerEvent) TableName() string { return "broker_events" }
+
+func init() {
+	migrations = append(migrations, &gormigrate.Migration{
+		ID: "20261018034649_0_0_6__broker_events",
+		Migrate: func(tx *gorm.DB) error {
+			return tx.Migrator().AutoMigrate(&brokerEvent{})
+		},
+		Rollback: func(tx *gorm.DB) error {
+			return tx.Migrator().DropTable(&brokerEvent{})
+		},
+	})
+}
@@ -0,0 +1,46 @@
+package db
+
+import (
+	"time"
+
+	"github.com/go-gormigrate/gormigrate/v2"
+	"gorm.io/gorm"
+)
+
+type sequencedRoom struct {
+	LastSeq int64 `gorm:"not null;default:0"`
+}
+
+func (sequencedRoom) TableName() string { return "rooms" }
+
+type roomEvent struct {
+	ID        int       `gorm:"primaryKey"`
+	RoomID    int       `gorm:"not null;uniqueIndex:idx_room_events_room_seq"`
+	Seq       int64     `gorm:"not null;uniqueIndex:idx_room_events_room_seq"`
+	Type      string    `gorm:"type:varchar(50);not null"`
+	Data      string    `gorm:"type:text;not null"`
+	CreatedAt time.Time `gorm:"index:idx_room_events_created_at"`
+	Room      roomKey   `gorm:"foreignKey:RoomID"`
+}
+
+func (roomEvent) TableName() string { return "room_events" }
+
+func init() {
+	migrations = append(migrations, &gormigrate.Migration{
+		ID: "20261018035354_0_0_7__room_events",
+		Migrate: func(tx *gorm.DB) error {
+			if !tx.Migrator().HasColumn(&sequencedRoom{}, "LastSeq") {
+				if err := tx.Migrator().AddColumn(&sequencedRoom{}, "LastSeq"); err != nil {
+					return err
+				}
+			}
+			return tx.Migrator().AutoMigrate(&roomEvent{})
+		},
+		Rollback: func(tx *gorm.DB) error {
+			if err := tx.Migrator().DropTable(&roomEvent{}); err != nil {
+				return err
+			}
+			return tx.Migrator().DropColumn(&sequencedRoom{}, "LastSeq")
+		},
+	})
+}
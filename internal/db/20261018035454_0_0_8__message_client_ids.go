@@ -0,0 +1,37 @@
+package db
+
+import (
+	"github.com/go-gormigrate/gormigrate/v2"
+	"gorm.io/gorm"
+)
+
+type clientMessageID struct {
+	SenderID    int     `gorm:"not null;uniqueIndex:idx_messages_sender_client_msg_id"`
+	ClientMsgID *string `gorm:"type:varchar(64);uniqueIndex:idx_messages_sender_client_msg_id"`
+}
+
+func (clientMessageID) TableName() string { return "messages" }
+
+func init() {
+	migrations = append(migrations, &gormigrate.Migration{
+		ID: "20261018035454_0_0_8__message_client_ids",
+		Migrate: func(tx *gorm.DB) error {
+			migrator := tx.Migrator()
+			if !migrator.HasColumn(&clientMessageID{}, "ClientMsgID") {
+				if err := migrator.AddColumn(&clientMessageID{}, "ClientMsgID"); err != nil {
+					return err
+				}
+			}
+			if migrator.HasIndex(&clientMessageID{}, "idx_messages_sender_client_msg_id") {
+				return nil
+			}
+			return migrator.CreateIndex(&clientMessageID{}, "idx_messages_sender_client_msg_id")
+		},
+		Rollback: func(tx *gorm.DB) error {
+			if err := tx.Migrator().DropIndex(&clientMessageID{}, "idx_messages_sender_client_msg_id"); err != nil {
+				return err
+			}
+			return tx.Migrator().DropColumn(&clientMessageID{}, "ClientMsgID")
+		},
+	})
+}
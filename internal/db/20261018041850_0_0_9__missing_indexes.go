@@ -0,0 +1,64 @@
+package db
+
+import (
+	"github.com/go-gormigrate/gormigrate/v2"
+	"gorm.io/gorm"
+)
+
+type communityRoomIndex struct {
+	CommunityID int `gorm:"index:idx_rooms_community"`
+}
+
+func (communityRoomIndex) TableName() string { return "rooms" }
+
+type pinnedMessageIndex struct {
+	RoomID   int  `gorm:"index:idx_messages_room_pinned"`
+	IsPinned bool `gorm:"index:idx_messages_room_pinned"`
+}
+
+func (pinnedMessageIndex) TableName() string { return "messages" }
+
+func init() {
+	migrations = append(migrations, &gormigrate.Migration{
+		// Brings the indexes in line with db.sql: the community index on
+		// rooms was named idx_rooms_event, and idx_messages_room_pinned
+		// left out room_id.
+		ID: "20261018041850_0_0_9__missing_indexes",
+		Migrate: func(tx *gorm.DB) error {
+			migrator := tx.Migrator()
+			for _, index := range []struct {
+				model any
+				name  string
+			}{
+				{&communityRoomIndex{}, "idx_rooms_event"},
+				{&pinnedMessageIndex{}, "idx_messages_room_pinned"},
+			} {
+				if migrator.HasIndex(index.model, index.name) {
+					if err := migrator.DropIndex(index.model, index.name); err != nil {
+						return err
+					}
+				}
+			}
+
+			if !migrator.HasIndex(&communityRoomIndex{}, "idx_rooms_community") {
+				if err := migrator.CreateIndex(&communityRoomIndex{}, "idx_rooms_community"); err != nil {
+					return err
+				}
+			}
+			return migrator.CreateIndex(&pinnedMessageIndex{}, "idx_messages_room_pinned")
+		},
+		Rollback: func(tx *gorm.DB) error {
+			for _, stmt := range []string{
+				"DROP INDEX IF EXISTS idx_rooms_community",
+				"DROP INDEX IF EXISTS idx_messages_room_pinned",
+				"CREATE INDEX idx_rooms_event ON rooms (community_id)",
+				"CREATE INDEX idx_messages_room_pinned ON messages (is_pinned)",
+			} {
+				if err := tx.Exec(stmt).Error; err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	})
+}
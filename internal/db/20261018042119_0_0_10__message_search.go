@@ -0,0 +1,27 @@
+package db
+
+import (
+	"github.com/go-gormigrate/gormigrate/v2"
+	"gorm.io/gorm"
+)
+
+func init() {
+	migrations = append(migrations, &gormigrate.Migration{
+		// Full-text search over message content. The expression has to
+		// match the one the search query uses, language included. Other
+		// databases search with LIKE and get no index.
+		ID: "20261018042119_0_0_10__message_search",
+		Migrate: func(tx *gorm.DB) error {
+			if tx.Dialector.Name() != "postgres" {
+				return nil
+			}
+			return tx.Exec("CREATE INDEX IF NOT EXISTS idx_messages_content_search ON messages USING GIN (to_tsvector('english', content))").Error
+		},
+		Rollback: func(tx *gorm.DB) error {
+			if tx.Dialector.Name() != "postgres" {
+				return nil
+			}
+			return tx.Exec("DROP INDEX IF EXISTS idx_messages_content_search").Error
+		},
+	})
+}
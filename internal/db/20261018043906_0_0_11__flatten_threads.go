@@ -0,0 +1,33 @@
+package db
+
+import (
+	"github.com/go-gormigrate/gormigrate/v2"
+	"gorm.io/gorm"
+)
+
+func init() {
+	migrations = append(migrations, &gormigrate.Migration{
+		// Threads are one level deep, but replies used to be able to point
+		// at other replies. Each pass moves such replies one level up,
+		// until every reply points at its thread's root.
+		ID: "20261018043906_0_0_11__flatten_threads",
+		Migrate: func(tx *gorm.DB) error {
+			for {
+				result := tx.Exec(`UPDATE messages SET reply_to_id = (
+					SELECT parent.reply_to_id FROM messages parent WHERE parent.id = messages.reply_to_id
+				) WHERE reply_to_id IN (SELECT id FROM messages WHERE reply_to_id IS NOT NULL)`)
+				if result.Error != nil {
+					return result.Error
+				}
+				if result.RowsAffected == 0 {
+					return nil
+				}
+			}
+		},
+		// Which reply a reply answered is gone, so there is nothing to
+		// restore.
+		Rollback: func(tx *gorm.DB) error {
+			return nil
+		},
+	})
+}
@@ -0,0 +1,124 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"go/format"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-gormigrate/gormigrate/v2"
+	"gorm.io/gorm"
+)
+
+// MigrationStatus reports whether a known migration has been applied.
+type MigrationStatus struct {
+	ID      string
+	Applied bool
+}
+
+func newMigrator(db *gorm.DB) *gormigrate.Gormigrate {
+	return gormigrate.New(db, gormigrate.DefaultOptions, migrations)
+}
+
+// RunMigration applies every pending migration.
+func RunMigration(db *gorm.DB) error {
+	return newMigrator(db).Migrate()
+}
+
+// Rollback undoes the last n applied migrations, newest first.
+func Rollback(db *gorm.DB, n int) error {
+	migrator := newMigrator(db)
+	for i := range n {
+		if err := migrator.RollbackLast(); err != nil {
+			return fmt.Errorf("rolled back %d of %d migrations: %w", i, n, err)
+		}
+	}
+	return nil
+}
+
+// Status lists every migration in the order they run.
+func Status(db *gorm.DB) ([]MigrationStatus, error) {
+	options := gormigrate.DefaultOptions
+
+	var applied []string
+	if db.Migrator().HasTable(options.TableName) {
+		if err := db.Table(options.TableName).Pluck(options.IDColumnName, &applied).Error; err != nil {
+			return nil, err
+		}
+	}
+
+	statuses := make([]MigrationStatus, len(migrations))
+	for i, m := range migrations {
+		statuses[i] = MigrationStatus{ID: m.ID, Applied: slices.Contains(applied, m.ID)}
+	}
+	return statuses, nil
+}
+
+// migrationID matches IDs like 20261018035454_0_0_8__message_client_ids.
+var migrationID = regexp.MustCompile(`^\d{14}_(\d+)_(\d+)_(\d+)__`)
+
+var migrationTemplate = `package db
+
+import (
+	"github.com/go-gormigrate/gormigrate/v2"
+	"gorm.io/gorm"
+)
+
+func init() {
+	migrations = append(migrations, &gormigrate.Migration{
+		ID: %q,
+		Migrate: func(tx *gorm.DB) error {
+			return nil
+		},
+		Rollback: func(tx *gorm.DB) error {
+			return nil
+		},
+	})
+}
+`
+
+// Create writes an empty migration called name to dir and returns its path.
+// The ID is the current time followed by the next patch version, and the
+// file is named after it so migrations register in the order they were
+// created.
+func Create(dir, name string, now time.Time) (string, error) {
+	name = strings.Trim(regexp.MustCompile(`[^a-z0-9]+`).ReplaceAllString(strings.ToLower(name), "_"), "_")
+	if name == "" {
+		return "", errors.New("migration name is required")
+	}
+
+	major, minor, patch := 0, 0, 0
+	if len(migrations) > 0 {
+		last := migrations[len(migrations)-1].ID
+		match := migrationID.FindStringSubmatch(last)
+		if match == nil {
+			return "", fmt.Errorf("cannot read the version of migration %q", last)
+		}
+		major, _ = strconv.Atoi(match[1])
+		minor, _ = strconv.Atoi(match[2])
+		patch, _ = strconv.Atoi(match[3])
+	}
+
+	id := fmt.Sprintf("%s_%d_%d_%d__%s", now.UTC().Format("20060102150405"), major, minor, patch+1, name)
+	src, err := format.Source(fmt.Appendf(nil, migrationTemplate, id))
+	if err != nil {
+		return "", err
+	}
+
+	path := filepath.Join(dir, id+".go")
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+	if err != nil {
+		return "", err
+	}
+	if _, err := f.Write(src); err != nil {
+		f.Close()
+		return "", err
+	}
+	return path, f.Close()
+}
@@ -1,55 +1,26 @@
 package db
 
-import (
-	"ws-whatever/ws"
-
-	"github.com/go-gormigrate/gormigrate/v2"
-	"gorm.io/gorm"
-)
-
-var migrations = []*gormigrate.Migration{
-	// {
-	// 	ID: "20250930225731_0_0_1__init_schema",
-	// 	Migrate: func(db *gorm.DB) error {
-	// 		return db.Migrator().AutoMigrate(&domain.Messages{})
-	// 	},
-	// 	Rollback: func(d *gorm.DB) error {
-	//
-	// 	},
-	// },
+import "github.com/go-gormigrate/gormigrate/v2"
+
+// migrations run in order on every database, fresh ones included, so a
+// migration must cope with its change already being there. Each one is in
+// a file named after its ID that registers it from init, which runs in file
+// name order. Migrations declare the tables they change as they were at the
+// time instead of using the ws models, so later model changes do not change
+// what an old migration does.
+var migrations []*gormigrate.Migration
+
+// roomKey and messageKey stand in for rooms and messages in the relations
+// of tables added later. AutoMigrate also migrates the tables a model
+// refers to, so these leave everything but the key alone.
+type roomKey struct {
+	ID int `gorm:"primaryKey"`
 }
 
-func RunMigration(db *gorm.DB) error {
-	migration := gormigrate.New(db, gormigrate.DefaultOptions, migrations)
-
-	migration.InitSchema(func(tx *gorm.DB) error {
-		if err := tx.Exec("CREATE TYPE room_type AS ENUM ('group', 'direct')").Error; err != nil {
-			return err
-		}
-
-		if err := tx.AutoMigrate(
-		&ws.Community{},
-		&ws.User{},
-		&ws.Room{},
-		&ws.RoomParticipant{},
-		&ws.Message{},
-		&ws.MessageAttachment{},
-		&ws.MessageReaction{},
-		&ws.MessageRead{},
-		); err != nil {
-		 return err
-		}
+func (roomKey) TableName() string { return "rooms" }
 
-		if err := tx.Create(&ws.Community{ID: 1}).Error; err != nil {
-			return err
-		}
-
-		if err := tx.Create(&ws.User{ID: 1}).Error; err != nil {
-			return err
-		}
-
-		return nil
-	})
-
-	return migration.Migrate()
+type messageKey struct {
+	ID int `gorm:"primaryKey"`
 }
+
+func (messageKey) TableName() string { return "messages" }
@@ -0,0 +1,148 @@
+package db
+
+import (
+	"fmt"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"ws-whatever/internal/broker"
+	"ws-whatever/ws"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// models are the tables the stores and the broker work with.
+var models = []any{
+	&ws.Community{},
+	&ws.User{},
+	&ws.Room{},
+	&ws.RoomParticipant{},
+	&ws.Message{},
+	&ws.MessageAttachment{},
+	&ws.MessageReaction{},
+	&ws.MessageRead{},
+	&ws.MessageRevision{},
+	&ws.RoomEvent{},
+	&broker.BrokerEvent{},
+}
+
+func openSQLite(t *testing.T) *gorm.DB {
+	t.Helper()
+
+	dsn := "file:" + filepath.Join(t.TempDir(), "test.db") + "?_foreign_keys=on"
+	conn, err := gorm.Open(sqlite.Open(dsn), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if sqlDB, err := conn.DB(); err == nil {
+			sqlDB.Close()
+		}
+	})
+	return conn
+}
+
+// describeTable lists a table's columns, indexes and foreign keys, sorted
+// so the order they were added in does not matter.
+func describeTable(t *testing.T, conn *gorm.DB, table string) []string {
+	t.Helper()
+
+	scan := func(dest any, sql string, args ...any) {
+		if err := conn.Raw(sql, args...).Scan(dest).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var columns []struct {
+		Name      string
+		Type      string
+		NotNull   bool
+		DfltValue *string
+		PK        int
+	}
+	scan(&columns, `SELECT name, type, "notnull" AS not_null, dflt_value, pk FROM pragma_table_info(?)`, table)
+
+	var foreignKeys []struct {
+		From  string
+		Table string
+		To    string
+	}
+	scan(&foreignKeys, `SELECT "from", "table", "to" FROM pragma_foreign_key_list(?)`, table)
+
+	var indexes []struct {
+		Name   string
+		Unique bool
+	}
+	scan(&indexes, `SELECT name, "unique" FROM pragma_index_list(?)`, table)
+
+	var lines []string
+	for _, c := range columns {
+		dflt := "none"
+		if c.DfltValue != nil {
+			dflt = *c.DfltValue
+		}
+		lines = append(lines, fmt.Sprintf("column %s %s not null %v default %s pk %d", c.Name, c.Type, c.NotNull, dflt, c.PK))
+	}
+	for _, fk := range foreignKeys {
+		lines = append(lines, fmt.Sprintf("foreign key %s -> %s.%s", fk.From, fk.Table, fk.To))
+	}
+	for _, index := range indexes {
+		var indexColumns []string
+		scan(&indexColumns, "SELECT name FROM pragma_index_info(?) ORDER BY seqno", index.Name)
+		lines = append(lines, fmt.Sprintf("index %s unique %v (%s)", index.Name, index.Unique, strings.Join(indexColumns, ", ")))
+	}
+
+	slices.Sort(lines)
+	return lines
+}
+
+func TestMigrationsMatchModels(t *testing.T) {
+	modeled := openSQLite(t)
+	if err := modeled.AutoMigrate(models...); err != nil {
+		t.Fatalf("auto migrate: %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		migrate func(conn *gorm.DB) error
+	}{
+		{name: "up", migrate: RunMigration},
+		{
+			name: "down and up again",
+			migrate: func(conn *gorm.DB) error {
+				if err := RunMigration(conn); err != nil {
+					return err
+				}
+				if err := Rollback(conn, len(migrations)); err != nil {
+					return err
+				}
+				return RunMigration(conn)
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			migrated := openSQLite(t)
+			if err := tt.migrate(migrated); err != nil {
+				t.Fatalf("migrate: %v", err)
+			}
+
+			for _, model := range models {
+				stmt := &gorm.Statement{DB: modeled}
+				if err := stmt.Parse(model); err != nil {
+					t.Fatal(err)
+				}
+				table := stmt.Schema.Table
+
+				got, want := describeTable(t, migrated, table), describeTable(t, modeled, table)
+				if !slices.Equal(got, want) {
+					t.Errorf("%s migrated:\n%s\nmodels:\n%s", table, strings.Join(got, "\n"), strings.Join(want, "\n"))
+				}
+			}
+		})
+	}
+}
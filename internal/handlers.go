@@ -1,19 +1,20 @@
 package internal
 
 import (
+	"errors"
 	"net/http"
 	"strconv"
 	"time"
 	"ws-whatever/ws"
 
 	"github.com/labstack/echo"
-	"gorm.io/gorm"
 )
 
 type CreateRoomRequest struct {
 	Name        string `json:"name"`
 	CommunityID int    `json:"community_id"`
 	Type        string `json:"type"`
+	IsPrivate   bool   `json:"is_private"`
 }
 
 type RoomResponse struct {
@@ -21,29 +22,111 @@ type RoomResponse struct {
 	Name        string    `json:"name"`
 	CommunityID int       `json:"community_id"`
 	Type        string    `json:"type"`
+	IsPrivate   bool      `json:"is_private"`
 	CreatedAt   time.Time `json:"created_at"`
 }
 
+func toRoomResponse(room ws.Room) RoomResponse {
+	return RoomResponse{
+		ID:          room.ID,
+		Name:        room.Name,
+		CommunityID: room.CommunityID,
+		Type:        string(room.Type),
+		IsPrivate:   room.IsPrivate,
+		CreatedAt:   room.CreatedAt,
+	}
+}
+
 type MessageResponse struct {
-	ID        int       `json:"id"`
-	RoomID    int       `json:"room_id"`
-	SenderID  int       `json:"sender_id"`
-	Content   string    `json:"content"`
-	ReplyToID *int      `json:"reply_to_id,omitempty"`
-	CreatedAt time.Time `json:"created_at"`
+	ID          int                    `json:"id"`
+	RoomID      int                    `json:"room_id"`
+	SenderID    int                    `json:"sender_id"`
+	Content     string                 `json:"content"`
+	ReplyToID   *int                   `json:"reply_to_id,omitempty"`
+	ReplyCount  int                    `json:"reply_count"`
+	LastReplyAt *time.Time             `json:"last_reply_at,omitempty"`
+	IsPinned    bool                   `json:"is_pinned"`
+	IsEdited    bool                   `json:"is_edited"`
+	CreatedAt   time.Time              `json:"created_at"`
+	UpdatedAt   *time.Time             `json:"updated_at,omitempty"`
+	Reactions   []ws.ReactionSummary   `json:"reactions,omitempty"`
+	Attachments []ws.AttachmentPayload `json:"attachments,omitempty"`
+}
+
+type MessagePageResponse struct {
+	Messages   []MessageResponse `json:"messages"`
+	NextCursor *int              `json:"next_cursor"`
+}
+
+func toMessageResponse(msg ws.Message) MessageResponse {
+	return MessageResponse{
+		ID:        msg.ID,
+		RoomID:    msg.RoomID,
+		SenderID:  msg.SenderID,
+		Content:   msg.Content,
+		ReplyToID: msg.ReplyToID,
+		IsPinned:  msg.IsPinned,
+		IsEdited:  msg.IsEdited,
+		CreatedAt: msg.CreatedAt,
+		UpdatedAt: msg.UpdatedAt,
+	}
 }
 
-func CreateRoom(db *gorm.DB) echo.HandlerFunc {
+// toMessageResponses converts messages along with their reactions, as seen
+// by userID, attachments and thread counts.
+func toMessageResponses(s ws.Stores, userID int, messages []ws.Message) ([]MessageResponse, error) {
+	messageIDs := make([]int, len(messages))
+	for i, msg := range messages {
+		messageIDs[i] = msg.ID
+	}
+
+	reactions, err := s.Messages.ReactionSummaries(userID, messageIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	attachments, err := s.Messages.Attachments(messageIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	threads, err := s.Messages.ThreadSummaries(messageIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	response := make([]MessageResponse, len(messages))
+	for i, msg := range messages {
+		response[i] = toMessageResponse(msg)
+		response[i].Reactions = reactions[msg.ID]
+		response[i].Attachments = attachments[msg.ID]
+		response[i].ReplyCount = threads[msg.ID].ReplyCount
+		response[i].LastReplyAt = threads[msg.ID].LastReplyAt
+	}
+	return response, nil
+}
+
+func CreateRoom(s ws.Stores) echo.HandlerFunc {
 	return func(c echo.Context) error {
+		userID := c.Get("user_id")
+		if userID == nil {
+			return echo.NewHTTPError(http.StatusUnauthorized, "unauthorized")
+		}
+
 		var req CreateRoomRequest
 		if err := c.Bind(&req); err != nil {
 			return echo.NewHTTPError(http.StatusBadRequest, "invalid request")
 		}
 
-		if req.Type != "group" && req.Type != "direct" {
-			return echo.NewHTTPError(http.StatusBadRequest, "type must be 'group' or 'direct'")
+		// Direct rooms never take participants after creation, so they
+		// can only be opened through /direct-messages.
+		if req.Type != "group" {
+			return echo.NewHTTPError(http.StatusBadRequest, "type must be 'group', use /direct-messages for direct rooms")
 		}
 
+		if req.CommunityID == 0 {
+			req.CommunityID, _ = c.Get("community_id").(int)
+		}
 		if req.CommunityID == 0 {
 			req.CommunityID = 1
 		}
@@ -52,88 +135,162 @@ func CreateRoom(db *gorm.DB) echo.HandlerFunc {
 			Name:        req.Name,
 			CommunityID: req.CommunityID,
 			Type:        ws.RoomType(req.Type),
+			IsPrivate:   req.IsPrivate,
 		}
 
-		if err := db.Create(&room).Error; err != nil {
-			return echo.NewHTTPError(http.StatusInternalServerError, "failed to create room")
+		owner := ws.RoomParticipant{
+			UserID: userID.(int),
+			Role:   ws.RoleOwner,
 		}
-
-		response := RoomResponse{
-			ID:          room.ID,
-			Name:        room.Name,
-			CommunityID: room.CommunityID,
-			Type:        string(room.Type),
-			CreatedAt:   room.CreatedAt,
+		if err := s.Rooms.CreateRoom(&room, []ws.RoomParticipant{owner}); err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "failed to create room")
 		}
 
-		return c.JSON(http.StatusCreated, response)
+		return c.JSON(http.StatusCreated, toRoomResponse(room))
 	}
 }
 
-func ListRooms(db *gorm.DB) echo.HandlerFunc {
+// ListRooms lists the public group rooms plus every room the user is in.
+func ListRooms(s ws.Stores) echo.HandlerFunc {
 	return func(c echo.Context) error {
-		var rooms []ws.Room
-		if err := db.Find(&rooms).Error; err != nil {
+		userID := c.Get("user_id")
+		if userID == nil {
+			return echo.NewHTTPError(http.StatusUnauthorized, "unauthorized")
+		}
+
+		rooms, err := s.Rooms.ListRooms(userID.(int))
+		if err != nil {
 			return echo.NewHTTPError(http.StatusInternalServerError, "failed to fetch rooms")
 		}
 
 		response := make([]RoomResponse, len(rooms))
 		for i, room := range rooms {
-			response[i] = RoomResponse{
-				ID:          room.ID,
-				Name:        room.Name,
-				CommunityID: room.CommunityID,
-				Type:        string(room.Type),
-				CreatedAt:   room.CreatedAt,
-			}
+			response[i] = toRoomResponse(room)
 		}
 
 		return c.JSON(http.StatusOK, response)
 	}
 }
 
-func GetRoomMessages(db *gorm.DB) echo.HandlerFunc {
+func GetRoomMessages(s ws.Stores, cfg ws.Config) echo.HandlerFunc {
 	return func(c echo.Context) error {
 		roomID, err := strconv.Atoi(c.Param("id"))
 		if err != nil {
 			return echo.NewHTTPError(http.StatusBadRequest, "invalid room id")
 		}
 
-		limit := 50
-		if l := c.QueryParam("limit"); l != "" {
-			if parsed, err := strconv.Atoi(l); err == nil && parsed > 0 && parsed <= 100 {
-				limit = parsed
-			}
+		userID := c.Get("user_id")
+		if userID == nil {
+			return echo.NewHTTPError(http.StatusUnauthorized, "unauthorized")
+		}
+
+		if _, err := ws.Authorize(s, userID.(int), roomID, ws.ActionRead); err != nil {
+			return httpError(err, "failed to fetch messages")
+		}
+
+		cursor, err := parseHistoryCursor(c)
+		if err != nil {
+			return err
+		}
+
+		messages, next, err := s.Messages.History(roomID, cursor, parseLimit(c, cfg))
+		if err != nil {
+			return httpError(err, "failed to fetch messages")
+		}
+
+		response, err := toMessageResponses(s, userID.(int), messages)
+		if err != nil {
+			return httpError(err, "failed to fetch messages")
+		}
+
+		return c.JSON(http.StatusOK, MessagePageResponse{
+			Messages:   response,
+			NextCursor: next,
+		})
+	}
+}
+
+type ThreadResponse struct {
+	Root       MessageResponse   `json:"root"`
+	Replies    []MessageResponse `json:"replies"`
+	NextCursor *int              `json:"next_cursor"`
+}
+
+// GetThread returns a message with a page of its replies, paginated like
+// room history. Given a reply, it returns the thread the reply is in.
+func GetThread(s ws.Stores, cfg ws.Config) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		messageID, err := strconv.Atoi(c.Param("id"))
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "invalid message id")
+		}
+
+		userID := c.Get("user_id")
+		if userID == nil {
+			return echo.NewHTTPError(http.StatusUnauthorized, "unauthorized")
 		}
 
-		var messages []ws.Message
-		err = db.
-			Where("room_id = ? AND deleted_at IS NULL", roomID).
-			Order("created_at DESC").
-			Limit(limit).
-			Find(&messages).Error
+		root, _, err := ws.AuthorizeMessage(s, userID.(int), messageID, ws.ActionRead)
 		if err != nil {
-			return echo.NewHTTPError(http.StatusInternalServerError, "failed to fetch messages")
+			return httpError(err, "failed to fetch thread")
 		}
 
-		response := make([]MessageResponse, len(messages))
-		for i := len(messages) - 1; i >= 0; i-- {
-			msg := messages[i]
-			response[len(messages)-1-i] = MessageResponse{
-				ID:        msg.ID,
-				RoomID:    msg.RoomID,
-				SenderID:  msg.SenderID,
-				Content:   msg.Content,
-				ReplyToID: msg.ReplyToID,
-				CreatedAt: msg.CreatedAt,
+		// Threads are one level deep and the root is in the same room, so
+		// the check above covers it.
+		if root.ReplyToID != nil {
+			parent, err := s.Messages.GetMessage(*root.ReplyToID)
+			if err != nil {
+				return httpError(err, "failed to fetch thread")
 			}
+			root = *parent
 		}
 
-		return c.JSON(http.StatusOK, response)
+		cursor, err := parseHistoryCursor(c)
+		if err != nil {
+			return err
+		}
+
+		replies, next, err := s.Messages.ThreadReplies(root, cursor, parseLimit(c, cfg))
+		if err != nil {
+			return httpError(err, "failed to fetch thread")
+		}
+
+		response, err := toMessageResponses(s, userID.(int), append([]ws.Message{root}, replies...))
+		if err != nil {
+			return httpError(err, "failed to fetch thread")
+		}
+
+		return c.JSON(http.StatusOK, ThreadResponse{
+			Root:       response[0],
+			Replies:    response[1:],
+			NextCursor: next,
+		})
 	}
 }
 
-func DeleteMessage(db *gorm.DB) echo.HandlerFunc {
+// parseHistoryCursor reads the before_id/after_id keyset cursors.
+func parseHistoryCursor(c echo.Context) (ws.HistoryCursor, error) {
+	var cursor ws.HistoryCursor
+	for name, target := range map[string]**int{"before_id": &cursor.BeforeID, "after_id": &cursor.AfterID} {
+		raw := c.QueryParam(name)
+		if raw == "" {
+			continue
+		}
+		id, err := strconv.Atoi(raw)
+		if err != nil {
+			return cursor, echo.NewHTTPError(http.StatusBadRequest, "invalid "+name)
+		}
+		*target = &id
+	}
+	return cursor, nil
+}
+
+func parseLimit(c echo.Context, cfg ws.Config) int {
+	limit, _ := strconv.Atoi(c.QueryParam("limit"))
+	return cfg.ClampHistoryLimit(limit)
+}
+
+func DeleteMessage(m *ws.Manager) echo.HandlerFunc {
 	return func(c echo.Context) error {
 		messageID, err := strconv.Atoi(c.Param("id"))
 		if err != nil {
@@ -145,60 +302,350 @@ func DeleteMessage(db *gorm.DB) echo.HandlerFunc {
 			return echo.NewHTTPError(http.StatusUnauthorized, "unauthorized")
 		}
 
-		var message ws.Message
-		if err := db.First(&message, messageID).Error; err != nil {
-			return echo.NewHTTPError(http.StatusNotFound, "message not found")
+		if err := m.DeleteMessage(messageID, userID.(int)); err != nil {
+			return httpError(err, "failed to delete message")
+		}
+
+		return c.NoContent(http.StatusNoContent)
+	}
+}
+
+type EditMessageRequest struct {
+	Content string `json:"content"`
+}
+
+type MessageRevisionResponse struct {
+	ID        int       `json:"id"`
+	MessageID int       `json:"message_id"`
+	Content   string    `json:"content"`
+	EditedBy  int       `json:"edited_by"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func EditMessage(m *ws.Manager) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		messageID, err := strconv.Atoi(c.Param("id"))
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "invalid message id")
+		}
+
+		userID := c.Get("user_id")
+		if userID == nil {
+			return echo.NewHTTPError(http.StatusUnauthorized, "unauthorized")
+		}
+
+		var req EditMessageRequest
+		if err := c.Bind(&req); err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "invalid request")
+		}
+
+		message, err := m.EditMessage(messageID, userID.(int), req.Content)
+		if err != nil {
+			return httpError(err, "failed to edit message")
+		}
+
+		return c.JSON(http.StatusOK, toMessageResponse(*message))
+	}
+}
+
+type ReactionRequest struct {
+	ReactionType string `json:"reaction_type"`
+}
+
+func AddReaction(m *ws.Manager) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		messageID, err := strconv.Atoi(c.Param("id"))
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "invalid message id")
+		}
+
+		userID := c.Get("user_id")
+		if userID == nil {
+			return echo.NewHTTPError(http.StatusUnauthorized, "unauthorized")
+		}
+
+		var req ReactionRequest
+		if err := c.Bind(&req); err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "invalid request")
+		}
+
+		if err := m.AddReaction(messageID, userID.(int), req.ReactionType); err != nil {
+			return httpError(err, "failed to add reaction")
+		}
+
+		return c.NoContent(http.StatusNoContent)
+	}
+}
+
+func RemoveReaction(m *ws.Manager) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		messageID, err := strconv.Atoi(c.Param("id"))
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "invalid message id")
+		}
+
+		userID := c.Get("user_id")
+		if userID == nil {
+			return echo.NewHTTPError(http.StatusUnauthorized, "unauthorized")
+		}
+
+		if err := m.RemoveReaction(messageID, userID.(int), c.Param("type")); err != nil {
+			return httpError(err, "failed to remove reaction")
+		}
+
+		return c.NoContent(http.StatusNoContent)
+	}
+}
+
+func PinMessage(m *ws.Manager) echo.HandlerFunc {
+	return setPinned(m, true)
+}
+
+func UnpinMessage(m *ws.Manager) echo.HandlerFunc {
+	return setPinned(m, false)
+}
+
+func setPinned(m *ws.Manager, pinned bool) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		messageID, err := strconv.Atoi(c.Param("id"))
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "invalid message id")
 		}
 
-		if message.SenderID != userID.(int) {
-			return echo.NewHTTPError(http.StatusForbidden, "can only delete your own messages")
+		userID := c.Get("user_id")
+		if userID == nil {
+			return echo.NewHTTPError(http.StatusUnauthorized, "unauthorized")
 		}
 
-		now := time.Now()
-		message.DeletedAt = &now
-		if err := db.Save(&message).Error; err != nil {
-			return echo.NewHTTPError(http.StatusInternalServerError, "failed to delete message")
+		if pinned {
+			err = m.PinMessage(messageID, userID.(int))
+		} else {
+			err = m.UnpinMessage(messageID, userID.(int))
+		}
+		if err != nil {
+			return httpError(err, "failed to update pin")
 		}
 
 		return c.NoContent(http.StatusNoContent)
 	}
 }
 
-func SearchMessages(db *gorm.DB) echo.HandlerFunc {
+func GetPinnedMessages(s ws.Stores) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		roomID, err := strconv.Atoi(c.Param("id"))
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "invalid room id")
+		}
+
+		userID := c.Get("user_id")
+		if userID == nil {
+			return echo.NewHTTPError(http.StatusUnauthorized, "unauthorized")
+		}
+
+		if _, err := ws.Authorize(s, userID.(int), roomID, ws.ActionRead); err != nil {
+			return httpError(err, "failed to fetch pinned messages")
+		}
+
+		messages, err := s.Messages.PinnedMessages(roomID)
+		if err != nil {
+			return httpError(err, "failed to fetch pinned messages")
+		}
+
+		response, err := toMessageResponses(s, userID.(int), messages)
+		if err != nil {
+			return httpError(err, "failed to fetch pinned messages")
+		}
+
+		return c.JSON(http.StatusOK, response)
+	}
+}
+
+// httpError maps errors returned by the ws package to HTTP errors, falling
+// back to a 500 with the given message.
+func httpError(err error, fallback string) error {
+	switch {
+	case errors.Is(err, ws.ErrEmptyContent), errors.Is(err, ws.ErrInvalidReaction), errors.Is(err, ws.ErrInvalidCursor):
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	case errors.Is(err, ws.ErrMessageNotFound), errors.Is(err, ws.ErrRoomNotFound), errors.Is(err, ws.ErrAttachmentNotFound):
+		return echo.NewHTTPError(http.StatusNotFound, err.Error())
+	case errors.Is(err, ws.ErrAlreadyParticipant):
+		return echo.NewHTTPError(http.StatusConflict, err.Error())
+	case ws.IsForbidden(err):
+		return echo.NewHTTPError(http.StatusForbidden, err.Error())
+	default:
+		httpErr := echo.NewHTTPError(http.StatusInternalServerError, fallback)
+		httpErr.Internal = err
+		return httpErr
+	}
+}
+
+func GetMessageRevisions(s ws.Stores) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		messageID, err := strconv.Atoi(c.Param("id"))
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "invalid message id")
+		}
+
+		userID := c.Get("user_id")
+		if userID == nil {
+			return echo.NewHTTPError(http.StatusUnauthorized, "unauthorized")
+		}
+
+		if _, _, err := ws.AuthorizeMessage(s, userID.(int), messageID, ws.ActionRead); err != nil {
+			return httpError(err, "failed to fetch revisions")
+		}
+
+		revisions, err := s.Messages.Revisions(messageID)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "failed to fetch revisions")
+		}
+
+		response := make([]MessageRevisionResponse, len(revisions))
+		for i, rev := range revisions {
+			response[i] = MessageRevisionResponse{
+				ID:        rev.ID,
+				MessageID: rev.MessageID,
+				Content:   rev.Content,
+				EditedBy:  rev.EditedBy,
+				CreatedAt: rev.CreatedAt,
+			}
+		}
+
+		return c.JSON(http.StatusOK, response)
+	}
+}
+
+type SearchResultResponse struct {
+	MessageResponse
+	Rank float64 `json:"rank"`
+	// Headline is HTML: the escaped content with matches in <mark> tags.
+	Headline string `json:"headline"`
+}
+
+// SearchResponse is a MessagePageResponse whose messages also carry their
+// rank and headline. Relevance sorted searches page with next_offset.
+type SearchResponse struct {
+	Messages   []SearchResultResponse `json:"messages"`
+	NextCursor *int                   `json:"next_cursor"`
+	NextOffset *int                   `json:"next_offset,omitempty"`
+}
+
+// SearchMessages returns matches newest first, paged with before_id like
+// room history. sort=relevance orders them by rank instead and pages with
+// offset.
+func SearchMessages(s ws.Stores, cfg ws.Config) echo.HandlerFunc {
 	return func(c echo.Context) error {
 		query := c.QueryParam("q")
 		if query == "" {
 			return echo.NewHTTPError(http.StatusBadRequest, "query parameter 'q' is required")
 		}
 
-		roomID := c.QueryParam("room_id")
+		userID := c.Get("user_id")
+		if userID == nil {
+			return echo.NewHTTPError(http.StatusUnauthorized, "unauthorized")
+		}
+
+		search := ws.MessageQuery{
+			UserID: userID.(int),
+			Text:   query,
+			Limit:  parseLimit(c, cfg),
+		}
+
+		switch c.QueryParam("sort") {
+		case "", "date":
+		case "relevance":
+			search.ByRank = true
+		default:
+			return echo.NewHTTPError(http.StatusBadRequest, "sort must be date or relevance")
+		}
+
+		for name, target := range map[string]*int{"room_id": &search.RoomID, "sender_id": &search.SenderID, "offset": &search.Offset} {
+			raw := c.QueryParam(name)
+			if raw == "" {
+				continue
+			}
+			n, err := strconv.Atoi(raw)
+			if err != nil || n < 0 {
+				return echo.NewHTTPError(http.StatusBadRequest, "invalid "+name)
+			}
+			*target = n
+		}
+
+		for name, target := range map[string]*time.Time{"since": &search.Since, "until": &search.Until} {
+			raw := c.QueryParam(name)
+			if raw == "" {
+				continue
+			}
+			t, err := time.Parse(time.RFC3339, raw)
+			if err != nil {
+				return echo.NewHTTPError(http.StatusBadRequest, name+" must be an RFC 3339 time")
+			}
+			*target = t
+		}
 
-		dbQuery := db.Where("content ILIKE ? AND deleted_at IS NULL", "%"+query+"%")
+		for name, target := range map[string]*bool{"has_attachments": &search.HasAttachments, "pinned": &search.Pinned} {
+			raw := c.QueryParam(name)
+			if raw == "" {
+				continue
+			}
+			b, err := strconv.ParseBool(raw)
+			if err != nil {
+				return echo.NewHTTPError(http.StatusBadRequest, "invalid "+name)
+			}
+			*target = b
+		}
 
-		if roomID != "" {
-			if id, err := strconv.Atoi(roomID); err == nil {
-				dbQuery = dbQuery.Where("room_id = ?", id)
+		if beforeID := c.QueryParam("before_id"); beforeID != "" {
+			id, err := strconv.Atoi(beforeID)
+			if err != nil {
+				return echo.NewHTTPError(http.StatusBadRequest, "invalid before_id")
 			}
+			search.BeforeID = &id
 		}
 
-		var messages []ws.Message
-		if err := dbQuery.Order("created_at DESC").Limit(50).Find(&messages).Error; err != nil {
-			return echo.NewHTTPError(http.StatusInternalServerError, "failed to search messages")
+		if search.ByRank && search.BeforeID != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "before_id only applies to sort=date, use offset")
+		}
+		if !search.ByRank && search.Offset != 0 {
+			return echo.NewHTTPError(http.StatusBadRequest, "offset only applies to sort=relevance, use before_id")
 		}
 
-		response := make([]MessageResponse, len(messages))
-		for i, msg := range messages {
-			response[i] = MessageResponse{
-				ID:        msg.ID,
-				RoomID:    msg.RoomID,
-				SenderID:  msg.SenderID,
-				Content:   msg.Content,
-				ReplyToID: msg.ReplyToID,
-				CreatedAt: msg.CreatedAt,
+		if search.RoomID != 0 {
+			if _, err := ws.Authorize(s, userID.(int), search.RoomID, ws.ActionRead); err != nil {
+				return httpError(err, "failed to search messages")
 			}
 		}
 
-		return c.JSON(http.StatusOK, response)
+		results, next, err := s.Messages.SearchMessages(search)
+		if err != nil {
+			return httpError(err, "failed to search messages")
+		}
+
+		messages := make([]ws.Message, len(results))
+		for i, result := range results {
+			messages[i] = result.Message
+		}
+		responses, err := toMessageResponses(s, userID.(int), messages)
+		if err != nil {
+			return httpError(err, "failed to search messages")
+		}
+
+		response := make([]SearchResultResponse, len(results))
+		for i, result := range results {
+			response[i] = SearchResultResponse{
+				MessageResponse: responses[i],
+				Rank:            result.Rank,
+				Headline:        result.Headline,
+			}
+		}
+
+		page := SearchResponse{Messages: response}
+		if search.ByRank {
+			page.NextOffset = next
+		} else {
+			page.NextCursor = next
+		}
+		return c.JSON(http.StatusOK, page)
 	}
 }
 
@@ -207,7 +654,7 @@ type AddParticipantRequest struct {
 	Role   string `json:"role"`
 }
 
-func AddRoomParticipant(db *gorm.DB) echo.HandlerFunc {
+func AddRoomParticipant(s ws.Stores) echo.HandlerFunc {
 	return func(c echo.Context) error {
 		roomID, err := strconv.Atoi(c.Param("id"))
 		if err != nil {
@@ -224,27 +671,25 @@ func AddRoomParticipant(db *gorm.DB) echo.HandlerFunc {
 		}
 
 		if req.Role == "" {
-			req.Role = "member"
+			req.Role = ws.RoleMember
 		}
 
-		var room ws.Room
-		if err := db.First(&room, roomID).Error; err != nil {
-			if err == gorm.ErrRecordNotFound {
-				return echo.NewHTTPError(http.StatusNotFound, "room not found")
-			}
-			return echo.NewHTTPError(http.StatusInternalServerError, "failed to fetch room")
-		}
-
-		var user ws.User
-		if err := db.First(&user, req.UserID).Error; err != nil {
-			if err == gorm.ErrRecordNotFound {
-				user = ws.User{ID: req.UserID}
-				if err := db.Create(&user).Error; err != nil {
-					return echo.NewHTTPError(http.StatusInternalServerError, "failed to create user")
-				}
-			} else {
-				return echo.NewHTTPError(http.StatusInternalServerError, "failed to fetch user")
-			}
+		if req.Role != ws.RoleMember && req.Role != ws.RoleAdmin && req.Role != ws.RoleOwner {
+			return echo.NewHTTPError(http.StatusBadRequest, "role must be 'member', 'admin' or 'owner'")
+		}
+
+		userID := c.Get("user_id")
+		if userID == nil {
+			return echo.NewHTTPError(http.StatusUnauthorized, "unauthorized")
+		}
+
+		inviter, err := ws.Authorize(s, userID.(int), roomID, ws.ActionManageParticipants)
+		if err != nil {
+			return httpError(err, "failed to add participant")
+		}
+
+		if req.Role != ws.RoleMember && inviter.Role != ws.RoleOwner {
+			return echo.NewHTTPError(http.StatusForbidden, "only the room owner can grant the admin or owner role")
 		}
 
 		participant := ws.RoomParticipant{
@@ -253,8 +698,8 @@ func AddRoomParticipant(db *gorm.DB) echo.HandlerFunc {
 			Role:   req.Role,
 		}
 
-		if err := db.Create(&participant).Error; err != nil {
-			return echo.NewHTTPError(http.StatusConflict, "user already in room")
+		if err := s.Participants.AddParticipant(&participant); err != nil {
+			return httpError(err, "failed to add participant")
 		}
 
 		return c.JSON(http.StatusCreated, map[string]string{"status": "user added to room"})
@@ -274,7 +719,7 @@ type DirectMessageResponse struct {
 	CreatedAt   time.Time `json:"created_at"`
 }
 
-func CreateOrGetDirectMessage(db *gorm.DB) echo.HandlerFunc {
+func CreateOrGetDirectMessage(s ws.Stores) echo.HandlerFunc {
 	return func(c echo.Context) error {
 		userID := c.Get("user_id")
 		if userID == nil {
@@ -292,120 +737,123 @@ func CreateOrGetDirectMessage(db *gorm.DB) echo.HandlerFunc {
 
 		currentUserID := userID.(int)
 		if currentUserID == req.UserID {
-		return echo.NewHTTPError(http.StatusBadRequest, "cannot create DM with yourself")
-		}
-
-		// Check if DM room already exists by finding a direct room with these 2 participants
-		var existingRoom ws.Room
-		err := db.Where("community_id = ? AND type = ?", req.CommunityID, ws.RoomTypeDirect).
-		 Joins("JOIN room_participants rp1 ON rp1.room_id = rooms.id AND rp1.user_id = ?", currentUserID).
-		Joins("JOIN room_participants rp2 ON rp2.room_id = rooms.id AND rp2.user_id = ?", req.UserID).
-		 Where("(SELECT COUNT(*) FROM room_participants WHERE room_id = rooms.id) = 2").
-		 First(&existingRoom).Error
-
-	if err == nil {
-		 return c.JSON(http.StatusOK, DirectMessageResponse{
-		 RoomID:      existingRoom.ID,
-		 CommunityID: existingRoom.CommunityID,
-		 UserAID:     min(currentUserID, req.UserID),
-		UserBID:     max(currentUserID, req.UserID),
-		CreatedAt:   existingRoom.CreatedAt,
-		})
+			return echo.NewHTTPError(http.StatusBadRequest, "cannot create DM with yourself")
 		}
 
-		if err != gorm.ErrRecordNotFound {
-		 return echo.NewHTTPError(http.StatusInternalServerError, "failed to check existing DM")
-	}
-
-		tx := db.Begin()
-		defer func() {
-		if r := recover(); r != nil {
-		tx.Rollback()
+		existingRoom, err := s.Rooms.FindDirectRoom(req.CommunityID, currentUserID, req.UserID)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "failed to check existing DM")
 		}
-		}()
 
-		for _, uid := range []int{currentUserID, req.UserID} {
-		var user ws.User
-		if err := tx.First(&user, uid).Error; err != nil {
-		if err == gorm.ErrRecordNotFound {
-		user = ws.User{ID: uid}
-		if err := tx.Create(&user).Error; err != nil {
-		tx.Rollback()
-		return echo.NewHTTPError(http.StatusInternalServerError, "failed to create user")
-		}
-		} else {
-		tx.Rollback()
-		return echo.NewHTTPError(http.StatusInternalServerError, "failed to fetch user")
-		}
-		}
+		if existingRoom != nil {
+			return c.JSON(http.StatusOK, DirectMessageResponse{
+				RoomID:      existingRoom.ID,
+				CommunityID: existingRoom.CommunityID,
+				UserAID:     min(currentUserID, req.UserID),
+				UserBID:     max(currentUserID, req.UserID),
+				CreatedAt:   existingRoom.CreatedAt,
+			})
 		}
 
 		room := ws.Room{
-		Name:        "",
-		CommunityID: req.CommunityID,
-		Type:        ws.RoomTypeDirect,
+			Name:        "",
+			CommunityID: req.CommunityID,
+			Type:        ws.RoomTypeDirect,
 		}
-		if err := tx.Create(&room).Error; err != nil {
-		tx.Rollback()
-		return echo.NewHTTPError(http.StatusInternalServerError, "failed to create room")
+		participants := []ws.RoomParticipant{
+			{UserID: currentUserID, Role: ws.RoleMember},
+			{UserID: req.UserID, Role: ws.RoleMember},
 		}
+		if err := s.Rooms.CreateRoom(&room, participants); err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "failed to create room")
+		}
+
+		return c.JSON(http.StatusCreated, DirectMessageResponse{
+			RoomID:      room.ID,
+			CommunityID: req.CommunityID,
+			UserAID:     min(currentUserID, req.UserID),
+			UserBID:     max(currentUserID, req.UserID),
+			CreatedAt:   room.CreatedAt,
+		})
+	}
+}
+
+type UserRoomResponse struct {
+	RoomResponse
+	UnreadCount       int  `json:"unread_count"`
+	LastReadMessageID *int `json:"last_read_message_id"`
+}
 
-		participantA := ws.RoomParticipant{
-		RoomID: room.ID,
-		UserID: currentUserID,
-		Role:   "member",
+func GetUserRooms(s ws.Stores) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		userID := c.Get("user_id")
+		if userID == nil {
+			return echo.NewHTTPError(http.StatusUnauthorized, "unauthorized")
 		}
-		participantB := ws.RoomParticipant{
-		 RoomID: room.ID,
-		UserID: req.UserID,
-		Role:   "member",
+
+		participants, err := s.Participants.UserRooms(userID.(int))
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "failed to fetch rooms")
 		}
 
-		if err := tx.Create(&participantA).Error; err != nil {
-		tx.Rollback()
-		return echo.NewHTTPError(http.StatusInternalServerError, "failed to add participants")
+		unreadByRoom, err := s.Messages.UnreadCounts(userID.(int))
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "failed to count unread messages")
 		}
-		if err := tx.Create(&participantB).Error; err != nil {
-		 tx.Rollback()
-		return echo.NewHTTPError(http.StatusInternalServerError, "failed to add participants")
+
+		lastReadByRoom, err := s.Messages.LastReadMessages(userID.(int))
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "failed to fetch read state")
 		}
 
-		if err := tx.Commit().Error; err != nil {
-		return echo.NewHTTPError(http.StatusInternalServerError, "failed to commit transaction")
+		response := make([]UserRoomResponse, len(participants))
+		for i, p := range participants {
+			response[i] = UserRoomResponse{
+				RoomResponse: toRoomResponse(p.Room),
+				UnreadCount:  unreadByRoom[p.RoomID],
+			}
+			if messageID, ok := lastReadByRoom[p.RoomID]; ok {
+				response[i].LastReadMessageID = &messageID
+			}
 		}
 
-		return c.JSON(http.StatusCreated, DirectMessageResponse{
-		RoomID:      room.ID,
-		 CommunityID: req.CommunityID,
-		UserAID:     min(currentUserID, req.UserID),
-		UserBID:     max(currentUserID, req.UserID),
-		 CreatedAt:   room.CreatedAt,
-		})
+		return c.JSON(http.StatusOK, response)
 	}
 }
 
-func GetUserRooms(db *gorm.DB) echo.HandlerFunc {
+type PresenceResponse struct {
+	UserID       int               `json:"user_id"`
+	Status       ws.PresenceStatus `json:"status"`
+	LastActiveAt *time.Time        `json:"last_active_at"`
+}
+
+func GetRoomPresence(s ws.Stores, m *ws.Manager) echo.HandlerFunc {
 	return func(c echo.Context) error {
+		roomID, err := strconv.Atoi(c.Param("id"))
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "invalid room id")
+		}
+
 		userID := c.Get("user_id")
 		if userID == nil {
 			return echo.NewHTTPError(http.StatusUnauthorized, "unauthorized")
 		}
 
-		var participants []ws.RoomParticipant
-		if err := db.Where("user_id = ?", userID.(int)).
-			Preload("Room").
-			Find(&participants).Error; err != nil {
-			return echo.NewHTTPError(http.StatusInternalServerError, "failed to fetch rooms")
+		if _, err := ws.Authorize(s, userID.(int), roomID, ws.ActionRead); err != nil {
+			return httpError(err, "failed to fetch presence")
+		}
+
+		participants, err := s.Participants.Participants(roomID)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "failed to fetch participants")
 		}
 
-		response := make([]RoomResponse, len(participants))
+		response := make([]PresenceResponse, len(participants))
 		for i, p := range participants {
-			response[i] = RoomResponse{
-				ID:          p.Room.ID,
-				Name:        p.Room.Name,
-				CommunityID: p.Room.CommunityID,
-				Type:        string(p.Room.Type),
-				CreatedAt:   p.Room.CreatedAt,
+			status, lastActive := m.UserPresence(p.UserID)
+			response[i] = PresenceResponse{UserID: p.UserID, Status: status}
+			if !lastActive.IsZero() {
+				response[i].LastActiveAt = &lastActive
 			}
 		}
 
@@ -0,0 +1,387 @@
+package internal
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+	"ws-whatever/internal/store"
+	"ws-whatever/ws"
+
+	"github.com/labstack/echo"
+)
+
+type testAPI struct {
+	echo   *echo.Echo
+	stores ws.Stores
+}
+
+// newTestAPI serves the message handlers on in-memory stores. Requests are
+// authenticated as the user in the X-User-ID header, if any.
+func newTestAPI(t *testing.T) *testAPI {
+	t.Helper()
+
+	stores := store.NewMemory().Stores()
+	cfg := ws.DefaultConfig()
+	m := ws.NewManager(stores, slog.New(slog.DiscardHandler), nil, cfg)
+
+	e := echo.New()
+	e.Use(func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if userID, err := strconv.Atoi(c.Request().Header.Get("X-User-ID")); err == nil {
+				c.Set("user_id", userID)
+			}
+			return next(c)
+		}
+	})
+	e.GET("/rooms/:id/messages", GetRoomMessages(stores, cfg))
+	e.PATCH("/messages/:id", EditMessage(m))
+	e.DELETE("/messages/:id", DeleteMessage(m))
+	e.GET("/messages/:id/thread", GetThread(stores, cfg))
+	e.GET("/search/messages", SearchMessages(stores, cfg))
+	e.GET("/rooms/:id/pins", GetPinnedMessages(stores))
+
+	return &testAPI{echo: e, stores: stores}
+}
+
+func (a *testAPI) createRoom(t *testing.T, private bool, userIDs ...int) int {
+	t.Helper()
+
+	room := ws.Room{Name: "room", CommunityID: 1, Type: ws.RoomTypeGroup, IsPrivate: private}
+	participants := make([]ws.RoomParticipant, len(userIDs))
+	for i, userID := range userIDs {
+		participants[i] = ws.RoomParticipant{UserID: userID, Role: ws.RoleMember}
+	}
+	if err := a.stores.Rooms.CreateRoom(&room, participants); err != nil {
+		t.Fatalf("create room: %v", err)
+	}
+	return room.ID
+}
+
+// createMessages stores one message per content, a second apart and in
+// order, and returns their IDs.
+func (a *testAPI) createMessages(t *testing.T, roomID int, replyToID *int, contents ...string) []int {
+	t.Helper()
+
+	start := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	if replyToID != nil {
+		start = start.Add(time.Hour)
+	}
+
+	ids := make([]int, len(contents))
+	for i, content := range contents {
+		message := ws.Message{
+			RoomID:    roomID,
+			SenderID:  1,
+			Content:   content,
+			ReplyToID: replyToID,
+			CreatedAt: start.Add(time.Duration(i) * time.Second),
+		}
+		if _, err := a.stores.Messages.CreateMessage(&message, nil); err != nil {
+			t.Fatalf("create message: %v", err)
+		}
+		ids[i] = message.ID
+	}
+	return ids
+}
+
+// do serves the request as userID and decodes a 200 response into out.
+func (a *testAPI) do(t *testing.T, userID int, method, target, body string, out any) int {
+	t.Helper()
+
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	req.Header.Set("X-User-ID", strconv.Itoa(userID))
+	rec := httptest.NewRecorder()
+	a.echo.ServeHTTP(rec, req)
+
+	if rec.Code == http.StatusOK && out != nil {
+		if err := json.Unmarshal(rec.Body.Bytes(), out); err != nil {
+			t.Fatalf("decode %s %s: %v", method, target, err)
+		}
+	}
+	return rec.Code
+}
+
+func messageIDs(messages []MessageResponse) []int {
+	ids := []int{}
+	for _, m := range messages {
+		ids = append(ids, m.ID)
+	}
+	return ids
+}
+
+func intPtrEqual(a, b *int) bool {
+	return (a == nil) == (b == nil) && (a == nil || *a == *b)
+}
+
+func TestGetRoomMessages(t *testing.T) {
+	api := newTestAPI(t)
+	roomID := api.createRoom(t, false, 1)
+	privateID := api.createRoom(t, true, 2)
+	ids := api.createMessages(t, roomID, nil, "one", "two", "three", "four")
+	api.createMessages(t, roomID, &ids[0], "reply")
+
+	tests := []struct {
+		name       string
+		roomID     int
+		query      string
+		wantStatus int
+		wantIDs    []int
+		wantNext   *int
+	}{
+		{name: "latest page", roomID: roomID, query: "limit=2", wantStatus: http.StatusOK, wantIDs: ids[2:], wantNext: &ids[2]},
+		{name: "before a message", roomID: roomID, query: "limit=2&before_id=" + strconv.Itoa(ids[2]), wantStatus: http.StatusOK, wantIDs: ids[:2]},
+		{name: "after a message", roomID: roomID, query: "limit=2&after_id=" + strconv.Itoa(ids[0]), wantStatus: http.StatusOK, wantIDs: ids[1:3], wantNext: &ids[2]},
+		{name: "invalid cursor", roomID: roomID, query: "before_id=x", wantStatus: http.StatusBadRequest},
+		{name: "private room", roomID: privateID, wantStatus: http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var page MessagePageResponse
+			status := api.do(t, 1, http.MethodGet, "/rooms/"+strconv.Itoa(tt.roomID)+"/messages?"+tt.query, "", &page)
+			if status != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", status, tt.wantStatus)
+			}
+			if status != http.StatusOK {
+				return
+			}
+			if got := messageIDs(page.Messages); !slices.Equal(got, tt.wantIDs) {
+				t.Fatalf("messages = %v, want %v", got, tt.wantIDs)
+			}
+			if !intPtrEqual(page.NextCursor, tt.wantNext) {
+				t.Fatalf("next_cursor = %v, want %v", page.NextCursor, tt.wantNext)
+			}
+		})
+	}
+}
+
+func TestGetThread(t *testing.T) {
+	api := newTestAPI(t)
+	roomID := api.createRoom(t, false, 1)
+	root := api.createMessages(t, roomID, nil, "root")[0]
+	replies := api.createMessages(t, roomID, &root, "one", "two", "three")
+
+	tests := []struct {
+		name       string
+		messageID  int
+		query      string
+		wantStatus int
+		wantIDs    []int
+		wantNext   *int
+	}{
+		{name: "root", messageID: root, wantStatus: http.StatusOK, wantIDs: replies},
+		{name: "reply resolves to its thread", messageID: replies[1], wantStatus: http.StatusOK, wantIDs: replies},
+		{name: "latest replies", messageID: root, query: "limit=2", wantStatus: http.StatusOK, wantIDs: replies[1:], wantNext: &replies[1]},
+		{name: "missing message", messageID: replies[2] + 100, wantStatus: http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var thread ThreadResponse
+			status := api.do(t, 1, http.MethodGet, "/messages/"+strconv.Itoa(tt.messageID)+"/thread?"+tt.query, "", &thread)
+			if status != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", status, tt.wantStatus)
+			}
+			if status != http.StatusOK {
+				return
+			}
+			if thread.Root.ID != root || thread.Root.ReplyCount != len(replies) {
+				t.Fatalf("root = %d with %d replies, want %d with %d", thread.Root.ID, thread.Root.ReplyCount, root, len(replies))
+			}
+			if got := messageIDs(thread.Replies); !slices.Equal(got, tt.wantIDs) {
+				t.Fatalf("replies = %v, want %v", got, tt.wantIDs)
+			}
+			if !intPtrEqual(thread.NextCursor, tt.wantNext) {
+				t.Fatalf("next_cursor = %v, want %v", thread.NextCursor, tt.wantNext)
+			}
+		})
+	}
+}
+
+func TestGetPinnedMessages(t *testing.T) {
+	api := newTestAPI(t)
+	roomID := api.createRoom(t, true, 1, 2)
+	ids := api.createMessages(t, roomID, nil, "pinned", "not pinned")
+	pinned := ids[0]
+	api.createMessages(t, roomID, &pinned, "reply")
+
+	if err := api.stores.Messages.SetPinned(pinned, true); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := api.stores.Messages.AddReaction(pinned, 1, "+1"); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name          string
+		userID        int
+		wantStatus    int
+		wantReactedBy bool
+	}{
+		{name: "reactor", userID: 1, wantStatus: http.StatusOK, wantReactedBy: true},
+		{name: "other member", userID: 2, wantStatus: http.StatusOK},
+		{name: "non-member", userID: 3, wantStatus: http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var pins []MessageResponse
+			status := api.do(t, tt.userID, http.MethodGet, "/rooms/"+strconv.Itoa(roomID)+"/pins", "", &pins)
+			if status != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", status, tt.wantStatus)
+			}
+			if status != http.StatusOK {
+				return
+			}
+
+			if got := messageIDs(pins); !slices.Equal(got, []int{pinned}) {
+				t.Fatalf("pins = %v, want %v", got, []int{pinned})
+			}
+			pin := pins[0]
+			if pin.ReplyCount != 1 || pin.LastReplyAt == nil {
+				t.Fatalf("pin has %d replies, last at %v; want 1", pin.ReplyCount, pin.LastReplyAt)
+			}
+			want := []ws.ReactionSummary{{Type: "+1", Count: 1, ReactedByMe: tt.wantReactedBy}}
+			if !slices.Equal(pin.Reactions, want) {
+				t.Fatalf("reactions = %+v, want %+v", pin.Reactions, want)
+			}
+		})
+	}
+}
+
+func TestSearchMessages(t *testing.T) {
+	api := newTestAPI(t)
+	roomID := api.createRoom(t, false, 1)
+	ids := api.createMessages(t, roomID, nil, "deploy one", "unrelated", "deploy two", "deploy three")
+
+	two := 2
+	tests := []struct {
+		name       string
+		query      string
+		wantStatus int
+		wantIDs    []int
+		wantNext   *int
+		wantOffset *int
+	}{
+		{name: "newest first", query: "q=deploy&limit=2", wantStatus: http.StatusOK, wantIDs: []int{ids[3], ids[2]}, wantNext: &ids[2]},
+		{name: "before a match", query: "q=deploy&limit=2&before_id=" + strconv.Itoa(ids[2]), wantStatus: http.StatusOK, wantIDs: []int{ids[0]}},
+		{name: "by relevance", query: "q=deploy&limit=2&sort=relevance", wantStatus: http.StatusOK, wantIDs: []int{ids[3], ids[2]}, wantOffset: &two},
+		{name: "by relevance with offset", query: "q=deploy&limit=2&sort=relevance&offset=2", wantStatus: http.StatusOK, wantIDs: []int{ids[0]}},
+		{name: "missing query", query: "limit=2", wantStatus: http.StatusBadRequest},
+		{name: "unknown sort", query: "q=deploy&sort=rank", wantStatus: http.StatusBadRequest},
+		{name: "offset by date", query: "q=deploy&offset=2", wantStatus: http.StatusBadRequest},
+		{name: "before_id by relevance", query: "q=deploy&sort=relevance&before_id=" + strconv.Itoa(ids[2]), wantStatus: http.StatusBadRequest},
+		{name: "unknown cursor", query: "q=deploy&before_id=" + strconv.Itoa(ids[3]+100), wantStatus: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var page SearchResponse
+			status := api.do(t, 1, http.MethodGet, "/search/messages?"+tt.query, "", &page)
+			if status != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", status, tt.wantStatus)
+			}
+			if status != http.StatusOK {
+				return
+			}
+
+			got := []int{}
+			for _, m := range page.Messages {
+				got = append(got, m.ID)
+				if !strings.Contains(m.Headline, "<mark>deploy</mark>") {
+					t.Fatalf("headline %q does not mark the match", m.Headline)
+				}
+			}
+			if !slices.Equal(got, tt.wantIDs) {
+				t.Fatalf("messages = %v, want %v", got, tt.wantIDs)
+			}
+			if !intPtrEqual(page.NextCursor, tt.wantNext) || !intPtrEqual(page.NextOffset, tt.wantOffset) {
+				t.Fatalf("next_cursor = %v, next_offset = %v; want %v, %v", page.NextCursor, page.NextOffset, tt.wantNext, tt.wantOffset)
+			}
+		})
+	}
+}
+
+func TestEditMessage(t *testing.T) {
+	tests := []struct {
+		name       string
+		userID     int
+		body       string
+		wantStatus int
+	}{
+		{name: "sender edits", userID: 1, body: `{"content":"edited"}`, wantStatus: http.StatusOK},
+		{name: "someone else", userID: 2, body: `{"content":"edited"}`, wantStatus: http.StatusForbidden},
+		{name: "empty content", userID: 1, body: `{"content":""}`, wantStatus: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api := newTestAPI(t)
+			roomID := api.createRoom(t, false, 1, 2)
+			id := api.createMessages(t, roomID, nil, "original")[0]
+
+			var response MessageResponse
+			status := api.do(t, tt.userID, http.MethodPatch, "/messages/"+strconv.Itoa(id), tt.body, &response)
+			if status != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", status, tt.wantStatus)
+			}
+
+			stored, err := api.stores.Messages.GetMessage(id)
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := "original"
+			if status == http.StatusOK {
+				want = "edited"
+				if response.Content != want || !response.IsEdited {
+					t.Fatalf("response = %+v, want edited content", response)
+				}
+			}
+			if stored.Content != want {
+				t.Fatalf("stored content = %q, want %q", stored.Content, want)
+			}
+		})
+	}
+}
+
+func TestDeleteMessage(t *testing.T) {
+	tests := []struct {
+		name       string
+		userID     int
+		wantStatus int
+	}{
+		{name: "sender deletes", userID: 1, wantStatus: http.StatusNoContent},
+		{name: "someone else", userID: 2, wantStatus: http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api := newTestAPI(t)
+			roomID := api.createRoom(t, false, 1, 2)
+			id := api.createMessages(t, roomID, nil, "message")[0]
+
+			if status := api.do(t, tt.userID, http.MethodDelete, "/messages/"+strconv.Itoa(id), "", nil); status != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", status, tt.wantStatus)
+			}
+
+			var page MessagePageResponse
+			if status := api.do(t, 1, http.MethodGet, "/rooms/"+strconv.Itoa(roomID)+"/messages", "", &page); status != http.StatusOK {
+				t.Fatalf("history status = %d", status)
+			}
+			wantLen := 1
+			if tt.wantStatus == http.StatusNoContent {
+				wantLen = 0
+			}
+			if len(page.Messages) != wantLen {
+				t.Fatalf("history has %d messages, want %d", len(page.Messages), wantLen)
+			}
+		})
+	}
+}
@@ -0,0 +1,124 @@
+// Package metrics instruments the HTTP server and the database for
+// Prometheus. The websocket metrics live in the ws package.
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/labstack/echo"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"gorm.io/gorm"
+)
+
+var (
+	httpRequestsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "http_requests_total",
+		Help: "HTTP requests, by method, route and status.",
+	}, []string{"method", "route", "status"})
+
+	httpRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "http_request_duration_seconds",
+		Help:    "HTTP request latency, by method and route.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"method", "route"})
+
+	dbQueryDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "db_query_duration_seconds",
+		Help:    "Database statement latency, by operation.",
+		Buckets: prometheus.ExponentialBuckets(0.0005, 2, 14),
+	}, []string{"operation"})
+
+	dbErrorsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "db_errors_total",
+		Help: "Failed database statements, by operation. Record not found is not counted.",
+	}, []string{"operation"})
+)
+
+// Handler serves the registered metrics.
+func Handler() echo.HandlerFunc {
+	return echo.WrapHandler(promhttp.Handler())
+}
+
+// Middleware records the count and latency of every request. Routes are
+// labelled by their pattern so ids in the path do not blow up cardinality.
+func Middleware(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		start := time.Now()
+		err := next(c)
+
+		status := c.Response().Status
+		if err != nil {
+			status = http.StatusInternalServerError
+			if httpErr, ok := err.(*echo.HTTPError); ok {
+				status = httpErr.Code
+			}
+		}
+
+		route := c.Path()
+		if route == "" {
+			route = "unmatched"
+		}
+
+		method := c.Request().Method
+		httpRequestsTotal.WithLabelValues(method, route, strconv.Itoa(status)).Inc()
+		httpRequestDuration.WithLabelValues(method, route).Observe(time.Since(start).Seconds())
+
+		return err
+	}
+}
+
+const startKey = "metrics:start"
+
+type registrar interface {
+	Register(name string, fn func(*gorm.DB)) error
+}
+
+// InstrumentDB times every statement GORM runs.
+func InstrumentDB(db *gorm.DB) error {
+	callbacks := db.Callback()
+
+	hooks := []struct {
+		operation     string
+		before, after registrar
+	}{
+		{"create", callbacks.Create().Before("*"), callbacks.Create().After("*")},
+		{"query", callbacks.Query().Before("*"), callbacks.Query().After("*")},
+		{"update", callbacks.Update().Before("*"), callbacks.Update().After("*")},
+		{"delete", callbacks.Delete().Before("*"), callbacks.Delete().After("*")},
+		{"row", callbacks.Row().Before("*"), callbacks.Row().After("*")},
+		{"raw", callbacks.Raw().Before("*"), callbacks.Raw().After("*")},
+	}
+
+	for _, hook := range hooks {
+		err := hook.before.Register("metrics:before_"+hook.operation, func(tx *gorm.DB) {
+			tx.InstanceSet(startKey, time.Now())
+		})
+		if err != nil {
+			return err
+		}
+
+		if err := hook.after.Register("metrics:after_"+hook.operation, observe(hook.operation)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func observe(operation string) func(*gorm.DB) {
+	return func(tx *gorm.DB) {
+		if value, ok := tx.InstanceGet(startKey); ok {
+			if start, ok := value.(time.Time); ok {
+				dbQueryDuration.WithLabelValues(operation).Observe(time.Since(start).Seconds())
+			}
+		}
+
+		if tx.Error != nil && tx.Error != gorm.ErrRecordNotFound {
+			dbErrorsTotal.WithLabelValues(operation).Inc()
+		}
+	}
+}
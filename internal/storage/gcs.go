@@ -0,0 +1,75 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+
+	gcs "cloud.google.com/go/storage"
+	"google.golang.org/api/option"
+)
+
+// GCS stores objects in a Cloud Storage bucket.
+type GCS struct {
+	bucket *gcs.BucketHandle
+}
+
+// NewGCS creates a GCS backend. Without an endpoint it talks to Cloud
+// Storage with Application Default Credentials. An endpoint such as a local
+// emulator (oittaa/gcp-storage-emulator) is used without credentials.
+func NewGCS(ctx context.Context, endpoint, bucket string) (*GCS, error) {
+	var opts []option.ClientOption
+	if endpoint != "" {
+		opts = append(opts,
+			option.WithEndpoint(strings.TrimRight(endpoint, "/")+"/storage/v1/"),
+			option.WithoutAuthentication(),
+			// Emulators tend to implement only the JSON API.
+			gcs.WithJSONReads(),
+		)
+	}
+
+	client, err := gcs.NewClient(ctx, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return &GCS{bucket: client.Bucket(bucket)}, nil
+}
+
+func (g *GCS) Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error {
+	// Cancelling the context is the only way to abandon an upload; closing
+	// the writer would store whatever was written so far.
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	w := g.bucket.Object(key).NewWriter(ctx)
+	w.ContentType = contentType
+	// Attachments are small enough to upload in a single request.
+	w.ChunkSize = 0
+
+	if _, err := io.Copy(w, r); err != nil {
+		cancel()
+		w.Close()
+		return err
+	}
+	return w.Close()
+}
+
+func (g *GCS) Open(ctx context.Context, key string) (io.ReadCloser, error) {
+	r, err := g.bucket.Object(key).NewReader(ctx)
+	if errors.Is(err, gcs.ErrObjectNotExist) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (g *GCS) Delete(ctx context.Context, key string) error {
+	err := g.bucket.Object(key).Delete(ctx)
+	if errors.Is(err, gcs.ErrObjectNotExist) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,209 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeGCS serves the parts of the Cloud Storage JSON API the client uses:
+// single request uploads, media downloads and deletes.
+type fakeGCS struct {
+	mu      sync.Mutex
+	objects map[string]string
+}
+
+func newFakeGCS(t *testing.T) string {
+	t.Helper()
+
+	f := &fakeGCS{objects: make(map[string]string)}
+	srv := httptest.NewServer(f)
+	t.Cleanup(srv.Close)
+	return srv.URL
+}
+
+func (f *fakeGCS) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	switch {
+	case r.Method == http.MethodPost && strings.HasPrefix(r.URL.Path, "/upload/storage/v1/b/bucket/o"):
+		name, content, err := readUpload(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		f.objects[name] = content
+		json.NewEncoder(w).Encode(map[string]string{"bucket": "bucket", "name": name, "size": strconv.Itoa(len(content))})
+	case strings.HasPrefix(r.URL.Path, "/storage/v1/b/bucket/o/"):
+		name := strings.TrimPrefix(r.URL.Path, "/storage/v1/b/bucket/o/")
+		content, ok := f.objects[name]
+		if !ok {
+			http.Error(w, `{"error": {"code": 404, "message": "not found"}}`, http.StatusNotFound)
+			return
+		}
+
+		switch r.Method {
+		case http.MethodGet:
+			io.WriteString(w, content)
+		case http.MethodDelete:
+			delete(f.objects, name)
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			http.Error(w, "unexpected method", http.StatusMethodNotAllowed)
+		}
+	default:
+		http.Error(w, "unexpected request "+r.Method+" "+r.URL.Path, http.StatusNotImplemented)
+	}
+}
+
+// readUpload reads a multipart upload's object name from its metadata part
+// and its content from the media part.
+func readUpload(r *http.Request) (name, content string, err error) {
+	_, params, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil {
+		return "", "", err
+	}
+
+	parts := multipart.NewReader(r.Body, params["boundary"])
+	metadata, err := parts.NextPart()
+	if err != nil {
+		return "", "", err
+	}
+	var object struct {
+		Name string `json:"name"`
+	}
+	if err := json.NewDecoder(metadata).Decode(&object); err != nil {
+		return "", "", err
+	}
+
+	media, err := parts.NextPart()
+	if err != nil {
+		return "", "", err
+	}
+	data, err := io.ReadAll(media)
+	return object.Name, string(data), err
+}
+
+func TestStorage(t *testing.T) {
+	backends := []struct {
+		name string
+		open func(t *testing.T) Storage
+	}{
+		{
+			name: "local",
+			open: func(t *testing.T) Storage {
+				s, err := NewLocal(t.TempDir())
+				if err != nil {
+					t.Fatal(err)
+				}
+				return s
+			},
+		},
+		{
+			name: "gcs",
+			open: func(t *testing.T) Storage {
+				s, err := NewGCS(context.Background(), newFakeGCS(t), "bucket")
+				if err != nil {
+					t.Fatal(err)
+				}
+				return s
+			},
+		},
+	}
+
+	ctx := context.Background()
+	for _, backend := range backends {
+		t.Run(backend.name, func(t *testing.T) {
+			s := backend.open(t)
+			const key, content = "rooms/1/file.txt", "hello"
+
+			if err := s.Put(ctx, key, strings.NewReader(content), int64(len(content)), "text/plain"); err != nil {
+				t.Fatalf("Put() error = %v", err)
+			}
+
+			body, err := s.Open(ctx, key)
+			if err != nil {
+				t.Fatalf("Open() error = %v", err)
+			}
+			got, err := io.ReadAll(body)
+			body.Close()
+			if err != nil || string(got) != content {
+				t.Fatalf("Open() read %q, %v; want %q", got, err, content)
+			}
+
+			if err := s.Delete(ctx, key); err != nil {
+				t.Fatalf("Delete() error = %v", err)
+			}
+			if _, err := s.Open(ctx, key); !errors.Is(err, ErrNotFound) {
+				t.Fatalf("Open() after Delete() error = %v, want ErrNotFound", err)
+			}
+			// Deleting is idempotent, so cleanups can retry.
+			if err := s.Delete(ctx, key); err != nil {
+				t.Fatalf("Delete() of a missing object error = %v", err)
+			}
+		})
+	}
+}
+
+func TestLocalKeysStayInsideDir(t *testing.T) {
+	tests := []struct {
+		name    string
+		key     string
+		wantErr bool
+	}{
+		{name: "nested", key: "rooms/1/file.txt"},
+		{name: "dot segments inside", key: "rooms/../rooms/1/file.txt"},
+		{name: "parent", key: "../file.txt", wantErr: true},
+		{name: "parent after a segment", key: "rooms/../../file.txt", wantErr: true},
+		{name: "sibling with the same prefix", key: "../files-other/file.txt", wantErr: true},
+		{name: "the dir itself", key: ".", wantErr: true},
+		{name: "empty", key: "", wantErr: true},
+	}
+
+	ctx := context.Background()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			parent := t.TempDir()
+			s, err := NewLocal(filepath.Join(parent, "files"))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = s.Put(ctx, tt.key, strings.NewReader("x"), 1, "text/plain")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Put(%q) error = %v, want error %v", tt.key, err, tt.wantErr)
+			}
+			if !tt.wantErr {
+				return
+			}
+
+			// Nothing may be written next to the storage dir.
+			entries, err := os.ReadDir(parent)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(entries) != 1 || entries[0].Name() != "files" {
+				t.Fatalf("Put(%q) wrote outside the storage dir: %v", tt.key, entries)
+			}
+
+			if _, err := s.Open(ctx, tt.key); err == nil || errors.Is(err, ErrNotFound) {
+				t.Fatalf("Open(%q) error = %v, want an invalid key error", tt.key, err)
+			}
+			if err := s.Delete(ctx, tt.key); err == nil {
+				t.Fatalf("Delete(%q) succeeded, want an invalid key error", tt.key)
+			}
+		})
+	}
+}
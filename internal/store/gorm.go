@@ -0,0 +1,190 @@
+package store
+
+import (
+	"encoding/json"
+	"errors"
+	"time"
+	"ws-whatever/ws"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// GORM implements the ws stores on top of a gorm database.
+type GORM struct {
+	db *gorm.DB
+}
+
+func NewGORM(db *gorm.DB) *GORM {
+	return &GORM{db: db}
+}
+
+// Stores returns s as each of the ws stores.
+func (s *GORM) Stores() ws.Stores {
+	return ws.Stores{Messages: s, Rooms: s, Participants: s}
+}
+
+func (s *GORM) CreateRoom(room *ws.Room, participants []ws.RoomParticipant) error {
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		for _, p := range participants {
+			if err := ensureUser(tx, p.UserID); err != nil {
+				return err
+			}
+		}
+
+		if err := tx.Create(room).Error; err != nil {
+			return err
+		}
+
+		for i := range participants {
+			participants[i].RoomID = room.ID
+		}
+		if len(participants) == 0 {
+			return nil
+		}
+		return tx.Create(&participants).Error
+	})
+}
+
+func (s *GORM) GetRoom(roomID int) (*ws.Room, error) {
+	var room ws.Room
+	if err := s.db.First(&room, roomID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ws.ErrRoomNotFound
+		}
+		return nil, err
+	}
+	return &room, nil
+}
+
+func (s *GORM) ListRooms(userID int) ([]ws.Room, error) {
+	var rooms []ws.Room
+	err := s.db.
+		Where("(type = ? AND is_private = ?) OR id IN (?)",
+			ws.RoomTypeGroup, false,
+			s.db.Model(&ws.RoomParticipant{}).Select("room_id").Where("user_id = ?", userID)).
+		Find(&rooms).Error
+	return rooms, err
+}
+
+func (s *GORM) FindDirectRoom(communityID, userA, userB int) (*ws.Room, error) {
+	var room ws.Room
+	err := s.db.Where("community_id = ? AND type = ?", communityID, ws.RoomTypeDirect).
+		Joins("JOIN room_participants rp1 ON rp1.room_id = rooms.id AND rp1.user_id = ?", userA).
+		Joins("JOIN room_participants rp2 ON rp2.room_id = rooms.id AND rp2.user_id = ?", userB).
+		Where("(SELECT COUNT(*) FROM room_participants WHERE room_id = rooms.id) = 2").
+		First(&room).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &room, nil
+}
+
+func (s *GORM) AppendEvent(roomID int, event ws.Event) ([]byte, error) {
+	var data []byte
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		// Bumping the counter locks the room row, so sequence numbers are
+		// handed out and stored in the same order.
+		err := tx.Raw("UPDATE rooms SET last_seq = last_seq + 1 WHERE id = ? RETURNING last_seq", roomID).
+			Scan(&event.Seq).Error
+		if err != nil {
+			return err
+		}
+		if event.Seq == 0 {
+			return ws.ErrRoomNotFound
+		}
+
+		data, err = json.Marshal(event)
+		if err != nil {
+			return err
+		}
+
+		return tx.Create(&ws.RoomEvent{
+			RoomID: roomID,
+			Seq:    event.Seq,
+			Type:   event.Type,
+			Data:   string(data),
+		}).Error
+	})
+	return data, err
+}
+
+func (s *GORM) LastSeq(roomID int) (int64, error) {
+	var room ws.Room
+	if err := s.db.Select("id", "last_seq").First(&room, roomID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return 0, ws.ErrRoomNotFound
+		}
+		return 0, err
+	}
+	return room.LastSeq, nil
+}
+
+func (s *GORM) EventsAfter(roomID int, seq int64, limit int) ([]ws.RoomEvent, error) {
+	var events []ws.RoomEvent
+	err := s.db.Where("room_id = ? AND seq > ?", roomID, seq).
+		Order("seq").
+		Limit(limit).
+		Find(&events).Error
+	return events, err
+}
+
+func (s *GORM) PruneEvents(before time.Time) error {
+	return s.db.Where("created_at < ?", before).Delete(&ws.RoomEvent{}).Error
+}
+
+func (s *GORM) GetParticipant(roomID, userID int) (*ws.RoomParticipant, error) {
+	var participant ws.RoomParticipant
+	err := s.db.Where("room_id = ? AND user_id = ?", roomID, userID).First(&participant).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &participant, nil
+}
+
+func (s *GORM) AddParticipant(participant *ws.RoomParticipant) error {
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		if err := ensureUser(tx, participant.UserID); err != nil {
+			return err
+		}
+
+		result := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(participant)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ws.ErrAlreadyParticipant
+		}
+		return nil
+	})
+}
+
+func (s *GORM) Participants(roomID int) ([]ws.RoomParticipant, error) {
+	var participants []ws.RoomParticipant
+	err := s.db.Where("room_id = ?", roomID).Order("user_id").Find(&participants).Error
+	return participants, err
+}
+
+func (s *GORM) UserRooms(userID int) ([]ws.RoomParticipant, error) {
+	var participants []ws.RoomParticipant
+	err := s.db.Where("user_id = ?", userID).Preload("Room").Find(&participants).Error
+	return participants, err
+}
+
+func (s *GORM) RoomIDs(userID int) ([]int, error) {
+	var roomIDs []int
+	err := s.db.Model(&ws.RoomParticipant{}).
+		Where("user_id = ?", userID).
+		Pluck("room_id", &roomIDs).Error
+	return roomIDs, err
+}
+
+func ensureUser(tx *gorm.DB, userID int) error {
+	return tx.FirstOrCreate(&ws.User{ID: userID}).Error
+}
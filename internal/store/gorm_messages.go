@@ -0,0 +1,512 @@
+package store
+
+import (
+	"errors"
+	"html"
+	"strings"
+	"time"
+	"ws-whatever/ws"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+func (s *GORM) CreateMessage(message *ws.Message, attachmentIDs []int) ([]ws.AttachmentPayload, error) {
+	var attachments []ws.AttachmentPayload
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(message).Error; err != nil {
+			return err
+		}
+
+		var err error
+		attachments, err = claimAttachments(tx, *message, attachmentIDs)
+		return err
+	})
+	return attachments, err
+}
+
+// claimAttachments links pending uploads to a freshly created message.
+func claimAttachments(tx *gorm.DB, message ws.Message, attachmentIDs []int) ([]ws.AttachmentPayload, error) {
+	if len(attachmentIDs) == 0 {
+		return nil, nil
+	}
+
+	result := tx.Model(&ws.MessageAttachment{}).
+		Where("id IN ? AND room_id = ? AND uploader_id = ? AND message_id IS NULL", attachmentIDs, message.RoomID, message.SenderID).
+		Update("message_id", message.ID)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if int(result.RowsAffected) != len(attachmentIDs) {
+		return nil, ws.ErrInvalidAttachments
+	}
+
+	attachments, err := loadAttachments(tx, []int{message.ID})
+	if err != nil {
+		return nil, err
+	}
+	return attachments[message.ID], nil
+}
+
+func (s *GORM) GetMessage(messageID int) (*ws.Message, error) {
+	var message ws.Message
+	if err := s.db.Where("deleted_at IS NULL").First(&message, messageID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ws.ErrMessageNotFound
+		}
+		return nil, err
+	}
+	return &message, nil
+}
+
+func (s *GORM) FindByClientMsgID(senderID int, clientMsgID string) (*ws.Message, error) {
+	if clientMsgID == "" {
+		return nil, nil
+	}
+
+	var message ws.Message
+	err := s.db.Where("sender_id = ? AND client_msg_id = ?", senderID, clientMsgID).First(&message).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &message, nil
+}
+
+func (s *GORM) ReviseMessage(message *ws.Message, editorID int, content string, at time.Time) error {
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		revision := ws.MessageRevision{
+			MessageID: message.ID,
+			Content:   message.Content,
+			EditedBy:  editorID,
+		}
+		if err := tx.Create(&revision).Error; err != nil {
+			return err
+		}
+
+		result := tx.Model(&ws.Message{}).
+			Where("id = ? AND deleted_at IS NULL", message.ID).
+			Updates(map[string]interface{}{
+				"content":    content,
+				"is_edited":  true,
+				"updated_at": at,
+			})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ws.ErrMessageNotFound
+		}
+
+		message.Content = content
+		message.IsEdited = true
+		message.UpdatedAt = &at
+		return nil
+	})
+}
+
+func (s *GORM) DeleteMessage(messageID int, at time.Time) error {
+	return s.db.Model(&ws.Message{}).
+		Where("id = ?", messageID).
+		Update("deleted_at", at).Error
+}
+
+func (s *GORM) SetPinned(messageID int, pinned bool) error {
+	return s.db.Model(&ws.Message{}).
+		Where("id = ?", messageID).
+		Update("is_pinned", pinned).Error
+}
+
+// History uses the (room_id, created_at) index as a keyset.
+func (s *GORM) History(roomID int, cursor ws.HistoryCursor, limit int) ([]ws.Message, *int, error) {
+	query := s.db.Where("room_id = ? AND reply_to_id IS NULL AND deleted_at IS NULL", roomID)
+	return s.history(query, roomID, cursor, limit)
+}
+
+func (s *GORM) ThreadReplies(root ws.Message, cursor ws.HistoryCursor, limit int) ([]ws.Message, *int, error) {
+	query := s.db.Where("reply_to_id = ? AND deleted_at IS NULL", root.ID)
+	return s.history(query, root.RoomID, cursor, limit)
+}
+
+// history pages through the messages query selects, all from roomID.
+func (s *GORM) history(query *gorm.DB, roomID int, cursor ws.HistoryCursor, limit int) ([]ws.Message, *int, error) {
+	if cursor.BeforeID != nil {
+		pivot, err := s.cursorMessage(roomID, *cursor.BeforeID)
+		if err != nil {
+			return nil, nil, err
+		}
+		query = query.Where("(created_at, id) < (?, ?)", pivot.CreatedAt, pivot.ID)
+	}
+
+	if cursor.AfterID != nil {
+		pivot, err := s.cursorMessage(roomID, *cursor.AfterID)
+		if err != nil {
+			return nil, nil, err
+		}
+		query = query.Where("(created_at, id) > (?, ?)", pivot.CreatedAt, pivot.ID)
+	}
+
+	forward := cursor.AfterID != nil && cursor.BeforeID == nil
+	if forward {
+		query = query.Order("created_at ASC, id ASC")
+	} else {
+		query = query.Order("created_at DESC, id DESC")
+	}
+
+	var messages []ws.Message
+	if err := query.Limit(limit + 1).Find(&messages).Error; err != nil {
+		return nil, nil, err
+	}
+
+	messages, next := page(messages, limit, forward)
+	return messages, next, nil
+}
+
+func (s *GORM) cursorMessage(roomID, messageID int) (ws.Message, error) {
+	var message ws.Message
+	err := s.db.Select("id", "created_at").
+		Where("room_id = ?", roomID).
+		First(&message, messageID).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return message, ws.ErrInvalidCursor
+	}
+	return message, err
+}
+
+func (s *GORM) ThreadSummaries(messageIDs []int) (map[int]ws.ThreadSummary, error) {
+	summaries := make(map[int]ws.ThreadSummary)
+	if len(messageIDs) == 0 {
+		return summaries, nil
+	}
+
+	var rows []struct {
+		ReplyToID  int
+		ReplyCount int
+		LastID     int
+	}
+	err := s.db.Model(&ws.Message{}).
+		Select("reply_to_id, COUNT(*) AS reply_count, MAX(id) AS last_id").
+		Where("reply_to_id IN ? AND deleted_at IS NULL", messageIDs).
+		Group("reply_to_id").
+		Scan(&rows).Error
+	if err != nil || len(rows) == 0 {
+		return summaries, err
+	}
+
+	// The newest reply is also the last one inserted. Its created_at comes
+	// from a second query because aggregates lose the column type on
+	// SQLite, which then cannot scan it as a time.
+	lastIDs := make([]int, len(rows))
+	for i, row := range rows {
+		lastIDs[i] = row.LastID
+	}
+	var last []ws.Message
+	if err := s.db.Select("id", "reply_to_id", "created_at").Find(&last, lastIDs).Error; err != nil {
+		return nil, err
+	}
+
+	for _, row := range rows {
+		summaries[row.ReplyToID] = ws.ThreadSummary{ReplyCount: row.ReplyCount}
+	}
+	for _, m := range last {
+		summary := summaries[*m.ReplyToID]
+		summary.LastReplyAt = &m.CreatedAt
+		summaries[*m.ReplyToID] = summary
+	}
+	return summaries, nil
+}
+
+func (s *GORM) PinnedMessages(roomID int) ([]ws.Message, error) {
+	var messages []ws.Message
+	err := s.db.
+		Where("room_id = ? AND is_pinned = ? AND deleted_at IS NULL", roomID, true).
+		Order("created_at DESC").
+		Find(&messages).Error
+	return messages, err
+}
+
+func (s *GORM) Revisions(messageID int) ([]ws.MessageRevision, error) {
+	var revisions []ws.MessageRevision
+	err := s.db.
+		Where("message_id = ?", messageID).
+		Order("created_at ASC").
+		Find(&revisions).Error
+	return revisions, err
+}
+
+// searchLanguage is the text search configuration of the
+// idx_messages_content_search index. Queries must use the same one for
+// Postgres to pick the index.
+const searchLanguage = "english"
+
+// ts_headline puts these around highlighted terms. They become <mark> tags
+// once the rest of the headline has been escaped.
+const (
+	headlineStart = "\x02"
+	headlineStop  = "\x03"
+)
+
+// searchRow is a message with the columns only a search selects.
+type searchRow struct {
+	ws.Message
+	Rank     float64
+	Headline string
+}
+
+func (s *GORM) SearchMessages(q ws.MessageQuery) ([]ws.SearchResult, *int, error) {
+	query := s.db.Model(&ws.Message{}).
+		Where("messages.deleted_at IS NULL").
+		Where("messages.room_id IN (?)",
+			s.db.Model(&ws.RoomParticipant{}).Select("room_id").Where("user_id = ?", q.UserID))
+
+	if q.RoomID != 0 {
+		query = query.Where("messages.room_id = ?", q.RoomID)
+	}
+	if q.SenderID != 0 {
+		query = query.Where("messages.sender_id = ?", q.SenderID)
+	}
+	if !q.Since.IsZero() {
+		query = query.Where("messages.created_at >= ?", q.Since)
+	}
+	if !q.Until.IsZero() {
+		query = query.Where("messages.created_at < ?", q.Until)
+	}
+	if q.HasAttachments {
+		query = query.Where("EXISTS (SELECT 1 FROM message_attachments WHERE message_attachments.message_id = messages.id)")
+	}
+	if q.Pinned {
+		query = query.Where("messages.is_pinned = ?", true)
+	}
+
+	fullText := s.db.Dialector.Name() == "postgres"
+	if fullText {
+		document := "to_tsvector('" + searchLanguage + "', messages.content)"
+		query = query.
+			Joins("CROSS JOIN websearch_to_tsquery('"+searchLanguage+"', ?) AS search_query", q.Text).
+			Where(document+" @@ search_query").
+			Select("messages.*, ts_rank("+document+", search_query) AS rank, "+
+				"ts_headline('"+searchLanguage+"', messages.content, search_query, ?) AS headline",
+				`StartSel="`+headlineStart+`", StopSel="`+headlineStop+`"`)
+		if q.ByRank {
+			query = query.Order("rank DESC")
+		}
+	} else {
+		// Other databases fall back to a substring match. LIKE ignores
+		// ASCII case on SQLite.
+		query = query.Where(`messages.content LIKE ? ESCAPE '\'`, "%"+escapeLike(q.Text)+"%")
+	}
+
+	if q.ByRank {
+		query = query.Offset(q.Offset)
+	} else if q.BeforeID != nil {
+		var pivot ws.Message
+		if err := s.db.Select("id", "created_at").First(&pivot, *q.BeforeID).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return nil, nil, ws.ErrInvalidCursor
+			}
+			return nil, nil, err
+		}
+		query = query.Where("(messages.created_at, messages.id) < (?, ?)", pivot.CreatedAt, pivot.ID)
+	}
+
+	var rows []searchRow
+	err := query.
+		Order("messages.created_at DESC, messages.id DESC").
+		Limit(q.Limit + 1).
+		Scan(&rows).Error
+	if err != nil {
+		return nil, nil, err
+	}
+
+	results := make([]ws.SearchResult, len(rows))
+	for i, row := range rows {
+		results[i] = ws.SearchResult{Message: row.Message, Rank: row.Rank}
+		if fullText {
+			results[i].Headline = strings.NewReplacer(headlineStart, "<mark>", headlineStop, "</mark>").
+				Replace(html.EscapeString(row.Headline))
+		} else {
+			results[i].Headline = highlight(row.Content, q.Text)
+		}
+	}
+
+	results, next := searchPage(results, q)
+	return results, next, nil
+}
+
+func (s *GORM) AddReaction(messageID, userID int, reactionType string) (bool, error) {
+	reaction := ws.MessageReaction{
+		MessageID:    messageID,
+		UserID:       userID,
+		ReactionType: reactionType,
+	}
+	result := s.db.Clauses(clause.OnConflict{DoNothing: true}).Create(&reaction)
+	return result.RowsAffected > 0, result.Error
+}
+
+func (s *GORM) RemoveReaction(messageID, userID int, reactionType string) (bool, error) {
+	result := s.db.
+		Where("message_id = ? AND user_id = ? AND reaction_type = ?", messageID, userID, reactionType).
+		Delete(&ws.MessageReaction{})
+	return result.RowsAffected > 0, result.Error
+}
+
+type reactionRow struct {
+	MessageID    int
+	ReactionType string
+	Count        int
+	Mine         int
+}
+
+func (s *GORM) ReactionCounts(messageID int) (map[string]int, error) {
+	var rows []reactionRow
+	err := s.db.Model(&ws.MessageReaction{}).
+		Select("reaction_type, COUNT(*) AS count").
+		Where("message_id = ?", messageID).
+		Group("reaction_type").
+		Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+
+	counts := make(map[string]int, len(rows))
+	for _, row := range rows {
+		counts[row.ReactionType] = row.Count
+	}
+	return counts, nil
+}
+
+func (s *GORM) ReactionSummaries(userID int, messageIDs []int) (map[int][]ws.ReactionSummary, error) {
+	summaries := make(map[int][]ws.ReactionSummary)
+	if len(messageIDs) == 0 {
+		return summaries, nil
+	}
+
+	var rows []reactionRow
+	err := s.db.Model(&ws.MessageReaction{}).
+		Select("message_id, reaction_type, COUNT(*) AS count, SUM(CASE WHEN user_id = ? THEN 1 ELSE 0 END) AS mine", userID).
+		Where("message_id IN ?", messageIDs).
+		Group("message_id, reaction_type").
+		Order("MIN(id)").
+		Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+
+	for _, row := range rows {
+		summaries[row.MessageID] = append(summaries[row.MessageID], ws.ReactionSummary{
+			Type:        row.ReactionType,
+			Count:       row.Count,
+			ReactedByMe: row.Mine > 0,
+		})
+	}
+	return summaries, nil
+}
+
+func (s *GORM) MarkRead(message ws.Message, userID int, at time.Time) (int, error) {
+	result := s.db.Exec(`
+		INSERT INTO message_reads (message_id, user_id, read_at)
+		SELECT id, ?, ? FROM messages
+		WHERE room_id = ? AND created_at <= ? AND sender_id <> ? AND deleted_at IS NULL
+		ON CONFLICT (message_id, user_id) DO NOTHING`,
+		userID, at, message.RoomID, message.CreatedAt, userID,
+	)
+	return int(result.RowsAffected), result.Error
+}
+
+type roomCount struct {
+	RoomID int
+	Count  int
+}
+
+func (s *GORM) UnreadCounts(userID int) (map[int]int, error) {
+	var rows []roomCount
+	err := s.db.Table("messages m").
+		Select("m.room_id, COUNT(*) AS count").
+		Joins("JOIN room_participants rp ON rp.room_id = m.room_id AND rp.user_id = ?", userID).
+		Where("m.deleted_at IS NULL AND m.sender_id <> ?", userID).
+		Where("NOT EXISTS (SELECT 1 FROM message_reads r WHERE r.message_id = m.id AND r.user_id = ?)", userID).
+		Group("m.room_id").
+		Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+
+	counts := make(map[int]int, len(rows))
+	for _, row := range rows {
+		counts[row.RoomID] = row.Count
+	}
+	return counts, nil
+}
+
+type roomLastRead struct {
+	RoomID    int
+	MessageID int
+}
+
+func (s *GORM) LastReadMessages(userID int) (map[int]int, error) {
+	var rows []roomLastRead
+	err := s.db.Table("message_reads r").
+		Select("m.room_id, MAX(m.id) AS message_id").
+		Joins("JOIN messages m ON m.id = r.message_id").
+		Where("r.user_id = ?", userID).
+		Group("m.room_id").
+		Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+
+	lastRead := make(map[int]int, len(rows))
+	for _, row := range rows {
+		lastRead[row.RoomID] = row.MessageID
+	}
+	return lastRead, nil
+}
+
+func (s *GORM) CreateAttachment(attachment *ws.MessageAttachment) error {
+	return s.db.Create(attachment).Error
+}
+
+func (s *GORM) GetAttachment(attachmentID int) (*ws.MessageAttachment, error) {
+	var attachment ws.MessageAttachment
+	if err := s.db.First(&attachment, attachmentID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ws.ErrAttachmentNotFound
+		}
+		return nil, err
+	}
+	return &attachment, nil
+}
+
+func (s *GORM) Attachments(messageIDs []int) (map[int][]ws.AttachmentPayload, error) {
+	return loadAttachments(s.db, messageIDs)
+}
+
+func (s *GORM) UnclaimedAttachments(before time.Time, limit int) ([]ws.MessageAttachment, error) {
+	var attachments []ws.MessageAttachment
+	err := s.db.Where("message_id IS NULL AND created_at < ?", before).Order("id").Limit(limit).Find(&attachments).Error
+	return attachments, err
+}
+
+func (s *GORM) DeleteUnclaimedAttachment(attachmentID int) (bool, error) {
+	result := s.db.Where("id = ? AND message_id IS NULL", attachmentID).Delete(&ws.MessageAttachment{})
+	return result.RowsAffected == 1, result.Error
+}
+
+func loadAttachments(db *gorm.DB, messageIDs []int) (map[int][]ws.AttachmentPayload, error) {
+	attachments := make(map[int][]ws.AttachmentPayload)
+	if len(messageIDs) == 0 {
+		return attachments, nil
+	}
+
+	var rows []ws.MessageAttachment
+	if err := db.Where("message_id IN ?", messageIDs).Order("id").Find(&rows).Error; err != nil {
+		return nil, err
+	}
+
+	for _, row := range rows {
+		attachments[*row.MessageID] = append(attachments[*row.MessageID], ws.NewAttachmentPayload(row))
+	}
+	return attachments, nil
+}
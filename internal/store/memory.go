@@ -0,0 +1,270 @@
+package store
+
+import (
+	"encoding/json"
+	"slices"
+	"sync"
+	"time"
+	"ws-whatever/ws"
+)
+
+// Memory keeps everything in process. It behaves like GORM for the
+// messaging flow, which makes it useful for tests. Every row takes its ID
+// from one shared counter.
+type Memory struct {
+	mu sync.Mutex
+
+	lastID       int
+	rooms        []ws.Room
+	participants []ws.RoomParticipant
+	events       []ws.RoomEvent
+	messages     []ws.Message
+	revisions    []ws.MessageRevision
+	reactions    []ws.MessageReaction
+	reads        []ws.MessageRead
+	attachments  []ws.MessageAttachment
+}
+
+func NewMemory() *Memory {
+	return &Memory{}
+}
+
+// Stores returns s as each of the ws stores.
+func (s *Memory) Stores() ws.Stores {
+	return ws.Stores{Messages: s, Rooms: s, Participants: s}
+}
+
+func (s *Memory) nextID() int {
+	s.lastID++
+	return s.lastID
+}
+
+func (s *Memory) CreateRoom(room *ws.Room, participants []ws.RoomParticipant) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	room.ID = s.nextID()
+	if room.CreatedAt.IsZero() {
+		room.CreatedAt = time.Now()
+	}
+	s.rooms = append(s.rooms, *room)
+
+	for i := range participants {
+		participants[i].RoomID = room.ID
+		s.addParticipant(&participants[i])
+	}
+	return nil
+}
+
+func (s *Memory) GetRoom(roomID int) (*ws.Room, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	room := s.room(roomID)
+	if room == nil {
+		return nil, ws.ErrRoomNotFound
+	}
+	found := *room
+	return &found, nil
+}
+
+func (s *Memory) room(roomID int) *ws.Room {
+	for i := range s.rooms {
+		if s.rooms[i].ID == roomID {
+			return &s.rooms[i]
+		}
+	}
+	return nil
+}
+
+func (s *Memory) ListRooms(userID int) ([]ws.Room, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var rooms []ws.Room
+	for _, room := range s.rooms {
+		if (room.Type == ws.RoomTypeGroup && !room.IsPrivate) || s.participant(room.ID, userID) != nil {
+			rooms = append(rooms, room)
+		}
+	}
+	return rooms, nil
+}
+
+func (s *Memory) FindDirectRoom(communityID, userA, userB int) (*ws.Room, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, room := range s.rooms {
+		if room.CommunityID != communityID || room.Type != ws.RoomTypeDirect {
+			continue
+		}
+		if s.participant(room.ID, userA) != nil && s.participant(room.ID, userB) != nil && s.countParticipants(room.ID) == 2 {
+			return &room, nil
+		}
+	}
+	return nil, nil
+}
+
+func (s *Memory) AppendEvent(roomID int, event ws.Event) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	room := s.room(roomID)
+	if room == nil {
+		return nil, ws.ErrRoomNotFound
+	}
+
+	event.Seq = room.LastSeq + 1
+	data, err := json.Marshal(event)
+	if err != nil {
+		return nil, err
+	}
+
+	room.LastSeq = event.Seq
+	s.events = append(s.events, ws.RoomEvent{
+		ID:        s.nextID(),
+		RoomID:    roomID,
+		Seq:       event.Seq,
+		Type:      event.Type,
+		Data:      string(data),
+		CreatedAt: time.Now(),
+	})
+	return data, nil
+}
+
+func (s *Memory) LastSeq(roomID int) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	room := s.room(roomID)
+	if room == nil {
+		return 0, ws.ErrRoomNotFound
+	}
+	return room.LastSeq, nil
+}
+
+func (s *Memory) EventsAfter(roomID int, seq int64, limit int) ([]ws.RoomEvent, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Events are appended in sequence order.
+	var events []ws.RoomEvent
+	for _, event := range s.events {
+		if len(events) == limit {
+			break
+		}
+		if event.RoomID == roomID && event.Seq > seq {
+			events = append(events, event)
+		}
+	}
+	return events, nil
+}
+
+func (s *Memory) PruneEvents(before time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.events = slices.DeleteFunc(s.events, func(event ws.RoomEvent) bool {
+		return event.CreatedAt.Before(before)
+	})
+	return nil
+}
+
+func (s *Memory) GetParticipant(roomID, userID int) (*ws.RoomParticipant, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	participant := s.participant(roomID, userID)
+	if participant == nil {
+		return nil, nil
+	}
+	found := *participant
+	return &found, nil
+}
+
+func (s *Memory) participant(roomID, userID int) *ws.RoomParticipant {
+	for i := range s.participants {
+		if s.participants[i].RoomID == roomID && s.participants[i].UserID == userID {
+			return &s.participants[i]
+		}
+	}
+	return nil
+}
+
+func (s *Memory) countParticipants(roomID int) int {
+	count := 0
+	for _, p := range s.participants {
+		if p.RoomID == roomID {
+			count++
+		}
+	}
+	return count
+}
+
+func (s *Memory) AddParticipant(participant *ws.RoomParticipant) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.participant(participant.RoomID, participant.UserID) != nil {
+		return ws.ErrAlreadyParticipant
+	}
+	s.addParticipant(participant)
+	return nil
+}
+
+func (s *Memory) addParticipant(participant *ws.RoomParticipant) {
+	participant.ID = s.nextID()
+	if participant.JoinedAt.IsZero() {
+		participant.JoinedAt = time.Now()
+	}
+	s.participants = append(s.participants, *participant)
+}
+
+func (s *Memory) Participants(roomID int) ([]ws.RoomParticipant, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var participants []ws.RoomParticipant
+	for _, p := range s.participants {
+		if p.RoomID == roomID {
+			participants = append(participants, p)
+		}
+	}
+	slices.SortFunc(participants, func(a, b ws.RoomParticipant) int {
+		return a.UserID - b.UserID
+	})
+	return participants, nil
+}
+
+func (s *Memory) UserRooms(userID int) ([]ws.RoomParticipant, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var participants []ws.RoomParticipant
+	for _, p := range s.participants {
+		if p.UserID == userID {
+			if room := s.room(p.RoomID); room != nil {
+				p.Room = *room
+			}
+			participants = append(participants, p)
+		}
+	}
+	return participants, nil
+}
+
+func (s *Memory) RoomIDs(userID int) ([]int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.roomIDs(userID), nil
+}
+
+func (s *Memory) roomIDs(userID int) []int {
+	var roomIDs []int
+	for _, p := range s.participants {
+		if p.UserID == userID {
+			roomIDs = append(roomIDs, p.RoomID)
+		}
+	}
+	return roomIDs
+}
@@ -0,0 +1,522 @@
+package store
+
+import (
+	"errors"
+	"slices"
+	"strings"
+	"time"
+	"ws-whatever/ws"
+)
+
+var errDuplicateClientMsgID = errors.New("duplicate client_msg_id")
+
+func (s *Memory) CreateMessage(message *ws.Message, attachmentIDs []int) ([]ws.AttachmentPayload, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if message.ClientMsgID != nil && s.findByClientMsgID(message.SenderID, *message.ClientMsgID) != nil {
+		return nil, errDuplicateClientMsgID
+	}
+
+	// Check every attachment before touching any, like the rolled back
+	// transaction would.
+	var claimed []int
+	for i, a := range s.attachments {
+		if slices.Contains(attachmentIDs, a.ID) && a.RoomID == message.RoomID && a.UploaderID == message.SenderID && a.MessageID == nil {
+			claimed = append(claimed, i)
+		}
+	}
+	if len(claimed) != len(attachmentIDs) {
+		return nil, ws.ErrInvalidAttachments
+	}
+
+	message.ID = s.nextID()
+	if message.CreatedAt.IsZero() {
+		message.CreatedAt = time.Now()
+	}
+	s.messages = append(s.messages, *message)
+
+	var attachments []ws.AttachmentPayload
+	for _, i := range claimed {
+		messageID := message.ID
+		s.attachments[i].MessageID = &messageID
+		attachments = append(attachments, ws.NewAttachmentPayload(s.attachments[i]))
+	}
+	return attachments, nil
+}
+
+func (s *Memory) message(messageID int) *ws.Message {
+	for i := range s.messages {
+		if s.messages[i].ID == messageID {
+			return &s.messages[i]
+		}
+	}
+	return nil
+}
+
+func (s *Memory) GetMessage(messageID int) (*ws.Message, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	message := s.message(messageID)
+	if message == nil || message.DeletedAt != nil {
+		return nil, ws.ErrMessageNotFound
+	}
+	found := *message
+	return &found, nil
+}
+
+func (s *Memory) FindByClientMsgID(senderID int, clientMsgID string) (*ws.Message, error) {
+	if clientMsgID == "" {
+		return nil, nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	message := s.findByClientMsgID(senderID, clientMsgID)
+	if message == nil {
+		return nil, nil
+	}
+	found := *message
+	return &found, nil
+}
+
+func (s *Memory) findByClientMsgID(senderID int, clientMsgID string) *ws.Message {
+	for i, m := range s.messages {
+		if m.SenderID == senderID && m.ClientMsgID != nil && *m.ClientMsgID == clientMsgID {
+			return &s.messages[i]
+		}
+	}
+	return nil
+}
+
+func (s *Memory) ReviseMessage(message *ws.Message, editorID int, content string, at time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored := s.message(message.ID)
+	if stored == nil || stored.DeletedAt != nil {
+		return ws.ErrMessageNotFound
+	}
+
+	s.revisions = append(s.revisions, ws.MessageRevision{
+		ID:        s.nextID(),
+		MessageID: message.ID,
+		Content:   message.Content,
+		EditedBy:  editorID,
+		CreatedAt: at,
+	})
+
+	stored.Content = content
+	stored.IsEdited = true
+	stored.UpdatedAt = &at
+
+	message.Content = content
+	message.IsEdited = true
+	message.UpdatedAt = &at
+	return nil
+}
+
+func (s *Memory) DeleteMessage(messageID int, at time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if message := s.message(messageID); message != nil {
+		message.DeletedAt = &at
+	}
+	return nil
+}
+
+func (s *Memory) SetPinned(messageID int, pinned bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if message := s.message(messageID); message != nil {
+		message.IsPinned = pinned
+	}
+	return nil
+}
+
+// compareMessages orders messages by (created_at, id) like the history index.
+func compareMessages(a, b ws.Message) int {
+	if c := a.CreatedAt.Compare(b.CreatedAt); c != 0 {
+		return c
+	}
+	return a.ID - b.ID
+}
+
+func (s *Memory) History(roomID int, cursor ws.HistoryCursor, limit int) ([]ws.Message, *int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.history(roomID, cursor, limit, func(m ws.Message) bool {
+		return m.RoomID == roomID && m.ReplyToID == nil
+	})
+}
+
+func (s *Memory) ThreadReplies(root ws.Message, cursor ws.HistoryCursor, limit int) ([]ws.Message, *int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.history(root.RoomID, cursor, limit, func(m ws.Message) bool {
+		return m.ReplyToID != nil && *m.ReplyToID == root.ID
+	})
+}
+
+// history pages through the messages match selects, all from roomID.
+func (s *Memory) history(roomID int, cursor ws.HistoryCursor, limit int, match func(ws.Message) bool) ([]ws.Message, *int, error) {
+	before, err := s.cursorMessage(roomID, cursor.BeforeID)
+	if err != nil {
+		return nil, nil, err
+	}
+	after, err := s.cursorMessage(roomID, cursor.AfterID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var messages []ws.Message
+	for _, m := range s.messages {
+		if !match(m) || m.DeletedAt != nil {
+			continue
+		}
+		if before != nil && compareMessages(m, *before) >= 0 {
+			continue
+		}
+		if after != nil && compareMessages(m, *after) <= 0 {
+			continue
+		}
+		messages = append(messages, m)
+	}
+
+	forward := cursor.AfterID != nil && cursor.BeforeID == nil
+	slices.SortFunc(messages, compareMessages)
+	if !forward {
+		slices.Reverse(messages)
+	}
+	if len(messages) > limit+1 {
+		messages = messages[:limit+1]
+	}
+
+	messages, next := page(messages, limit, forward)
+	return messages, next, nil
+}
+
+func (s *Memory) ThreadSummaries(messageIDs []int) (map[int]ws.ThreadSummary, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	summaries := make(map[int]ws.ThreadSummary)
+	for _, m := range s.messages {
+		if m.ReplyToID == nil || m.DeletedAt != nil || !slices.Contains(messageIDs, *m.ReplyToID) {
+			continue
+		}
+		summary := summaries[*m.ReplyToID]
+		summary.ReplyCount++
+		if summary.LastReplyAt == nil || m.CreatedAt.After(*summary.LastReplyAt) {
+			summary.LastReplyAt = &m.CreatedAt
+		}
+		summaries[*m.ReplyToID] = summary
+	}
+	return summaries, nil
+}
+
+func (s *Memory) cursorMessage(roomID int, messageID *int) (*ws.Message, error) {
+	if messageID == nil {
+		return nil, nil
+	}
+
+	message := s.message(*messageID)
+	if message == nil || message.RoomID != roomID {
+		return nil, ws.ErrInvalidCursor
+	}
+	return message, nil
+}
+
+func (s *Memory) PinnedMessages(roomID int) ([]ws.Message, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var messages []ws.Message
+	for _, m := range s.messages {
+		if m.RoomID == roomID && m.IsPinned && m.DeletedAt == nil {
+			messages = append(messages, m)
+		}
+	}
+	slices.SortFunc(messages, func(a, b ws.Message) int {
+		return b.CreatedAt.Compare(a.CreatedAt)
+	})
+	return messages, nil
+}
+
+func (s *Memory) Revisions(messageID int) ([]ws.MessageRevision, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var revisions []ws.MessageRevision
+	for _, r := range s.revisions {
+		if r.MessageID == messageID {
+			revisions = append(revisions, r)
+		}
+	}
+	return revisions, nil
+}
+
+func (s *Memory) SearchMessages(q ws.MessageQuery) ([]ws.SearchResult, *int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var pivot *ws.Message
+	if q.BeforeID != nil && !q.ByRank {
+		if pivot = s.message(*q.BeforeID); pivot == nil {
+			return nil, nil, ws.ErrInvalidCursor
+		}
+	}
+
+	roomIDs := s.roomIDs(q.UserID)
+	text := strings.ToLower(q.Text)
+	var messages []ws.Message
+	for _, m := range s.messages {
+		switch {
+		case m.DeletedAt != nil,
+			!slices.Contains(roomIDs, m.RoomID),
+			!strings.Contains(strings.ToLower(m.Content), text),
+			q.RoomID != 0 && m.RoomID != q.RoomID,
+			q.SenderID != 0 && m.SenderID != q.SenderID,
+			!q.Since.IsZero() && m.CreatedAt.Before(q.Since),
+			!q.Until.IsZero() && !m.CreatedAt.Before(q.Until),
+			q.HasAttachments && !s.hasAttachments(m.ID),
+			q.Pinned && !m.IsPinned,
+			pivot != nil && compareMessages(m, *pivot) >= 0:
+			continue
+		}
+		messages = append(messages, m)
+	}
+
+	slices.SortFunc(messages, func(a, b ws.Message) int {
+		return compareMessages(b, a)
+	})
+
+	// There is no rank here, so ranked searches are by date as well.
+	if q.ByRank {
+		messages = messages[min(q.Offset, len(messages)):]
+	}
+	messages = messages[:min(q.Limit+1, len(messages))]
+	results := make([]ws.SearchResult, len(messages))
+	for i, m := range messages {
+		results[i] = ws.SearchResult{Message: m, Headline: highlight(m.Content, q.Text)}
+	}
+
+	results, next := searchPage(results, q)
+	return results, next, nil
+}
+
+func (s *Memory) hasAttachments(messageID int) bool {
+	return slices.ContainsFunc(s.attachments, func(a ws.MessageAttachment) bool {
+		return a.MessageID != nil && *a.MessageID == messageID
+	})
+}
+
+func (s *Memory) reaction(messageID, userID int, reactionType string) int {
+	return slices.IndexFunc(s.reactions, func(r ws.MessageReaction) bool {
+		return r.MessageID == messageID && r.UserID == userID && r.ReactionType == reactionType
+	})
+}
+
+func (s *Memory) AddReaction(messageID, userID int, reactionType string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.reaction(messageID, userID, reactionType) >= 0 {
+		return false, nil
+	}
+
+	s.reactions = append(s.reactions, ws.MessageReaction{
+		ID:           s.nextID(),
+		MessageID:    messageID,
+		UserID:       userID,
+		ReactionType: reactionType,
+		CreatedAt:    time.Now(),
+	})
+	return true, nil
+}
+
+func (s *Memory) RemoveReaction(messageID, userID int, reactionType string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	i := s.reaction(messageID, userID, reactionType)
+	if i < 0 {
+		return false, nil
+	}
+	s.reactions = slices.Delete(s.reactions, i, i+1)
+	return true, nil
+}
+
+func (s *Memory) ReactionCounts(messageID int) (map[string]int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	counts := make(map[string]int)
+	for _, r := range s.reactions {
+		if r.MessageID == messageID {
+			counts[r.ReactionType]++
+		}
+	}
+	return counts, nil
+}
+
+func (s *Memory) ReactionSummaries(userID int, messageIDs []int) (map[int][]ws.ReactionSummary, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Reactions are kept in ID order, so types come out in the order they
+	// were first used.
+	summaries := make(map[int][]ws.ReactionSummary)
+	for _, r := range s.reactions {
+		if !slices.Contains(messageIDs, r.MessageID) {
+			continue
+		}
+
+		summary := summaries[r.MessageID]
+		i := slices.IndexFunc(summary, func(rs ws.ReactionSummary) bool { return rs.Type == r.ReactionType })
+		if i < 0 {
+			summary = append(summary, ws.ReactionSummary{Type: r.ReactionType})
+			i = len(summary) - 1
+		}
+		summary[i].Count++
+		summary[i].ReactedByMe = summary[i].ReactedByMe || r.UserID == userID
+		summaries[r.MessageID] = summary
+	}
+	return summaries, nil
+}
+
+func (s *Memory) hasRead(messageID, userID int) bool {
+	return slices.ContainsFunc(s.reads, func(r ws.MessageRead) bool {
+		return r.MessageID == messageID && r.UserID == userID
+	})
+}
+
+func (s *Memory) MarkRead(message ws.Message, userID int, at time.Time) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	marked := 0
+	for _, m := range s.messages {
+		if m.RoomID != message.RoomID || m.CreatedAt.After(message.CreatedAt) || m.SenderID == userID || m.DeletedAt != nil {
+			continue
+		}
+		if s.hasRead(m.ID, userID) {
+			continue
+		}
+
+		s.reads = append(s.reads, ws.MessageRead{
+			ID:        s.nextID(),
+			MessageID: m.ID,
+			UserID:    userID,
+			ReadAt:    &at,
+		})
+		marked++
+	}
+	return marked, nil
+}
+
+func (s *Memory) UnreadCounts(userID int) (map[int]int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	roomIDs := s.roomIDs(userID)
+	counts := make(map[int]int)
+	for _, m := range s.messages {
+		if m.DeletedAt != nil || m.SenderID == userID || !slices.Contains(roomIDs, m.RoomID) {
+			continue
+		}
+		if !s.hasRead(m.ID, userID) {
+			counts[m.RoomID]++
+		}
+	}
+	return counts, nil
+}
+
+func (s *Memory) LastReadMessages(userID int) (map[int]int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	lastRead := make(map[int]int)
+	for _, r := range s.reads {
+		if r.UserID != userID {
+			continue
+		}
+		if m := s.message(r.MessageID); m != nil && m.ID > lastRead[m.RoomID] {
+			lastRead[m.RoomID] = m.ID
+		}
+	}
+	return lastRead, nil
+}
+
+func (s *Memory) CreateAttachment(attachment *ws.MessageAttachment) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	attachment.ID = s.nextID()
+	if attachment.CreatedAt.IsZero() {
+		attachment.CreatedAt = time.Now()
+	}
+	s.attachments = append(s.attachments, *attachment)
+	return nil
+}
+
+func (s *Memory) GetAttachment(attachmentID int) (*ws.MessageAttachment, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, a := range s.attachments {
+		if a.ID == attachmentID {
+			return &a, nil
+		}
+	}
+	return nil, ws.ErrAttachmentNotFound
+}
+
+func (s *Memory) Attachments(messageIDs []int) (map[int][]ws.AttachmentPayload, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	attachments := make(map[int][]ws.AttachmentPayload)
+	for _, a := range s.attachments {
+		if a.MessageID != nil && slices.Contains(messageIDs, *a.MessageID) {
+			attachments[*a.MessageID] = append(attachments[*a.MessageID], ws.NewAttachmentPayload(a))
+		}
+	}
+	return attachments, nil
+}
+
+func (s *Memory) UnclaimedAttachments(before time.Time, limit int) ([]ws.MessageAttachment, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var attachments []ws.MessageAttachment
+	for _, a := range s.attachments {
+		if len(attachments) == limit {
+			break
+		}
+		if a.MessageID == nil && a.CreatedAt.Before(before) {
+			attachments = append(attachments, a)
+		}
+	}
+	return attachments, nil
+}
+
+func (s *Memory) DeleteUnclaimedAttachment(attachmentID int) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	i := slices.IndexFunc(s.attachments, func(a ws.MessageAttachment) bool {
+		return a.ID == attachmentID && a.MessageID == nil
+	})
+	if i < 0 {
+		return false, nil
+	}
+	s.attachments = slices.Delete(s.attachments, i, i+1)
+	return true, nil
+}
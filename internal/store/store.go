@@ -0,0 +1,85 @@
+// Package store implements the ws stores: GORM for the server and Memory
+// for tests that should not need a database.
+package store
+
+import (
+	"html"
+	"strings"
+	"ws-whatever/ws"
+)
+
+// page trims the limit+1 rows a history query fetched to limit and puts them
+// in chronological order. Rows are newest first unless forward is set. The
+// returned cursor continues in the same direction, or is nil at the end.
+func page(rows []ws.Message, limit int, forward bool) ([]ws.Message, *int) {
+	more := len(rows) > limit
+	if more {
+		rows = rows[:limit]
+	}
+
+	if !forward {
+		for i, j := 0, len(rows)-1; i < j; i, j = i+1, j-1 {
+			rows[i], rows[j] = rows[j], rows[i]
+		}
+	}
+
+	if !more {
+		return rows, nil
+	}
+
+	var next int
+	if forward {
+		next = rows[len(rows)-1].ID
+	} else {
+		next = rows[0].ID
+	}
+	return rows, &next
+}
+
+// searchPage trims the limit+1 results a search fetched to limit and
+// returns the next page: the offset of ranked searches, otherwise the
+// before_id cursor. It is nil at the end.
+func searchPage(results []ws.SearchResult, q ws.MessageQuery) ([]ws.SearchResult, *int) {
+	if len(results) <= q.Limit {
+		return results, nil
+	}
+
+	results = results[:q.Limit]
+	next := results[len(results)-1].Message.ID
+	if q.ByRank {
+		next = q.Offset + q.Limit
+	}
+	return results, &next
+}
+
+// escapeLike escapes the LIKE wildcards in s for use with ESCAPE '\'.
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
+
+// highlight escapes content for HTML and marks every case-insensitive
+// occurrence of text, as the LIKE search matches.
+func highlight(content, text string) string {
+	lower := strings.ToLower(content)
+	needle := strings.ToLower(text)
+	// Lowercasing can change byte lengths outside ASCII, which would put
+	// the marks in the wrong place.
+	if needle == "" || len(lower) != len(content) || len(needle) != len(text) {
+		return html.EscapeString(content)
+	}
+
+	var b strings.Builder
+	for {
+		i := strings.Index(lower, needle)
+		if i < 0 {
+			break
+		}
+		b.WriteString(html.EscapeString(content[:i]))
+		b.WriteString("<mark>")
+		b.WriteString(html.EscapeString(content[i : i+len(needle)]))
+		b.WriteString("</mark>")
+		content, lower = content[i+len(needle):], lower[i+len(needle):]
+	}
+	b.WriteString(html.EscapeString(content))
+	return b.String()
+}
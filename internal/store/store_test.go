@@ -0,0 +1,422 @@
+package store_test
+
+import (
+	"encoding/json"
+	"errors"
+	"maps"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+	"ws-whatever/internal/db"
+	"ws-whatever/internal/store"
+	"ws-whatever/ws"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// forEachStore runs test against the memory store and the GORM store on a
+// migrated SQLite database, so both implement the same contract.
+func forEachStore(t *testing.T, test func(t *testing.T, s ws.Stores)) {
+	t.Run("memory", func(t *testing.T) {
+		test(t, store.NewMemory().Stores())
+	})
+	t.Run("sqlite", func(t *testing.T) {
+		test(t, store.NewGORM(openSQLite(t)).Stores())
+	})
+}
+
+func openSQLite(t *testing.T) *gorm.DB {
+	t.Helper()
+
+	dsn := "file:" + filepath.Join(t.TempDir(), "test.db") + "?_foreign_keys=on"
+	conn, err := gorm.Open(sqlite.Open(dsn), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if sqlDB, err := conn.DB(); err == nil {
+			sqlDB.Close()
+		}
+	})
+
+	if err := db.RunMigration(conn); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	return conn
+}
+
+func createRoom(t *testing.T, s ws.Stores, userIDs ...int) int {
+	t.Helper()
+
+	room := ws.Room{Name: "room", CommunityID: 1, Type: ws.RoomTypeGroup}
+	participants := make([]ws.RoomParticipant, len(userIDs))
+	for i, userID := range userIDs {
+		participants[i] = ws.RoomParticipant{UserID: userID, Role: ws.RoleMember}
+	}
+	if err := s.Rooms.CreateRoom(&room, participants); err != nil {
+		t.Fatalf("create room: %v", err)
+	}
+	return room.ID
+}
+
+var base = time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+
+func createMessage(t *testing.T, s ws.Stores, roomID, senderID int, content string, at time.Time) ws.Message {
+	t.Helper()
+
+	message := ws.Message{RoomID: roomID, SenderID: senderID, Content: content, CreatedAt: at}
+	if _, err := s.Messages.CreateMessage(&message, nil); err != nil {
+		t.Fatalf("create message: %v", err)
+	}
+	return message
+}
+
+func ids(messages []ws.Message) []int {
+	ids := []int{}
+	for _, m := range messages {
+		ids = append(ids, m.ID)
+	}
+	return ids
+}
+
+func intPtrEqual(a, b *int) bool {
+	return (a == nil) == (b == nil) && (a == nil || *a == *b)
+}
+
+func TestAppendEvent(t *testing.T) {
+	forEachStore(t, func(t *testing.T, s ws.Stores) {
+		roomID := createRoom(t, s, 1)
+		other := createRoom(t, s, 1)
+
+		for want := int64(1); want <= 3; want++ {
+			data, err := s.Rooms.AppendEvent(roomID, ws.Event{Type: "new_message"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			var event ws.Event
+			if err := json.Unmarshal(data, &event); err != nil {
+				t.Fatal(err)
+			}
+			if event.Seq != want || event.Type != "new_message" {
+				t.Fatalf("event = %+v, want seq %d", event, want)
+			}
+		}
+		// Each room counts on its own.
+		if _, err := s.Rooms.AppendEvent(other, ws.Event{Type: "new_message"}); err != nil {
+			t.Fatal(err)
+		}
+
+		if seq, err := s.Rooms.LastSeq(roomID); err != nil || seq != 3 {
+			t.Fatalf("LastSeq() = %d, %v; want 3", seq, err)
+		}
+		if seq, err := s.Rooms.LastSeq(other); err != nil || seq != 1 {
+			t.Fatalf("LastSeq() of the other room = %d, %v; want 1", seq, err)
+		}
+
+		events, err := s.Rooms.EventsAfter(roomID, 1, 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(events) != 2 || events[0].Seq != 2 || events[1].Seq != 3 {
+			t.Fatalf("EventsAfter(1) = %+v, want seqs 2 and 3", events)
+		}
+		if events, _ := s.Rooms.EventsAfter(roomID, 0, 1); len(events) != 1 || events[0].Seq != 1 {
+			t.Fatalf("EventsAfter(0) limited to 1 = %+v, want seq 1", events)
+		}
+
+		missing := other + 100
+		if _, err := s.Rooms.AppendEvent(missing, ws.Event{Type: "new_message"}); !errors.Is(err, ws.ErrRoomNotFound) {
+			t.Fatalf("AppendEvent() on a missing room = %v, want ErrRoomNotFound", err)
+		}
+		if _, err := s.Rooms.LastSeq(missing); !errors.Is(err, ws.ErrRoomNotFound) {
+			t.Fatalf("LastSeq() on a missing room = %v, want ErrRoomNotFound", err)
+		}
+	})
+}
+
+func TestUnclaimedAttachments(t *testing.T) {
+	forEachStore(t, func(t *testing.T, s ws.Stores) {
+		roomID := createRoom(t, s, 1)
+
+		upload := func(at time.Time) ws.MessageAttachment {
+			t.Helper()
+
+			attachment := ws.MessageAttachment{
+				RoomID: roomID, UploaderID: 1, FileName: "a.txt", FilePath: "rooms/a.txt",
+				FileType: "file", FileSize: 1, FileMime: "text/plain", CreatedAt: at,
+			}
+			if err := s.Messages.CreateAttachment(&attachment); err != nil {
+				t.Fatal(err)
+			}
+			return attachment
+		}
+		old := upload(base)
+		claimed := upload(base)
+		upload(base.Add(2 * time.Hour))
+
+		message := ws.Message{RoomID: roomID, SenderID: 1, Content: "with file", CreatedAt: base}
+		if _, err := s.Messages.CreateMessage(&message, []int{claimed.ID}); err != nil {
+			t.Fatal(err)
+		}
+
+		unclaimed := func() []int {
+			t.Helper()
+
+			attachments, err := s.Messages.UnclaimedAttachments(base.Add(time.Hour), 10)
+			if err != nil {
+				t.Fatal(err)
+			}
+			ids := []int{}
+			for _, a := range attachments {
+				ids = append(ids, a.ID)
+			}
+			return ids
+		}
+		if got := unclaimed(); !slices.Equal(got, []int{old.ID}) {
+			t.Fatalf("UnclaimedAttachments() = %v, want %v", got, []int{old.ID})
+		}
+
+		tests := []struct {
+			name string
+			id   int
+			want bool
+		}{
+			{name: "claimed", id: claimed.ID, want: false},
+			{name: "unclaimed", id: old.ID, want: true},
+			{name: "already deleted", id: old.ID, want: false},
+		}
+		for _, tt := range tests {
+			if got, err := s.Messages.DeleteUnclaimedAttachment(tt.id); err != nil || got != tt.want {
+				t.Fatalf("%s: DeleteUnclaimedAttachment() = %v, %v; want %v", tt.name, got, err, tt.want)
+			}
+		}
+
+		if got := unclaimed(); len(got) != 0 {
+			t.Fatalf("UnclaimedAttachments() after deleting = %v, want none", got)
+		}
+		if _, err := s.Messages.GetAttachment(claimed.ID); err != nil {
+			t.Fatalf("claimed attachment: %v", err)
+		}
+	})
+}
+
+func TestHistory(t *testing.T) {
+	forEachStore(t, func(t *testing.T, s ws.Stores) {
+		roomID := createRoom(t, s, 1)
+		other := createRoom(t, s, 1)
+
+		// The middle two share a timestamp, so the keyset has to fall back
+		// to the ID.
+		var messages []ws.Message
+		for _, offset := range []time.Duration{0, time.Second, time.Second, 2 * time.Second, 3 * time.Second} {
+			messages = append(messages, createMessage(t, s, roomID, 1, "message", base.Add(offset)))
+		}
+		m := ids(messages)
+
+		reply := ws.Message{RoomID: roomID, SenderID: 1, Content: "reply", ReplyToID: &m[0], CreatedAt: base.Add(time.Minute)}
+		if _, err := s.Messages.CreateMessage(&reply, nil); err != nil {
+			t.Fatal(err)
+		}
+		deleted := createMessage(t, s, roomID, 1, "deleted", base.Add(time.Minute))
+		if err := s.Messages.DeleteMessage(deleted.ID, base.Add(time.Hour)); err != nil {
+			t.Fatal(err)
+		}
+		elsewhere := createMessage(t, s, other, 1, "elsewhere", base)
+
+		tests := []struct {
+			name     string
+			cursor   ws.HistoryCursor
+			limit    int
+			wantIDs  []int
+			wantNext *int
+			wantErr  error
+		}{
+			{name: "latest", limit: 2, wantIDs: m[3:], wantNext: &m[3]},
+			{name: "before a tie", cursor: ws.HistoryCursor{BeforeID: &m[2]}, limit: 2, wantIDs: m[:2], wantNext: nil},
+			{name: "before the newest", cursor: ws.HistoryCursor{BeforeID: &m[4]}, limit: 2, wantIDs: m[2:4], wantNext: &m[2]},
+			{name: "after the oldest", cursor: ws.HistoryCursor{AfterID: &m[0]}, limit: 2, wantIDs: m[1:3], wantNext: &m[2]},
+			{name: "after a tie", cursor: ws.HistoryCursor{AfterID: &m[1]}, limit: 3, wantIDs: m[2:]},
+			{name: "between", cursor: ws.HistoryCursor{AfterID: &m[0], BeforeID: &m[4]}, limit: 10, wantIDs: m[1:4]},
+			{name: "cursor from another room", cursor: ws.HistoryCursor{BeforeID: &elsewhere.ID}, limit: 2, wantErr: ws.ErrInvalidCursor},
+		}
+
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				got, next, err := s.Messages.History(roomID, tt.cursor, tt.limit)
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("History() error = %v, want %v", err, tt.wantErr)
+				}
+				if tt.wantErr != nil {
+					return
+				}
+				if !slices.Equal(ids(got), tt.wantIDs) {
+					t.Fatalf("History() = %v, want %v", ids(got), tt.wantIDs)
+				}
+				if !intPtrEqual(next, tt.wantNext) {
+					t.Fatalf("next = %v, want %v", next, tt.wantNext)
+				}
+			})
+		}
+	})
+}
+
+func TestMarkRead(t *testing.T) {
+	forEachStore(t, func(t *testing.T, s ws.Stores) {
+		roomID := createRoom(t, s, 1, 2)
+		var sent []ws.Message
+		for i := range 3 {
+			sent = append(sent, createMessage(t, s, roomID, 1, "message", base.Add(time.Duration(i)*time.Second)))
+		}
+		own := createMessage(t, s, roomID, 2, "own", base.Add(time.Minute))
+		deleted := createMessage(t, s, roomID, 1, "deleted", base.Add(time.Second))
+		if err := s.Messages.DeleteMessage(deleted.ID, base.Add(time.Hour)); err != nil {
+			t.Fatal(err)
+		}
+
+		tests := []struct {
+			name         string
+			message      ws.Message
+			userID       int
+			wantMarked   int
+			wantUnread   map[int]int
+			wantLastRead map[int]int
+		}{
+			{
+				name:         "up to a message",
+				message:      sent[1],
+				userID:       2,
+				wantMarked:   2,
+				wantUnread:   map[int]int{roomID: 1},
+				wantLastRead: map[int]int{roomID: sent[1].ID},
+			},
+			{
+				name:         "again",
+				message:      sent[1],
+				userID:       2,
+				wantUnread:   map[int]int{roomID: 1},
+				wantLastRead: map[int]int{roomID: sent[1].ID},
+			},
+			{
+				name:         "up to their own message",
+				message:      own,
+				userID:       2,
+				wantMarked:   1,
+				wantUnread:   map[int]int{},
+				wantLastRead: map[int]int{roomID: sent[2].ID},
+			},
+			{
+				name:         "the other user",
+				message:      own,
+				userID:       1,
+				wantMarked:   1,
+				wantUnread:   map[int]int{},
+				wantLastRead: map[int]int{roomID: own.ID},
+			},
+		}
+
+		at := base.Add(time.Hour)
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				marked, err := s.Messages.MarkRead(tt.message, tt.userID, at)
+				if err != nil || marked != tt.wantMarked {
+					t.Fatalf("MarkRead() = %d, %v; want %d", marked, err, tt.wantMarked)
+				}
+
+				unread, err := s.Messages.UnreadCounts(tt.userID)
+				if err != nil || !maps.Equal(unread, tt.wantUnread) {
+					t.Fatalf("UnreadCounts() = %v, %v; want %v", unread, err, tt.wantUnread)
+				}
+				lastRead, err := s.Messages.LastReadMessages(tt.userID)
+				if err != nil || !maps.Equal(lastRead, tt.wantLastRead) {
+					t.Fatalf("LastReadMessages() = %v, %v; want %v", lastRead, err, tt.wantLastRead)
+				}
+			})
+		}
+	})
+}
+
+func TestSearchMessages(t *testing.T) {
+	forEachStore(t, func(t *testing.T, s ws.Stores) {
+		roomID := createRoom(t, s, 1, 2)
+		other := createRoom(t, s, 1)
+		hidden := createRoom(t, s, 2)
+
+		var m []int
+		for i, c := range []struct {
+			roomID, senderID int
+			content          string
+		}{
+			{roomID, 1, "deploy on Friday"},
+			{roomID, 2, "DEPLOY again"},
+			{roomID, 1, "100% done"},
+			{roomID, 1, "1000 done"},
+			{roomID, 1, "snake_case"},
+			{roomID, 1, "snakeXcase"},
+			{other, 1, "deploy elsewhere"},
+			{hidden, 2, "deploy in secret"},
+		} {
+			m = append(m, createMessage(t, s, c.roomID, c.senderID, c.content, base.Add(time.Duration(i)*time.Second)).ID)
+		}
+
+		two, missing := 2, m[7]+100
+		tests := []struct {
+			name         string
+			query        ws.MessageQuery
+			wantIDs      []int
+			wantNext     *int
+			wantHeadline string
+			wantErr      error
+		}{
+			{
+				name:         "any case, newest first",
+				query:        ws.MessageQuery{Text: "deploy"},
+				wantIDs:      []int{m[6], m[1], m[0]},
+				wantHeadline: "<mark>deploy</mark> elsewhere",
+			},
+			{name: "percent is literal", query: ws.MessageQuery{Text: "100%"}, wantIDs: []int{m[2]}, wantHeadline: "<mark>100%</mark> done"},
+			{name: "underscore is literal", query: ws.MessageQuery{Text: "_case"}, wantIDs: []int{m[4]}},
+			{name: "in a room", query: ws.MessageQuery{Text: "deploy", RoomID: roomID}, wantIDs: []int{m[1], m[0]}},
+			{name: "by a sender", query: ws.MessageQuery{Text: "deploy", SenderID: 2}, wantIDs: []int{m[1]}},
+			{name: "since", query: ws.MessageQuery{Text: "deploy", Since: base.Add(time.Second)}, wantIDs: []int{m[6], m[1]}},
+			{name: "first page", query: ws.MessageQuery{Text: "deploy", Limit: 2}, wantIDs: []int{m[6], m[1]}, wantNext: &m[1]},
+			{name: "next page", query: ws.MessageQuery{Text: "deploy", Limit: 2, BeforeID: &m[1]}, wantIDs: []int{m[0]}},
+			{name: "first page by rank", query: ws.MessageQuery{Text: "deploy", Limit: 2, ByRank: true}, wantIDs: []int{m[6], m[1]}, wantNext: &two},
+			{name: "next page by rank", query: ws.MessageQuery{Text: "deploy", Limit: 2, ByRank: true, Offset: 2}, wantIDs: []int{m[0]}},
+			{name: "unknown cursor", query: ws.MessageQuery{Text: "deploy", BeforeID: &missing}, wantErr: ws.ErrInvalidCursor},
+		}
+
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				tt.query.UserID = 1
+				if tt.query.Limit == 0 {
+					tt.query.Limit = 10
+				}
+
+				results, next, err := s.Messages.SearchMessages(tt.query)
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("SearchMessages() error = %v, want %v", err, tt.wantErr)
+				}
+				if tt.wantErr != nil {
+					return
+				}
+
+				got := []int{}
+				for _, r := range results {
+					got = append(got, r.Message.ID)
+				}
+				if !slices.Equal(got, tt.wantIDs) {
+					t.Fatalf("SearchMessages() = %v, want %v", got, tt.wantIDs)
+				}
+				if !intPtrEqual(next, tt.wantNext) {
+					t.Fatalf("next = %v, want %v", next, tt.wantNext)
+				}
+				if tt.wantHeadline != "" && results[0].Headline != tt.wantHeadline {
+					t.Fatalf("headline = %q, want %q", results[0].Headline, tt.wantHeadline)
+				}
+			})
+		}
+	})
+}
@@ -1,83 +1,209 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"flag"
 	"fmt"
 	"html/template"
-	"log"
+	"log/slog"
 	"net/http"
 	"os"
-	"strconv"
+	"os/signal"
+	"syscall"
 	"time"
 	"ws-whatever/internal"
+	"ws-whatever/internal/auth"
+	"ws-whatever/internal/broker"
+	"ws-whatever/internal/config"
 	"ws-whatever/internal/db"
+	"ws-whatever/internal/metrics"
+	"ws-whatever/internal/storage"
+	"ws-whatever/internal/store"
 	"ws-whatever/utils"
 	"ws-whatever/ws"
 
 	gws "github.com/gorilla/websocket"
 	"github.com/labstack/echo"
 	"gorm.io/driver/postgres"
+	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
+	gormlogger "gorm.io/gorm/logger"
 )
 
-var upgrader = gws.Upgrader{
-	ReadBufferSize:  1024,
-	WriteBufferSize: 1024,
-	CheckOrigin: func(r *http.Request) bool {
-		return true
-	},
+func newStorage(cfg config.Storage) (storage.Storage, error) {
+	switch cfg.Backend {
+	case "local":
+		return storage.NewLocal(cfg.Dir)
+	case "gcs":
+		return storage.NewGCS(context.Background(), cfg.GCSEndpoint, cfg.GCSBucket)
+	default:
+		return nil, fmt.Errorf("unknown storage backend %q", cfg.Backend)
+	}
 }
 
-func getEnv(key, defaultValue string) string {
-	if value := os.Getenv(key); value != "" {
-		return value
+// newGormLogger sends GORM's logs through the structured logger. Every
+// statement is traced at debug; otherwise only slow queries and errors are
+// logged. Missing records are expected and never logged.
+func newGormLogger(logger *slog.Logger) gormlogger.Interface {
+	level := gormlogger.Warn
+	switch {
+	case logger.Enabled(context.Background(), slog.LevelDebug):
+		level = gormlogger.Info
+	case !logger.Enabled(context.Background(), slog.LevelWarn):
+		level = gormlogger.Error
 	}
-	return defaultValue
+
+	return gormlogger.NewSlogLogger(logger, gormlogger.Config{
+		SlowThreshold:             200 * time.Millisecond,
+		LogLevel:                  level,
+		IgnoreRecordNotFoundError: true,
+		// Query arguments carry message content and tokens.
+		ParameterizedQueries: true,
+	})
 }
 
-func testAuthMiddleware(next echo.HandlerFunc) echo.HandlerFunc {
-	return func(c echo.Context) error {
-		userIDStr := c.QueryParam("user_id")
-		if userIDStr == "" {
-			userIDStr = string(time.Now().Second())
+func openDatabase(cfg config.Database, logger *slog.Logger) (*gorm.DB, error) {
+	gormConfig := &gorm.Config{Logger: newGormLogger(logger)}
+
+	switch cfg.Driver {
+	case "postgres":
+		return gorm.Open(postgres.Open(cfg.DSN()), gormConfig)
+	case "sqlite":
+		db, err := gorm.Open(sqlite.Open(cfg.DSN()), gormConfig)
+		if err != nil {
+			return nil, err
 		}
 
-		userID, err := strconv.Atoi(userIDStr)
+		// SQLite takes one writer at a time. A single connection queues
+		// them instead of failing with "database is locked", and keeps an
+		// in-memory database from being split across connections.
+		sqlDB, err := db.DB()
 		if err != nil {
-			return echo.NewHTTPError(400, "invalid user_id")
+			return nil, err
 		}
+		sqlDB.SetMaxOpenConns(1)
+		return db, nil
+	default:
+		return nil, fmt.Errorf("unknown database driver %q", cfg.Driver)
+	}
+}
 
-		c.Set("user_id", userID)
-		return next(c)
+func newAuthMiddleware(cfg config.Config, logger *slog.Logger) (echo.MiddlewareFunc, error) {
+	if cfg.Server.DevAuth {
+		logger.Warn("dev auth enabled, user_id query parameters are trusted")
+		return auth.DevMiddleware, nil
 	}
+
+	verifier, err := auth.NewVerifier(auth.Options{
+		Secret:   []byte(cfg.Auth.Secret),
+		JWKSFile: cfg.Auth.JWKSFile,
+		Issuer:   cfg.Auth.Issuer,
+		Audience: cfg.Auth.Audience,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return auth.Middleware(verifier), nil
+}
+
+// fatal logs err and exits, like log.Fatal but through the structured logger.
+func fatal(logger *slog.Logger, msg string, err error) {
+	logger.Error(msg, "error", err)
+	os.Exit(1)
 }
 
 func main() {
-	host := getEnv("DB_HOST", "localhost")
-	port := getEnv("DB_PORT", "5432")
-	user := getEnv("DB_USER", "postgres")
-	password := getEnv("DB_PASSWORD", "HgYKJ72T")
-	dbname := getEnv("DB_NAME", "messaging")
-	sslmode := getEnv("DB_SSLMODE", "disable")
-
-	dsn := fmt.Sprintf("host=%v user=%v password=%v dbname=%v port=%v sslmode=%v", host, user, password, dbname, port, sslmode)
-	dbClient, err := gorm.Open(postgres.Open(dsn), &gorm.Config{})
+	cfg, command, err := config.Load(os.Args[1:])
 	if err != nil {
-		log.Fatal(err)
+		if errors.Is(err, flag.ErrHelp) {
+			os.Exit(0)
+		}
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+
+	logger, err := utils.NewLogger(cfg.Log.Level, cfg.Log.Format)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	// Anything still using the log package ends up in the same output.
+	slog.SetDefault(logger)
+
+	if len(command) > 0 {
+		if command[0] != "migrate" {
+			fmt.Fprintf(os.Stderr, "unknown command %q\n", command[0])
+			os.Exit(2)
+		}
+		if err := runMigrate(cfg.Database, command[1:], logger); err != nil {
+			fatal(logger, "migrate failed", err)
+		}
+		return
+	}
+
+	dbClient, err := openDatabase(cfg.Database, logger)
+	if err != nil {
+		fatal(logger, "failed to connect to database", err)
+	}
+
+	if err := metrics.InstrumentDB(dbClient); err != nil {
+		fatal(logger, "failed to instrument database", err)
 	}
 
 	if err := db.RunMigration(dbClient); err != nil {
-		log.Printf("Run migrations failed: %v", err)
+		if !cfg.Database.IgnoreMigrationErrors {
+			fatal(logger, "run migrations failed", err)
+		}
+		logger.Warn("run migrations failed, starting anyway", "error", err)
+	}
+
+	files, err := newStorage(cfg.Storage)
+	if err != nil {
+		fatal(logger, "failed to set up storage", err)
+	}
+
+	authenticate, err := newAuthMiddleware(cfg, logger)
+	if err != nil {
+		fatal(logger, "failed to set up auth", err)
 	}
 
 	e := echo.New()
+	e.HideBanner = true
+	e.HidePort = true
+	e.Use(utils.RequestLogger(logger))
+	e.Use(metrics.Middleware)
 	tmpl := template.Must(template.ParseFiles("web/templates/index.html"))
-	logger := utils.NewLogger()
 
-	m := ws.NewManager(dbClient, logger)
+	var roomBroker ws.Broker
+	switch cfg.Broker.Backend {
+	case "none":
+	case "postgres":
+		roomBroker = broker.NewPostgres(dbClient, cfg.Database.DSN(), cfg.Broker.Channel, logger)
+	default:
+		fatal(logger, "failed to set up broker", fmt.Errorf("unknown broker %q", cfg.Broker.Backend))
+	}
+
+	upgrader := gws.Upgrader{
+		ReadBufferSize:  cfg.WebSocket.ReadBufferSize,
+		WriteBufferSize: cfg.WebSocket.WriteBufferSize,
+		CheckOrigin: func(r *http.Request) bool {
+			return true
+		},
+	}
+
+	stores := store.NewGORM(dbClient).Stores()
+	m := ws.NewManager(stores, logger, roomBroker, cfg.WebSocket)
+	runCtx, stopRun := context.WithCancel(context.Background())
+	go m.Run(runCtx)
+	go internal.SweepAttachments(runCtx, stores, files, cfg.Storage.UploadTTL, logger)
 
 	e.GET("/", func(c echo.Context) error {
-		if err := tmpl.Execute(c.Response(), nil); err != nil {
-			log.Printf("Template execution error: %v", err)
+		// With dev auth the UI asks for a user ID instead of a token.
+		data := struct{ DevAuth bool }{cfg.Server.DevAuth}
+		if err := tmpl.Execute(c.Response(), data); err != nil {
+			utils.Logger(c).Error("template execution failed", "error", err)
 			return err
 		}
 		return nil
@@ -89,34 +215,94 @@ func main() {
 			return echo.NewHTTPError(401, "unauthorized")
 		}
 
+		if m.Draining() {
+			return echo.NewHTTPError(http.StatusServiceUnavailable, "server is shutting down")
+		}
+
 		conn, err := upgrader.Upgrade(c.Response(), c.Request(), nil)
 		if err != nil {
-			log.Printf("WebSocket upgrade error: %v", err)
+			utils.Logger(c).Warn("websocket upgrade failed", "error", err)
 			return err
 		}
 
-		client := ws.NewClient(conn, m, userID.(int))
-		client.Manager.AddClient(client)
+		client := ws.NewClient(conn, m, userID.(int), utils.Logger(c))
+		if err := client.Manager.AddClient(client); err != nil {
+			// Lost the race with Shutdown after the upgrade.
+			conn.WriteControl(gws.CloseMessage, gws.FormatCloseMessage(gws.CloseServiceRestart, err.Error()), time.Now().Add(time.Second))
+			conn.Close()
+			return nil
+		}
 
 		go client.ReadMessages()
 		go client.WriteMessages()
 
 		return nil
-	}, testAuthMiddleware)
+	}, authenticate)
 
 	// HTTP REST endpoints
-	e.POST("/rooms", internal.CreateRoom(dbClient))
-	e.GET("/rooms", internal.ListRooms(dbClient))
-	e.GET("/rooms/:id/messages", internal.GetRoomMessages(dbClient))
-	e.POST("/rooms/:id/participants", internal.AddRoomParticipant(dbClient))
-	e.GET("/users/rooms", internal.GetUserRooms(dbClient), testAuthMiddleware)
-	e.POST("/direct-messages", internal.CreateOrGetDirectMessage(dbClient), testAuthMiddleware)
-	e.DELETE("/messages/:id", internal.DeleteMessage(dbClient), testAuthMiddleware)
-	e.GET("/search/messages", internal.SearchMessages(dbClient))
+	e.POST("/rooms", internal.CreateRoom(stores), authenticate)
+	e.GET("/rooms", internal.ListRooms(stores), authenticate)
+	e.GET("/rooms/:id/messages", internal.GetRoomMessages(stores, cfg.WebSocket), authenticate)
+	e.POST("/rooms/:id/participants", internal.AddRoomParticipant(stores), authenticate)
+	e.POST("/rooms/:id/attachments", internal.UploadAttachment(stores, files, cfg.Storage.MaxAttachmentSize), authenticate)
+	e.GET("/rooms/:id/pins", internal.GetPinnedMessages(stores), authenticate)
+	e.GET("/rooms/:id/presence", internal.GetRoomPresence(stores, m), authenticate)
+	e.GET("/attachments/:id", internal.GetAttachment(stores, files), authenticate)
+	e.GET("/users/rooms", internal.GetUserRooms(stores), authenticate)
+	e.POST("/direct-messages", internal.CreateOrGetDirectMessage(stores), authenticate)
+	e.PATCH("/messages/:id", internal.EditMessage(m), authenticate)
+	e.DELETE("/messages/:id", internal.DeleteMessage(m), authenticate)
+	e.GET("/messages/:id/revisions", internal.GetMessageRevisions(stores), authenticate)
+	e.GET("/messages/:id/thread", internal.GetThread(stores, cfg.WebSocket), authenticate)
+	e.POST("/messages/:id/reactions", internal.AddReaction(m), authenticate)
+	e.DELETE("/messages/:id/reactions/:type", internal.RemoveReaction(m), authenticate)
+	e.PUT("/messages/:id/pin", internal.PinMessage(m), authenticate)
+	e.DELETE("/messages/:id/pin", internal.UnpinMessage(m), authenticate)
+	e.GET("/search/messages", internal.SearchMessages(stores, cfg.WebSocket), authenticate)
+
+	e.GET("/metrics", metrics.Handler())
 
 	// serving static files
 	e.Static("/static", "web/static")
 
-	log.Println("Server running on port :6969")
-	e.Logger.Fatal(e.Start(":6969"))
+	signalCtx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	go func() {
+		logger.Info("server running", "addr", cfg.Server.Addr)
+		if err := e.Start(cfg.Server.Addr); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			fatal(logger, "server failed", err)
+		}
+	}()
+
+	<-signalCtx.Done()
+	stop()
+	logger.Info("shutting down, draining connections")
+
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.Server.ShutdownTimeout)
+	defer cancel()
+
+	// Drain websockets first so in-flight events still reach the database
+	// and the broker, then stop HTTP and the background workers.
+	if err := m.Shutdown(ctx, cfg.Server.ReconnectAfter); err != nil {
+		logger.Error("draining websocket clients", "error", err)
+	}
+	if err := e.Shutdown(ctx); err != nil {
+		logger.Error("http server shutdown", "error", err)
+	}
+	stopRun()
+
+	if roomBroker != nil {
+		if err := roomBroker.Close(); err != nil {
+			logger.Error("closing broker", "error", err)
+		}
+	}
+
+	if sqlDB, err := dbClient.DB(); err == nil {
+		if err := sqlDB.Close(); err != nil {
+			logger.Error("closing database", "error", err)
+		}
+	}
+
+	logger.Info("server stopped")
 }
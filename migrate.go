@@ -0,0 +1,85 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"time"
+	"ws-whatever/internal/config"
+	"ws-whatever/internal/db"
+)
+
+// migrationsDir is where migrate create writes new migrations, relative to
+// the repository root.
+const migrationsDir = "internal/db"
+
+var errMigrateUsage = errors.New("usage: migrate up | down [N] | status | create NAME")
+
+// runMigrate runs the migrate command: up applies pending migrations, down
+// rolls back the last N (default 1), status lists them and create writes a
+// new one.
+func runMigrate(cfg config.Database, args []string, logger *slog.Logger) error {
+	if len(args) == 0 {
+		return errMigrateUsage
+	}
+
+	if args[0] == "create" {
+		if len(args) != 2 {
+			return errMigrateUsage
+		}
+		path, err := db.Create(migrationsDir, args[1], time.Now())
+		if err != nil {
+			return err
+		}
+		fmt.Println("created", path)
+		return nil
+	}
+
+	dbClient, err := openDatabase(cfg, logger)
+	if err != nil {
+		return err
+	}
+	if sqlDB, err := dbClient.DB(); err == nil {
+		defer sqlDB.Close()
+	}
+
+	switch args[0] {
+	case "up":
+		if len(args) != 1 {
+			return errMigrateUsage
+		}
+		return db.RunMigration(dbClient)
+	case "down":
+		n := 1
+		switch len(args) {
+		case 1:
+		case 2:
+			n, err = strconv.Atoi(args[1])
+			if err != nil || n <= 0 {
+				return fmt.Errorf("invalid number of migrations %q", args[1])
+			}
+		default:
+			return errMigrateUsage
+		}
+		return db.Rollback(dbClient, n)
+	case "status":
+		if len(args) != 1 {
+			return errMigrateUsage
+		}
+		statuses, err := db.Status(dbClient)
+		if err != nil {
+			return err
+		}
+		for _, s := range statuses {
+			state := "pending"
+			if s.Applied {
+				state = "applied"
+			}
+			fmt.Printf("%-8s %s\n", state, s.ID)
+		}
+		return nil
+	default:
+		return errMigrateUsage
+	}
+}
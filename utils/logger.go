@@ -1,11 +1,105 @@
 package utils
 
 import (
+	"fmt"
 	"log/slog"
+	"net/http"
 	"os"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo"
 )
 
-func NewLogger() *slog.Logger {
-	logger := slog.New(slog.NewJSONHandler(os.Stdout, nil))
-	return logger
+const loggerKey = "logger"
+
+// maxRequestIDLength bounds request ids taken from the X-Request-ID header.
+const maxRequestIDLength = 128
+
+// NewLogger builds the process logger. level is debug, info, warn or
+// error; format is json or text.
+func NewLogger(level, format string) (*slog.Logger, error) {
+	var lvl slog.Level
+	if err := lvl.UnmarshalText([]byte(level)); err != nil {
+		return nil, fmt.Errorf("invalid log level %q", level)
+	}
+
+	opts := &slog.HandlerOptions{Level: lvl}
+
+	var handler slog.Handler
+	switch strings.ToLower(format) {
+	case "json":
+		handler = slog.NewJSONHandler(os.Stdout, opts)
+	case "text":
+		handler = slog.NewTextHandler(os.Stdout, opts)
+	default:
+		return nil, fmt.Errorf("invalid log format %q", format)
+	}
+
+	return slog.New(handler), nil
+}
+
+// RequestLogger gives every request an id, taken from X-Request-ID when the
+// caller sent one, and a logger carrying it for handlers to use. Each
+// request is logged once it completes.
+func RequestLogger(logger *slog.Logger) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			requestID := c.Request().Header.Get(echo.HeaderXRequestID)
+			if requestID == "" || len(requestID) > maxRequestIDLength {
+				requestID = uuid.New().String()
+			}
+			c.Response().Header().Set(echo.HeaderXRequestID, requestID)
+
+			reqLogger := logger.With("requestID", requestID)
+			c.Set(loggerKey, reqLogger)
+
+			start := time.Now()
+			err := next(c)
+
+			status := c.Response().Status
+			attrs := []any{
+				"method", c.Request().Method,
+				"path", c.Request().URL.Path,
+				"route", c.Path(),
+				"duration", time.Since(start),
+			}
+			if userID := c.Get("user_id"); userID != nil {
+				attrs = append(attrs, "userID", userID)
+			}
+			if err != nil {
+				status = http.StatusInternalServerError
+				cause := err
+				if httpErr, ok := err.(*echo.HTTPError); ok {
+					status = httpErr.Code
+					if httpErr.Internal != nil {
+						cause = httpErr.Internal
+					}
+				}
+				attrs = append(attrs, "error", cause)
+			}
+			attrs = append(attrs, "status", status)
+
+			switch {
+			case status >= http.StatusInternalServerError:
+				reqLogger.Error("request failed", attrs...)
+			case status >= http.StatusBadRequest:
+				reqLogger.Warn("request rejected", attrs...)
+			default:
+				reqLogger.Info("request completed", attrs...)
+			}
+
+			return err
+		}
+	}
+}
+
+// Logger returns the request's logger, or the default logger outside of
+// RequestLogger.
+func Logger(c echo.Context) *slog.Logger {
+	if logger, ok := c.Get(loggerKey).(*slog.Logger); ok {
+		return logger
+	}
+	return slog.Default()
 }
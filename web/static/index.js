@@ -1,15 +1,29 @@
 let ws = null;
 let currentRoomID = null;
 let currentUserID = null;
+// The JWT sent with every request. The server only trusts a bare user ID
+// when it runs with dev auth, which renders the login form for that.
+let accessToken = null;
 let reconnectAttempts = 0;
+let historyCursor = null;
+let loadingHistory = false;
+let restartDelay = null;
 const MAX_RECONNECT_ATTEMPTS = 5;
+const SLOW_CONSUMER_CLOSE = 4008;
 
 const rooms = new Map();
+// Latest event sequence number seen per room, used to resume after a
+// reconnect without losing events.
+const roomSeqs = new Map();
+// Messages sent but not yet acknowledged, keyed by client_msg_id. They are
+// sent again after a reconnect; the server drops the duplicates.
+const pendingMessages = new Map();
 
 const loginContainer = document.getElementById("login-container");
 const appContainer = document.getElementById("app-container");
 const loginForm = document.getElementById("login-form");
-const userIdInput = document.getElementById("user-id-input");
+const loginInput = document.getElementById("login-input");
+const devAuth = loginForm.hasAttribute("data-dev-auth");
 const statusDiv = document.getElementById("status");
 const userAvatar = document.getElementById("user-avatar");
 const userName = document.getElementById("user-name");
@@ -25,13 +39,18 @@ const typingIndicator = document.getElementById("typing-indicator");
 
 loginForm.addEventListener("submit", (e) => {
   e.preventDefault();
-  const userId = parseInt(userIdInput.value, 10);
+  const userId = devAuth
+    ? parseInt(loginInput.value, 10)
+    : tokenUserID(loginInput.value.trim());
 
   if (isNaN(userId) || userId < 1) {
-    alert("Please enter a valid user ID");
+    alert(devAuth ? "Please enter a valid user ID" : "Please enter a valid access token");
     return;
   }
 
+  if (!devAuth) {
+    accessToken = loginInput.value.trim();
+  }
   currentUserID = userId;
   userName.textContent = `User ${userId}`;
   userAvatar.textContent = `U${userId}`;
@@ -43,9 +62,41 @@ loginForm.addEventListener("submit", (e) => {
   loadRooms();
 });
 
+// tokenUserID reads the user ID from the token's "sub" claim. The server
+// verifies the token; this only tells the UI which messages are ours.
+function tokenUserID(token) {
+  try {
+    const payload = token.split(".")[1].replace(/-/g, "+").replace(/_/g, "/");
+    return parseInt(JSON.parse(atob(payload)).sub, 10);
+  } catch {
+    return NaN;
+  }
+}
+
+// credentials returns the query parameter that authenticates a WebSocket,
+// which cannot carry an Authorization header.
+function credentials() {
+  return devAuth
+    ? `user_id=${currentUserID}`
+    : `access_token=${encodeURIComponent(accessToken)}`;
+}
+
+// apiFetch is fetch for the REST API with the user's credentials.
+function apiFetch(path, options = {}) {
+  if (devAuth) {
+    const separator = path.includes("?") ? "&" : "?";
+    return fetch(`${path}${separator}${credentials()}`, options);
+  }
+  return fetch(path, {
+    ...options,
+    headers: { ...options.headers, Authorization: `Bearer ${accessToken}` },
+  });
+}
+
 function connect() {
+  const scheme = document.location.protocol === "https:" ? "wss" : "ws";
   ws = new WebSocket(
-    `ws://${document.location.host}/ws?user_id=${currentUserID}`
+    `${scheme}://${document.location.host}/ws?${credentials()}`
   );
 
   ws.onopen = () => {
@@ -53,6 +104,12 @@ function connect() {
     statusDiv.textContent = "Connected";
     statusDiv.className = "status-indicator connected";
     reconnectAttempts = 0;
+
+    subscribeAll();
+    if (currentRoomID && !roomSeqs.has(currentRoomID)) {
+      sendEvent("join_room", { room_id: currentRoomID });
+    }
+    pendingMessages.forEach((payload) => sendEvent("send_message", payload));
   };
 
   ws.onmessage = (e) => {
@@ -68,15 +125,26 @@ function connect() {
     console.error("WebSocket error:", err);
   };
 
-  ws.onclose = () => {
+  ws.onclose = (e) => {
     console.log("WebSocket disconnected");
+    if (e.code === SLOW_CONSUMER_CLOSE && restartDelay === null) {
+      // We fell behind; come back and resume from the last seen events.
+      restartDelay = 1000;
+    }
     statusDiv.textContent = "Disconnected";
     statusDiv.className = "status-indicator disconnected";
 
     messageInput.disabled = true;
     sendButton.disabled = true;
 
-    if (reconnectAttempts < MAX_RECONNECT_ATTEMPTS) {
+    if (restartDelay !== null) {
+      // The server asked us to come back (restart or falling behind),
+      // which does not count against the retry budget.
+      const delay = restartDelay;
+      restartDelay = null;
+      console.log(`Reconnecting in ${delay}ms...`);
+      setTimeout(connect, delay);
+    } else if (reconnectAttempts < MAX_RECONNECT_ATTEMPTS) {
       reconnectAttempts++;
       const delay = Math.min(1000 * Math.pow(2, reconnectAttempts), 30000);
       console.log(
@@ -88,9 +156,17 @@ function connect() {
 }
 
 function handleEvent(event) {
+  if (event.seq && event.payload) {
+    // Replays can overlap with live delivery; skip what we already have.
+    const roomId = event.payload.room_id;
+    if (event.seq <= (roomSeqs.get(roomId) || 0)) return;
+    roomSeqs.set(roomId, event.seq);
+  }
+
   switch (event.type) {
     case "new_message":
       handleNewMessage(event.payload);
+      markRead(event.payload);
       break;
     case "history":
       handleHistory(event.payload);
@@ -101,6 +177,29 @@ function handleEvent(event) {
     case "typing":
       handleTyping(event.payload);
       break;
+    case "message_edited":
+      handleMessageEdited(event.payload);
+      break;
+    case "message_ack":
+      handleMessageAck(event.payload);
+      break;
+    case "message_nack":
+      handleMessageNack(event.payload);
+      break;
+    case "message_deleted":
+      handleMessageDeleted(event.payload);
+      break;
+    case "replay":
+      handleReplay(event.payload);
+      break;
+    case "server_restarting":
+      restartDelay = event.payload.reconnect_after_ms;
+      break;
+    case "subscribed":
+      trackSeq(event.payload.room_id, event.payload.seq);
+      break;
+    case "unsubscribed":
+      break;
     default:
       console.log("Unknown event type:", event.type, event);
   }
@@ -110,9 +209,32 @@ function handleNewMessage(msg) {
   updateRoomPreview(msg.room_id, msg.content, msg.created_at);
 
   if (currentRoomID !== msg.room_id) return;
+  if (chatMessages.querySelector(`[data-message-id="${msg.id}"]`)) return;
+
+  if (msg.client_msg_id && msg.sender_id === currentUserID) {
+    pendingMessages.delete(msg.client_msg_id);
+    const pending = chatMessages.querySelector(
+      `[data-client-msg-id="${msg.client_msg_id}"]`
+    );
+    if (pending) {
+      pending.replaceWith(renderMessage(msg));
+      return;
+    }
+  }
 
+  const emptyState = chatMessages.querySelector(".empty-state");
+  if (emptyState) {
+    emptyState.remove();
+  }
+
+  chatMessages.appendChild(renderMessage(msg));
+  chatMessages.scrollTop = chatMessages.scrollHeight;
+}
+
+function renderMessage(msg) {
   const messageDiv = document.createElement("div");
   messageDiv.className = `message ${msg.sender_id === currentUserID ? "own" : "other"}`;
+  messageDiv.dataset.messageId = msg.id;
 
   const bubble = document.createElement("div");
   bubble.className = "message-bubble";
@@ -134,22 +256,105 @@ function handleNewMessage(msg) {
     hour: "2-digit",
     minute: "2-digit",
   });
+  if (msg.is_edited) {
+    time.textContent += " (edited)";
+  }
 
   bubble.appendChild(content);
   bubble.appendChild(time);
   messageDiv.appendChild(bubble);
 
-  const emptyState = chatMessages.querySelector(".empty-state");
-  if (emptyState) {
-    emptyState.remove();
+  return messageDiv;
+}
+
+function handleMessageEdited(msg) {
+  const messageDiv = chatMessages.querySelector(
+    `[data-message-id="${msg.id}"]`
+  );
+  if (!messageDiv) return;
+
+  messageDiv.querySelector(".message-content").textContent = msg.content;
+
+  const time = messageDiv.querySelector(".message-time");
+  if (!time.textContent.endsWith(" (edited)")) {
+    time.textContent += " (edited)";
   }
+}
 
-  chatMessages.appendChild(messageDiv);
-  chatMessages.scrollTop = chatMessages.scrollHeight;
+function handleMessageAck(ack) {
+  pendingMessages.delete(ack.client_msg_id);
+
+  const pending = chatMessages.querySelector(
+    `[data-client-msg-id="${ack.client_msg_id}"]`
+  );
+  if (!pending) return;
+
+  pending.classList.remove("pending");
+  pending.dataset.messageId = ack.id;
+}
+
+function handleMessageNack(nack) {
+  pendingMessages.delete(nack.client_msg_id);
+
+  const pending = chatMessages.querySelector(
+    `[data-client-msg-id="${nack.client_msg_id}"]`
+  );
+  if (!pending) return;
+
+  pending.classList.remove("pending");
+  pending.classList.add("failed");
+  pending.querySelector(".message-time").textContent = `Not sent: ${nack.reason}`;
+}
+
+function newClientMsgID() {
+  if (window.crypto && crypto.randomUUID) {
+    return crypto.randomUUID();
+  }
+  return `${Date.now()}-${Math.random().toString(36).slice(2)}`;
+}
+
+function handleMessageDeleted(msg) {
+  const messageDiv = chatMessages.querySelector(
+    `[data-message-id="${msg.id}"]`
+  );
+  if (messageDiv) {
+    messageDiv.remove();
+  }
+}
+
+function handleReplay(payload) {
+  if (payload.truncated) {
+    // Too much was missed to replay, so start the room over.
+    roomSeqs.set(payload.room_id, payload.seq);
+    if (payload.room_id === currentRoomID) {
+      sendEvent("join_room", { room_id: currentRoomID });
+    }
+    return;
+  }
+
+  payload.events.forEach((event) => handleEvent(event));
+  trackSeq(payload.room_id, payload.seq);
+}
+
+function trackSeq(roomId, seq) {
+  if (seq > (roomSeqs.get(roomId) || 0)) {
+    roomSeqs.set(roomId, seq);
+  }
 }
 
 function handleHistory(payload) {
+  if (payload.room_id !== currentRoomID) return;
+  if (payload.seq) {
+    trackSeq(payload.room_id, payload.seq);
+  }
+
+  if (payload.before_id) {
+    prependHistory(payload);
+    return;
+  }
+
   chatMessages.innerHTML = "";
+  historyCursor = payload.next_cursor;
 
   if (payload.messages.length === 0) {
     const systemMsg = document.createElement("div");
@@ -160,6 +365,24 @@ function handleHistory(payload) {
   }
 
   payload.messages.forEach((msg) => handleNewMessage(msg));
+  markRead(payload.messages[payload.messages.length - 1]);
+}
+
+function prependHistory(payload) {
+  historyCursor = payload.next_cursor;
+  loadingHistory = false;
+
+  const previousHeight = chatMessages.scrollHeight;
+  const fragment = document.createDocumentFragment();
+  payload.messages.forEach((msg) => fragment.appendChild(renderMessage(msg)));
+  chatMessages.prepend(fragment);
+  chatMessages.scrollTop += chatMessages.scrollHeight - previousHeight;
+}
+
+function markRead(msg) {
+  if (currentRoomID !== msg.room_id) return;
+
+  sendEvent("mark_read", { message_id: msg.id });
 }
 
 function handleError(payload) {
@@ -171,6 +394,8 @@ function handleError(payload) {
 }
 
 function handleTyping(payload) {
+  if (payload.room_id !== currentRoomID) return;
+
   if (!payload.user_ids || payload.user_ids.length === 0) {
     typingIndicator.classList.remove("active");
     return;
@@ -196,7 +421,7 @@ function sendEvent(type, payload) {
 
 async function loadRooms() {
   try {
-    const response = await fetch(`/users/rooms?user_id=${currentUserID}`);
+    const response = await apiFetch("/users/rooms");
     if (!response.ok) {
       console.error("Failed to load rooms");
       return;
@@ -214,11 +439,33 @@ async function loadRooms() {
       rooms.set(room.id, roomData);
       renderRoomItem(roomData);
     });
+
+    subscribeAll();
   } catch (error) {
     console.error("Error loading rooms:", error);
   }
 }
 
+// Subscribing to every room keeps the sidebar previews live, not just the
+// room that is open. Rooms we have seen events for are resumed so that
+// anything missed while disconnected is replayed.
+function subscribeAll() {
+  if (!ws || ws.readyState !== WebSocket.OPEN) return;
+
+  const resume = {};
+  rooms.forEach((room) => {
+    if (roomSeqs.has(room.id)) {
+      resume[room.id] = roomSeqs.get(room.id);
+    } else {
+      sendEvent("subscribe", { room_id: room.id });
+    }
+  });
+
+  if (Object.keys(resume).length > 0) {
+    sendEvent("resume", { rooms: resume });
+  }
+}
+
 function renderRoomItem(room) {
   let roomItem = document.getElementById(`room-${room.id}`);
 
@@ -258,6 +505,8 @@ function selectRoom(roomId) {
   messageInput.disabled = false;
   sendButton.disabled = false;
 
+  historyCursor = null;
+  loadingHistory = false;
   sendEvent("join_room", { room_id: roomId });
 }
 
@@ -305,7 +554,30 @@ sendButton.addEventListener("click", (e) => {
   const content = messageInput.value.trim();
   if (!content) return;
 
-  sendEvent("send_message", { content });
+  const payload = {
+    room_id: currentRoomID,
+    content,
+    client_msg_id: newClientMsgID(),
+  };
+  pendingMessages.set(payload.client_msg_id, payload);
+
+  const emptyState = chatMessages.querySelector(".empty-state");
+  if (emptyState) {
+    emptyState.remove();
+  }
+
+  const messageDiv = renderMessage({
+    sender_id: currentUserID,
+    content,
+    created_at: new Date(),
+  });
+  messageDiv.classList.add("pending");
+  messageDiv.dataset.clientMsgId = payload.client_msg_id;
+  delete messageDiv.dataset.messageId;
+  chatMessages.appendChild(messageDiv);
+  chatMessages.scrollTop = chatMessages.scrollHeight;
+
+  sendEvent("send_message", payload);
   messageInput.value = "";
 });
 
@@ -315,12 +587,33 @@ messageInput.addEventListener("keypress", (e) => {
   }
 });
 
+chatMessages.addEventListener("scroll", () => {
+  if (chatMessages.scrollTop > 0 || !historyCursor || loadingHistory) return;
+
+  loadingHistory = true;
+  sendEvent("load_history", {
+    room_id: currentRoomID,
+    before_id: historyCursor,
+  });
+});
+
+// The server expires typing after 3s, so refresh it while the user types and
+// stop it explicitly once they pause.
 let typingTimeout;
+let lastTypingSent = 0;
 messageInput.addEventListener("input", () => {
   if (!currentRoomID) return;
 
-  clearTimeout(typingTimeout);
-  sendEvent("typing", {});
+  const roomId = currentRoomID;
+  const now = Date.now();
+  if (now - lastTypingSent > 1000) {
+    sendEvent("typing", { room_id: roomId });
+    lastTypingSent = now;
+  }
 
-  typingTimeout = setTimeout(() => {}, 3000);
+  clearTimeout(typingTimeout);
+  typingTimeout = setTimeout(() => {
+    sendEvent("typing_stop", { room_id: roomId });
+    lastTypingSent = 0;
+  }, 2000);
 });
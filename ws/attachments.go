@@ -0,0 +1,24 @@
+package ws
+
+import (
+	"errors"
+	"fmt"
+)
+
+var ErrInvalidAttachments = errors.New("attachments must be uploaded to this room by the sender and not already used")
+
+// AttachmentURL is the path clients download an attachment from.
+func AttachmentURL(attachmentID int) string {
+	return fmt.Sprintf("/attachments/%d", attachmentID)
+}
+
+func NewAttachmentPayload(a MessageAttachment) AttachmentPayload {
+	return AttachmentPayload{
+		ID:       a.ID,
+		FileName: a.FileName,
+		FileType: a.FileType,
+		FileMime: a.FileMime,
+		FileSize: a.FileSize,
+		URL:      AttachmentURL(a.ID),
+	}
+}
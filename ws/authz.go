@@ -0,0 +1,90 @@
+package ws
+
+import "errors"
+
+var (
+	ErrRoomNotFound     = errors.New("room not found")
+	ErrNotParticipant   = errors.New("not a participant of this room")
+	ErrInviteRequired   = errors.New("this room is private and requires an invite")
+	ErrDirectRoomClosed = errors.New("direct rooms do not accept new participants")
+)
+
+// Action is something a user can attempt in a room.
+type Action int
+
+const (
+	ActionRead Action = iota
+	ActionPost
+	ActionJoin
+	ActionManageParticipants
+)
+
+// Authorize decides whether userID may perform action in roomID based on
+// their RoomParticipant record. It returns that record, which is nil when a
+// non-member is allowed to join a public group room.
+func Authorize(s Stores, userID, roomID int, action Action) (*RoomParticipant, error) {
+	room, err := s.Rooms.GetRoom(roomID)
+	if err != nil {
+		return nil, err
+	}
+
+	participant, err := s.Participants.GetParticipant(roomID, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	switch action {
+	case ActionJoin:
+		if participant != nil {
+			return participant, nil
+		}
+		if room.Type == RoomTypeDirect {
+			return nil, ErrDirectRoomClosed
+		}
+		if room.IsPrivate {
+			return nil, ErrInviteRequired
+		}
+		return nil, nil
+	case ActionManageParticipants:
+		if room.Type == RoomTypeDirect {
+			return nil, ErrDirectRoomClosed
+		}
+		if participant == nil {
+			return nil, ErrNotParticipant
+		}
+		if !isRoomAdmin(participant.Role) {
+			return nil, ErrNotRoomAdmin
+		}
+		return participant, nil
+	default:
+		if participant == nil {
+			return nil, ErrNotParticipant
+		}
+		return participant, nil
+	}
+}
+
+// AuthorizeMessage loads a message that has not been deleted and checks that
+// userID may perform action in its room.
+func AuthorizeMessage(s Stores, userID, messageID int, action Action) (Message, *RoomParticipant, error) {
+	message, err := s.Messages.GetMessage(messageID)
+	if err != nil {
+		return Message{}, nil, err
+	}
+
+	participant, err := Authorize(s, userID, message.RoomID, action)
+	return *message, participant, err
+}
+
+// IsForbidden reports whether err is an authorization denial.
+func IsForbidden(err error) bool {
+	return errors.Is(err, ErrNotParticipant) ||
+		errors.Is(err, ErrInviteRequired) ||
+		errors.Is(err, ErrDirectRoomClosed) ||
+		errors.Is(err, ErrNotRoomAdmin) ||
+		errors.Is(err, ErrNotMessageSender)
+}
+
+func isRoomAdmin(role string) bool {
+	return role == RoleOwner || role == RoleAdmin
+}
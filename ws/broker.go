@@ -0,0 +1,84 @@
+package ws
+
+import (
+	"encoding/json"
+
+	"github.com/google/uuid"
+)
+
+// Broker carries room events between server nodes so clients connected to
+// any node receive them.
+type Broker interface {
+	Publish(msg BrokerMessage) error
+	Subscribe(handler func(BrokerMessage))
+	Close() error
+}
+
+type BrokerMessage struct {
+	ID     string `json:"id"`
+	NodeID string `json:"node_id"`
+	RoomID int    `json:"room_id"`
+	// ThreadID is set for events that only go to the thread's subscribers.
+	ThreadID int `json:"thread_id,omitempty"`
+	// Presence carries the sending node's view of its users instead of an
+	// event for clients.
+	Presence []NodePresence `json:"presence,omitempty"`
+	// Typing carries the sending node's typers the same way.
+	Typing []NodeTyping    `json:"typing,omitempty"`
+	Data   json.RawMessage `json:"data,omitempty"`
+}
+
+// seenCapacity bounds how many broker message IDs are remembered for
+// de-duplication.
+const seenCapacity = 4096
+
+func (m *Manager) publish(msg BrokerMessage) {
+	if m.broker == nil {
+		return
+	}
+
+	msg.ID = uuid.New().String()
+	msg.NodeID = m.nodeID
+	if err := m.broker.Publish(msg); err != nil {
+		m.logger.Error("failed to publish room event", "error", err, "roomID", msg.RoomID)
+	}
+}
+
+func (m *Manager) handleBrokerMessage(msg BrokerMessage) {
+	// The originating node has already delivered to its own clients.
+	if msg.NodeID == m.nodeID || !m.markSeen(msg.ID) {
+		return
+	}
+
+	if msg.Presence != nil {
+		m.handleRemotePresence(msg.NodeID, msg.Presence)
+		return
+	}
+	if msg.Typing != nil {
+		m.handleRemoteTyping(msg.NodeID, msg.Typing)
+		return
+	}
+	if msg.ThreadID != 0 {
+		m.deliverToThread(msg.RoomID, msg.ThreadID, msg.Data)
+		return
+	}
+	m.deliverToRoom(msg.RoomID, msg.Data)
+}
+
+// markSeen records the message ID and reports whether it was new.
+func (m *Manager) markSeen(id string) bool {
+	m.seenMu.Lock()
+	defer m.seenMu.Unlock()
+
+	if m.seen[id] {
+		return false
+	}
+
+	if len(m.seenOrder) >= seenCapacity {
+		delete(m.seen, m.seenOrder[0])
+		m.seenOrder = m.seenOrder[1:]
+	}
+	m.seen[id] = true
+	m.seenOrder = append(m.seenOrder, id)
+	return true
+}
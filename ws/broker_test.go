@@ -0,0 +1,96 @@
+package ws_test
+
+import (
+	"testing"
+	"ws-whatever/ws"
+)
+
+func TestDeliveryAcrossNodes(t *testing.T) {
+	nodes := newTestCluster(t, 2)
+	roomID := nodes[0].createRoom(t, false, 1, 2)
+	root := nodes[0].createMessage(t, roomID, 1, 0, nil)
+
+	sender := nodes[0].dial(t, 1)
+	sender.subscribe(t, roomID)
+	recipient := nodes[1].dial(t, 2)
+	recipient.subscribe(t, roomID)
+	follower := nodes[1].dial(t, 2)
+	follower.send(t, "subscribe_thread", ws.ThreadSubscribePayload{MessageID: root.ID})
+	follower.expect(t, "thread_subscribed", nil)
+
+	tests := []struct {
+		name      string
+		replyToID *int
+		// receiver gets wantEvent on the other node, about the new
+		// message or with wantRoot about its thread.
+		receiver  *testClient
+		wantEvent string
+		wantRoot  bool
+	}{
+		{name: "room message", receiver: recipient, wantEvent: "new_message"},
+		{name: "thread reply", replyToID: &root.ID, receiver: follower, wantEvent: "thread_reply"},
+		{name: "thread counts", replyToID: &root.ID, receiver: recipient, wantEvent: "thread_updated", wantRoot: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sender.send(t, "send_message", ws.SendMessagePayload{RoomID: roomID, Content: tt.name, ReplyToID: tt.replyToID})
+			var ack ws.MessageAckPayload
+			sender.expect(t, "message_ack", &ack)
+
+			var payload struct {
+				ID        int `json:"id"`
+				MessageID int `json:"message_id"`
+			}
+			tt.receiver.expect(t, tt.wantEvent, &payload)
+			got, want := payload.ID, ack.ID
+			if tt.wantRoot {
+				got, want = payload.MessageID, root.ID
+			}
+			if got != want {
+				t.Fatalf("%s for message %d, want %d", tt.wantEvent, got, want)
+			}
+		})
+	}
+
+	// Sequence numbers come from the shared store, so both nodes agree.
+	seq, err := nodes[1].stores.Rooms.LastSeq(roomID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := recipient.subscribe(t, roomID); got != seq {
+		t.Fatalf("seq on the other node = %d, want %d", got, seq)
+	}
+}
+
+func TestPresenceAcrossNodes(t *testing.T) {
+	nodes := newTestCluster(t, 2)
+	roomID := nodes[0].createRoom(t, false, 1, 2)
+
+	watcher := nodes[1].dial(t, 2)
+	watcher.subscribe(t, roomID)
+
+	expectPresence := func(t *testing.T, want ws.PresenceStatus) {
+		t.Helper()
+
+		var presence ws.PresencePayload
+		for range 10 {
+			watcher.expect(t, "presence", &presence)
+			if presence.UserID == 1 && presence.Status == want {
+				break
+			}
+		}
+		if presence.UserID != 1 || presence.Status != want {
+			t.Fatalf("presence = %+v, want user 1 %s", presence, want)
+		}
+		if status, _ := nodes[1].manager.UserPresence(1); status != want {
+			t.Fatalf("UserPresence() on the other node = %s, want %s", status, want)
+		}
+	}
+
+	c := nodes[0].dial(t, 1)
+	expectPresence(t, ws.PresenceOnline)
+
+	c.conn.Close()
+	expectPresence(t, ws.PresenceOffline)
+}
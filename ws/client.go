@@ -4,6 +4,10 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"log/slog"
+	"sort"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/google/uuid"
@@ -16,10 +20,36 @@ type Client struct {
 	Conn    *ws.Conn
 	Manager *Manager
 	Send    chan []byte
-	RoomID  int
+
+	// logger tags every line with the connection and user.
+	logger *slog.Logger
+
+	// closeCode is sent in the close frame once Send is closed. It is set
+	// by the Manager before it closes Send.
+	closeCode int
+
+	// resuming holds live events back per room while a resume replays
+	// what the client missed.
+	resumeMu sync.Mutex
+	resuming map[int][][]byte
+
+	// Rate limit rejections in the current abuse window. Only the read
+	// pump touches these.
+	violations      int
+	violationsSince time.Time
+
+	// overflow holds events that did not fit in Send, as allowed by the
+	// Manager's slow consumer policy. slow marks a client that is being
+	// disconnected for falling behind.
+	outMu       sync.Mutex
+	overflow    []outbound
+	hasOverflow atomic.Bool
+	slow        bool
 }
 
-func NewClient(conn *ws.Conn, m *Manager, userID int) *Client {
+// NewClient wraps an upgraded connection. logger is usually the upgrade
+// request's logger, so the connection can be traced back to it.
+func NewClient(conn *ws.Conn, m *Manager, userID int, logger *slog.Logger) *Client {
 	id := uuid.New().String()
 
 	return &Client{
@@ -27,19 +57,24 @@ func NewClient(conn *ws.Conn, m *Manager, userID int) *Client {
 		UserID:  userID,
 		Conn:    conn,
 		Manager: m,
-		Send:    make(chan []byte, 256),
+		Send:    make(chan []byte, m.config.SendBufferSize),
+		logger:  logger.With("connID", id, "userID", userID),
+
+		closeCode: ws.CloseNormalClosure,
 	}
 }
 
 func (c *Client) ReadMessages() {
 	defer func() {
 		c.Manager.RemoveClient(c)
+		c.Manager.wg.Done()
 	}()
 
-	c.Conn.SetReadLimit(512 * 1024)
-	c.Conn.SetReadDeadline(time.Now().Add(60 * time.Second))
+	config := c.Manager.config
+	c.Conn.SetReadLimit(config.ReadLimit)
+	c.Conn.SetReadDeadline(time.Now().Add(config.PongWait))
 	c.Conn.SetPongHandler(func(string) error {
-		c.Conn.SetReadDeadline(time.Now().Add(60 * time.Second))
+		c.Conn.SetReadDeadline(time.Now().Add(config.PongWait))
 		return nil
 	})
 
@@ -47,47 +82,64 @@ func (c *Client) ReadMessages() {
 		_, p, err := c.Conn.ReadMessage()
 		if err != nil {
 			if ws.IsUnexpectedCloseError(err, ws.CloseGoingAway, ws.CloseAbnormalClosure) {
-				c.Manager.logger.Error("unexpected websocket close", "error", err, "userID", c.UserID)
+				c.logger.Error("unexpected websocket close", "error", err)
 			}
 			break
 		}
 
+		c.Manager.Touch(c)
+
 		event := Event{}
 		if err := json.Unmarshal(p, &event); err != nil {
-			c.Manager.logger.Warn("invalid message format", "error", err, "userID", c.UserID)
+			c.logger.Warn("invalid message format", "error", err)
+			continue
+		}
+
+		if err := c.Manager.checkRate(c, event.Type); err != nil {
+			observeEvent(event.Type, time.Now(), err)
+			if errors.Is(err, errRateLimitAbuse) {
+				c.logger.Warn("closing connection for ignoring rate limits")
+				c.Manager.removeClient(c, ws.ClosePolicyViolation)
+				break
+			}
+			c.sendError(err)
 			continue
 		}
 
 		if err := c.handleEvent(event); err != nil {
-			c.Manager.logger.Error("event handling error", "type", event.Type, "error", err, "userID", c.UserID)
-			c.sendError(err.Error())
+			c.logger.Error("event handling error", "type", event.Type, "error", err)
+			c.sendError(err)
 		}
 	}
 }
 
 func (c *Client) WriteMessages() {
-	ticker := time.NewTicker(54 * time.Second)
+	config := c.Manager.config
+	ticker := time.NewTicker(config.PingInterval)
 	defer func() {
 		ticker.Stop()
 		c.Manager.RemoveClient(c)
+		c.Conn.Close()
+		c.Manager.wg.Done()
 	}()
 
 	for {
 		select {
 		case message, ok := <-c.Send:
-			c.Conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+			c.Conn.SetWriteDeadline(time.Now().Add(config.WriteWait))
 			if !ok {
-				if err := c.Conn.WriteMessage(ws.CloseMessage, nil); err != nil {
-					c.Manager.logger.Error("failed to send close message", "error", err, "userID", c.UserID)
+				if err := c.Conn.WriteMessage(ws.CloseMessage, ws.FormatCloseMessage(c.closeCode, "")); err != nil {
+					c.logger.Error("failed to send close message", "error", err)
 				}
 				return
 			}
 			if err := c.Conn.WriteMessage(ws.TextMessage, message); err != nil {
-				c.Manager.logger.Error("failed to send message", "error", err, "userID", c.UserID)
+				c.logger.Error("failed to send message", "error", err)
 				return
 			}
+			c.Manager.flushOverflow(c)
 		case <-ticker.C:
-			c.Conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+			c.Conn.SetWriteDeadline(time.Now().Add(config.WriteWait))
 			if err := c.Conn.WriteMessage(ws.PingMessage, nil); err != nil {
 				return
 			}
@@ -95,17 +147,41 @@ func (c *Client) WriteMessages() {
 	}
 }
 
+var errUnknownEvent = errors.New("unknown event type")
+
 func (c *Client) handleEvent(event Event) error {
-	switch event.Type {
-	case "send_message":
-		return c.handleSendMessage(event.Payload)
-	case "join_room":
-		return c.handleJoinRoom(event.Payload)
-	case "typing":
-		return c.handleTyping()
-	default:
-		return fmt.Errorf("unknown event type: %s", event.Type)
+	start := time.Now()
+	err := c.dispatchEvent(event)
+	observeEvent(event.Type, start, err)
+	return err
+}
+
+// eventHandlers maps every event type a client may send to its handler.
+var eventHandlers = map[string]func(c *Client, payload interface{}) error{
+	"send_message":       (*Client).handleSendMessage,
+	"join_room":          (*Client).handleJoinRoom,
+	"subscribe":          (*Client).handleSubscribe,
+	"unsubscribe":        (*Client).handleUnsubscribe,
+	"subscribe_thread":   func(c *Client, payload interface{}) error { return c.handleThreadSubscription(payload, true) },
+	"unsubscribe_thread": func(c *Client, payload interface{}) error { return c.handleThreadSubscription(payload, false) },
+	"resume":             (*Client).handleResume,
+	"load_history":       (*Client).handleLoadHistory,
+	"typing":             func(c *Client, payload interface{}) error { return c.handleTyping(payload, true) },
+	"typing_stop":        func(c *Client, payload interface{}) error { return c.handleTyping(payload, false) },
+	"edit_message":       (*Client).handleEditMessage,
+	"add_reaction":       func(c *Client, payload interface{}) error { return c.handleReaction(payload, true) },
+	"remove_reaction":    func(c *Client, payload interface{}) error { return c.handleReaction(payload, false) },
+	"mark_read":          (*Client).handleMarkRead,
+	"pin_message":        func(c *Client, payload interface{}) error { return c.handlePin(payload, true) },
+	"unpin_message":      func(c *Client, payload interface{}) error { return c.handlePin(payload, false) },
+}
+
+func (c *Client) dispatchEvent(event Event) error {
+	handle, ok := eventHandlers[event.Type]
+	if !ok {
+		return fmt.Errorf("%w: %s", errUnknownEvent, event.Type)
 	}
+	return handle(c, event.Payload)
 }
 
 func (c *Client) handleSendMessage(payload interface{}) error {
@@ -119,44 +195,127 @@ func (c *Client) handleSendMessage(payload interface{}) error {
 		return err
 	}
 
-	if msg.Content == "" {
-		return errors.New("message content cannot be empty")
+	message, duplicate, err := c.sendMessage(msg)
+	if err != nil {
+		// Senders that tagged the message get a nack they can match to it.
+		if msg.ClientMsgID == "" {
+			return err
+		}
+
+		c.logger.Warn("message rejected", "error", err, "clientMsgID", msg.ClientMsgID)
+		return c.sendEvent(Event{
+			Type: "message_nack",
+			Payload: MessageNackPayload{
+				ClientMsgID: msg.ClientMsgID,
+				RoomID:      msg.RoomID,
+				Code:        errorCode(err),
+				Reason:      err.Error(),
+			},
+		})
+	}
+
+	return c.sendEvent(Event{
+		Type: "message_ack",
+		Payload: MessageAckPayload{
+			ClientMsgID: msg.ClientMsgID,
+			ID:          message.ID,
+			RoomID:      message.RoomID,
+			CreatedAt:   message.CreatedAt,
+			Duplicate:   duplicate,
+		},
+	})
+}
+
+// sendMessage stores and broadcasts the message. A retry of a message that
+// was already stored returns the original with duplicate set and is not
+// broadcast again.
+func (c *Client) sendMessage(msg SendMessagePayload) (*Message, bool, error) {
+	if msg.Content == "" && len(msg.AttachmentIDs) == 0 {
+		return nil, false, ErrEmptyContent
+	}
+
+	if msg.RoomID == 0 {
+		return nil, false, errors.New("room_id is required")
+	}
+
+	if len(msg.ClientMsgID) > maxClientMsgIDLength {
+		return nil, false, fmt.Errorf("%w: must be at most %d characters", ErrInvalidClientMsgID, maxClientMsgIDLength)
 	}
 
-	if c.RoomID == 0 {
-		return errors.New("must join a room before sending messages")
+	if _, err := Authorize(c.Manager.store, c.UserID, msg.RoomID, ActionPost); err != nil {
+		return nil, false, err
+	}
+
+	if existing, err := c.findSent(msg); err != nil || existing != nil {
+		return existing, existing != nil, err
 	}
 
 	message := Message{
-		RoomID:    c.RoomID,
-		SenderID:  c.UserID,
-		Content:   msg.Content,
-		ReplyToID: msg.ReplyToID,
+		RoomID:   msg.RoomID,
+		SenderID: c.UserID,
+		Content:  msg.Content,
+	}
+	if msg.ReplyToID != nil {
+		if err := c.Manager.checkThreadRoot(msg.RoomID, *msg.ReplyToID); err != nil {
+			return nil, false, err
+		}
+		message.ReplyToID = msg.ReplyToID
+	}
+	if msg.ClientMsgID != "" {
+		message.ClientMsgID = &msg.ClientMsgID
 	}
 
-	if err := c.Manager.db.Create(&message).Error; err != nil {
-		return fmt.Errorf("failed to save message: %w", err)
+	attachments, err := c.Manager.store.Messages.CreateMessage(&message, msg.AttachmentIDs)
+	if err != nil {
+		// A concurrent retry may have won the unique index on client_msg_id.
+		existing, findErr := c.findSent(msg)
+		switch {
+		case existing != nil:
+			return existing, true, nil
+		case errors.Is(findErr, ErrInvalidClientMsgID):
+			return nil, false, findErr
+		}
+		return nil, false, fmt.Errorf("failed to save message: %w", err)
 	}
 
+	newMessage := newMessagePayload(message)
+	newMessage.Attachments = attachments
+
 	outgoing := Event{
-		Type: "new_message",
-		Payload: NewMessagePayload{
-			ID:        message.ID,
-			RoomID:    message.RoomID,
-			SenderID:  message.SenderID,
-			Content:   message.Content,
-			ReplyToID: message.ReplyToID,
-			CreatedAt: message.CreatedAt,
-		},
+		Type:    "new_message",
+		Payload: newMessage,
 	}
 
-	data, err = json.Marshal(outgoing)
+	// The message is stored either way, so the sender still gets an ack.
+	// Replies go to the thread's subscribers; the room only gets the new
+	// reply count.
+	if message.ReplyToID != nil {
+		err = c.Manager.broadcastThreadReply(newMessage)
+	} else {
+		err = c.Manager.BroadcastRoomEvent(message.RoomID, outgoing)
+	}
 	if err != nil {
-		return err
+		c.logger.Error("failed to broadcast message", "error", err, "messageID", message.ID)
 	}
 
-	c.Manager.BroadcastToRoom(c.RoomID, data)
-	return nil
+	if c.Manager.StopTyping(message.RoomID, c.UserID) {
+		c.Manager.broadcastTyping(message.RoomID)
+	}
+	return &message, false, nil
+}
+
+// findSent returns the message the user already sent with msg's
+// client_msg_id, if any. Reusing an id in another room is an error rather
+// than a duplicate, or the sender would be acked for the wrong message.
+func (c *Client) findSent(msg SendMessagePayload) (*Message, error) {
+	existing, err := c.Manager.store.Messages.FindByClientMsgID(c.UserID, msg.ClientMsgID)
+	if err != nil || existing == nil {
+		return existing, err
+	}
+	if existing.RoomID != msg.RoomID {
+		return nil, fmt.Errorf("%w: already used for a message in room %d", ErrInvalidClientMsgID, existing.RoomID)
+	}
+	return existing, nil
 }
 
 func (c *Client) handleJoinRoom(payload interface{}) error {
@@ -170,85 +329,342 @@ func (c *Client) handleJoinRoom(payload interface{}) error {
 		return err
 	}
 
-	if err := c.Manager.JoinRoom(c, join.RoomID); err != nil {
+	if err := c.Manager.Subscribe(c, join.RoomID); err != nil {
 		return fmt.Errorf("failed to join room: %w", err)
 	}
 
-	var messages []Message
-	err = c.Manager.db.
-		Where("room_id = ? AND deleted_at IS NULL", join.RoomID).
-		Order("created_at DESC").
-		Limit(50).
-		Find(&messages).Error
+	return c.sendHistory(join.RoomID, HistoryCursor{}, c.Manager.config.HistoryLimit)
+}
+
+func (c *Client) handleSubscribe(payload interface{}) error {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	var sub SubscribePayload
+	if err := json.Unmarshal(data, &sub); err != nil {
+		return err
+	}
+
+	if err := c.Manager.Subscribe(c, sub.RoomID); err != nil {
+		return fmt.Errorf("failed to subscribe: %w", err)
+	}
+
+	seq, err := c.Manager.store.Rooms.LastSeq(sub.RoomID)
+	if err != nil {
+		return err
+	}
+
+	return c.sendEvent(Event{
+		Type:    "subscribed",
+		Payload: SubscribedPayload{RoomID: sub.RoomID, Seq: seq},
+	})
+}
+
+func (c *Client) handleUnsubscribe(payload interface{}) error {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	var sub SubscribePayload
+	if err := json.Unmarshal(data, &sub); err != nil {
+		return err
+	}
+
+	c.Manager.Unsubscribe(c, sub.RoomID)
+
+	return c.sendEvent(Event{Type: "unsubscribed", Payload: sub})
+}
+
+func (c *Client) handleThreadSubscription(payload interface{}, subscribe bool) error {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	var sub ThreadSubscribePayload
+	if err := json.Unmarshal(data, &sub); err != nil {
+		return err
+	}
+
+	if !subscribe {
+		c.Manager.UnsubscribeThread(c, sub.MessageID)
+		return c.sendEvent(Event{Type: "thread_unsubscribed", Payload: sub})
+	}
+
+	if err := c.Manager.SubscribeThread(c, sub.MessageID); err != nil {
+		return fmt.Errorf("failed to subscribe to thread: %w", err)
+	}
+	return c.sendEvent(Event{Type: "thread_subscribed", Payload: sub})
+}
+
+func (c *Client) handleResume(payload interface{}) error {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	var resume ResumePayload
+	if err := json.Unmarshal(data, &resume); err != nil {
+		return err
+	}
+
+	roomIDs := make([]int, 0, len(resume.Rooms))
+	for roomID := range resume.Rooms {
+		roomIDs = append(roomIDs, roomID)
+	}
+	sort.Ints(roomIDs)
+
+	// One room the user lost access to should not stop the others.
+	for _, roomID := range roomIDs {
+		if err := c.Manager.Resume(c, roomID, resume.Rooms[roomID]); err != nil {
+			c.sendError(fmt.Errorf("failed to resume room %d: %w", roomID, err))
+		}
+	}
+
+	return nil
+}
+
+func (c *Client) handleLoadHistory(payload interface{}) error {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	var load LoadHistoryPayload
+	if err := json.Unmarshal(data, &load); err != nil {
+		return err
+	}
+
+	if _, err := Authorize(c.Manager.store, c.UserID, load.RoomID, ActionRead); err != nil {
+		return err
+	}
+
+	cursor := HistoryCursor{BeforeID: load.BeforeID, AfterID: load.AfterID}
+	return c.sendHistory(load.RoomID, cursor, c.Manager.config.ClampHistoryLimit(load.Limit))
+}
+
+func (c *Client) sendHistory(roomID int, cursor HistoryCursor, limit int) error {
+	// The latest page tells the client where live events pick up. Reading
+	// the sequence first means an event racing the query can only show up
+	// twice, never go missing.
+	var seq int64
+	if cursor.BeforeID == nil && cursor.AfterID == nil {
+		var err error
+		if seq, err = c.Manager.store.Rooms.LastSeq(roomID); err != nil {
+			return err
+		}
+	}
+
+	messages, next, err := c.Manager.store.Messages.History(roomID, cursor, limit)
 	if err != nil {
 		return fmt.Errorf("failed to load history: %w", err)
 	}
 
+	messageIDs := make([]int, len(messages))
+	for i, msg := range messages {
+		messageIDs[i] = msg.ID
+	}
+
+	reactions, err := c.Manager.store.Messages.ReactionSummaries(c.UserID, messageIDs)
+	if err != nil {
+		return fmt.Errorf("failed to load reactions: %w", err)
+	}
+
+	attachments, err := c.Manager.store.Messages.Attachments(messageIDs)
+	if err != nil {
+		return fmt.Errorf("failed to load attachments: %w", err)
+	}
+
+	threads, err := c.Manager.store.Messages.ThreadSummaries(messageIDs)
+	if err != nil {
+		return fmt.Errorf("failed to load threads: %w", err)
+	}
+
 	history := make([]NewMessagePayload, len(messages))
-	for i := len(messages) - 1; i >= 0; i-- {
-		history[len(messages)-1-i] = NewMessagePayload{
-			ID:        messages[i].ID,
-			RoomID:    messages[i].RoomID,
-			SenderID:  messages[i].SenderID,
-			Content:   messages[i].Content,
-			ReplyToID: messages[i].ReplyToID,
-			CreatedAt: messages[i].CreatedAt,
-		}
+	for i, msg := range messages {
+		history[i] = newMessagePayload(msg)
+		history[i].Reactions = reactions[msg.ID]
+		history[i].Attachments = attachments[msg.ID]
+		history[i].ReplyCount = threads[msg.ID].ReplyCount
+		history[i].LastReplyAt = threads[msg.ID].LastReplyAt
 	}
 
-	response := Event{
-		Type:    "history",
-		Payload: HistoryPayload{Messages: history},
+	return c.sendEvent(Event{
+		Type: "history",
+		Payload: HistoryPayload{
+			RoomID:     roomID,
+			Messages:   history,
+			BeforeID:   cursor.BeforeID,
+			AfterID:    cursor.AfterID,
+			NextCursor: next,
+			Seq:        seq,
+		},
+	})
+}
+
+// sendEvent queues an event for this connection only.
+func (c *Client) sendEvent(event Event) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	if !c.Manager.send(c, data) {
+		c.logger.Warn("failed to send event", "type", event.Type)
 	}
 
-	data, err = json.Marshal(response)
+	return nil
+}
+
+func (c *Client) handleEditMessage(payload interface{}) error {
+	data, err := json.Marshal(payload)
 	if err != nil {
 		return err
 	}
 
-	select {
-	case c.Send <- data:
-	default:
-		c.Manager.logger.Warn("failed to send history, buffer full", "userID", c.UserID)
+	var edit EditMessagePayload
+	if err := json.Unmarshal(data, &edit); err != nil {
+		return err
+	}
+
+	if _, err := c.Manager.EditMessage(edit.MessageID, c.UserID, edit.Content); err != nil {
+		return fmt.Errorf("failed to edit message: %w", err)
 	}
 
 	return nil
 }
 
-func (c *Client) handleTyping() error {
-	if c.RoomID == 0 {
-		return errors.New("must join a room first")
+func (c *Client) handleReaction(payload interface{}, add bool) error {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return err
 	}
 
-	c.Manager.SetTyping(c.RoomID, c.UserID)
-	
-	typingUsers := c.Manager.GetTypingUsers(c.RoomID)
-	
-	event := Event{
-		Type: "typing",
-		Payload: TypingPayload{
-			UserIDs: typingUsers,
-		},
+	var reaction ReactionPayload
+	if err := json.Unmarshal(data, &reaction); err != nil {
+		return err
+	}
+
+	if add {
+		err = c.Manager.AddReaction(reaction.MessageID, c.UserID, reaction.ReactionType)
+	} else {
+		err = c.Manager.RemoveReaction(reaction.MessageID, c.UserID, reaction.ReactionType)
 	}
-	
-	data, err := json.Marshal(event)
 	if err != nil {
+		return fmt.Errorf("failed to update reaction: %w", err)
+	}
+
+	return nil
+}
+
+func (c *Client) handleMarkRead(payload interface{}) error {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	var read MarkReadPayload
+	if err := json.Unmarshal(data, &read); err != nil {
 		return err
 	}
-	
-	c.Manager.BroadcastToRoom(c.RoomID, data)
+
+	if err := c.Manager.MarkRead(read.MessageID, c.UserID); err != nil {
+		return fmt.Errorf("failed to mark read: %w", err)
+	}
+
 	return nil
 }
 
-func (c *Client) sendError(message string) {
+func (c *Client) handlePin(payload interface{}, pinned bool) error {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	var pin PinPayload
+	if err := json.Unmarshal(data, &pin); err != nil {
+		return err
+	}
+
+	if pinned {
+		err = c.Manager.PinMessage(pin.MessageID, c.UserID)
+	} else {
+		err = c.Manager.UnpinMessage(pin.MessageID, c.UserID)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to update pin: %w", err)
+	}
+
+	return nil
+}
+
+func (c *Client) handleTyping(payload interface{}, typing bool) error {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	var event TypingEventPayload
+	if err := json.Unmarshal(data, &event); err != nil {
+		return err
+	}
+
+	if !c.Manager.IsSubscribed(c, event.RoomID) {
+		return errors.New("must subscribe to the room first")
+	}
+
+	var changed bool
+	if typing {
+		changed = c.Manager.SetTyping(event.RoomID, c.UserID)
+	} else {
+		changed = c.Manager.StopTyping(event.RoomID, c.UserID)
+	}
+
+	if changed {
+		c.Manager.broadcastTyping(event.RoomID)
+	}
+	return nil
+}
+
+func (c *Client) sendError(err error) {
+	payload := ErrorPayload{
+		Code:    errorCode(err),
+		Message: err.Error(),
+	}
+
+	var rateErr *RateLimitError
+	if errors.As(err, &rateErr) {
+		payload.RetryAfterMs = rateErr.RetryAfter.Milliseconds()
+	}
+
 	errorEvent := Event{
 		Type:    "error",
-		Payload: map[string]string{"message": message},
+		Payload: payload,
 	}
 	data, _ := json.Marshal(errorEvent)
 
-	select {
-	case c.Send <- data:
+	c.Manager.send(c, data)
+}
+
+// errorCode classifies err so clients can react to denials without parsing
+// the message.
+func errorCode(err error) string {
+	var rateErr *RateLimitError
+	switch {
+	case errors.As(err, &rateErr):
+		return "rate_limited"
+	case IsForbidden(err):
+		return "forbidden"
+	case errors.Is(err, ErrRoomNotFound), errors.Is(err, ErrMessageNotFound):
+		return "not_found"
+	case errors.Is(err, ErrEmptyContent), errors.Is(err, ErrInvalidReaction), errors.Is(err, ErrInvalidClientMsgID),
+		errors.Is(err, ErrInvalidAttachments), errors.Is(err, ErrInvalidCursor), errors.Is(err, ErrInvalidReply),
+		errors.Is(err, ErrNestedReply), errors.Is(err, errUnknownEvent):
+		return "invalid_request"
 	default:
+		return "error"
 	}
 }
@@ -0,0 +1,203 @@
+package ws_test
+
+import (
+	"slices"
+	"strings"
+	"testing"
+	"time"
+	"ws-whatever/ws"
+)
+
+func TestSendMessage(t *testing.T) {
+	const userID = 1
+
+	type send struct {
+		room        string
+		content     string
+		clientMsgID string
+	}
+
+	tests := []struct {
+		name string
+		// before are sent and acked first.
+		before []send
+		send   send
+		// wantNack is the nack code, or empty for an ack.
+		wantNack      string
+		wantDuplicate bool
+	}{
+		{
+			name: "new message",
+			send: send{room: "general", content: "hello", clientMsgID: "a"},
+		},
+		{
+			name:          "retry is acked as a duplicate",
+			before:        []send{{room: "general", content: "hello", clientMsgID: "a"}},
+			send:          send{room: "general", content: "hello", clientMsgID: "a"},
+			wantDuplicate: true,
+		},
+		{
+			name:     "client_msg_id reused in another room",
+			before:   []send{{room: "general", content: "hello", clientMsgID: "a"}},
+			send:     send{room: "random", content: "hello", clientMsgID: "a"},
+			wantNack: "invalid_request",
+		},
+		{
+			name:     "empty content",
+			send:     send{room: "general", clientMsgID: "a"},
+			wantNack: "invalid_request",
+		},
+		{
+			name:     "client_msg_id too long",
+			send:     send{room: "general", content: "hello", clientMsgID: strings.Repeat("a", 65)},
+			wantNack: "invalid_request",
+		},
+		{
+			name:     "room the user is not in",
+			send:     send{room: "private", content: "hello", clientMsgID: "a"},
+			wantNack: "forbidden",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := newTestServer(t)
+			rooms := map[string]int{
+				"general": srv.createRoom(t, false, userID),
+				"random":  srv.createRoom(t, false, userID),
+				"private": srv.createRoom(t, true, 2),
+			}
+
+			c := srv.dial(t, userID)
+			c.subscribe(t, rooms["general"])
+
+			payload := func(s send) ws.SendMessagePayload {
+				return ws.SendMessagePayload{RoomID: rooms[s.room], Content: s.content, ClientMsgID: s.clientMsgID}
+			}
+
+			var first ws.MessageAckPayload
+			for _, s := range tt.before {
+				c.send(t, "send_message", payload(s))
+				c.expect(t, "message_ack", &first)
+			}
+
+			c.send(t, "send_message", payload(tt.send))
+
+			if tt.wantNack != "" {
+				var nack ws.MessageNackPayload
+				c.expect(t, "message_nack", &nack)
+				if nack.Code != tt.wantNack || nack.ClientMsgID != tt.send.clientMsgID {
+					t.Fatalf("nack = %+v, want code %q for %q", nack, tt.wantNack, tt.send.clientMsgID)
+				}
+				return
+			}
+
+			var ack ws.MessageAckPayload
+			c.expect(t, "message_ack", &ack)
+			if ack.Duplicate != tt.wantDuplicate || ack.RoomID != rooms[tt.send.room] {
+				t.Fatalf("ack = %+v, want duplicate %v in room %d", ack, tt.wantDuplicate, rooms[tt.send.room])
+			}
+			if tt.wantDuplicate && ack.ID != first.ID {
+				t.Fatalf("duplicate ack for message %d, want %d", ack.ID, first.ID)
+			}
+
+			messages, _, err := srv.stores.Messages.History(rooms[tt.send.room], ws.HistoryCursor{}, 10)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(messages) != 1 || messages[0].ID != ack.ID {
+				t.Fatalf("history has %d messages, want only %d", len(messages), ack.ID)
+			}
+		})
+	}
+}
+
+func TestSendMessageBroadcast(t *testing.T) {
+	srv := newTestServer(t)
+	roomID := srv.createRoom(t, false, 1, 2)
+
+	sender := srv.dial(t, 1)
+	recipient := srv.dial(t, 2)
+	sender.subscribe(t, roomID)
+	recipient.subscribe(t, roomID)
+
+	sender.send(t, "send_message", ws.SendMessagePayload{RoomID: roomID, Content: "hello", ClientMsgID: "a"})
+	var ack ws.MessageAckPayload
+	sender.expect(t, "message_ack", &ack)
+
+	var message ws.NewMessagePayload
+	event := recipient.expect(t, "new_message", &message)
+	if message.ID != ack.ID || message.ClientMsgID != "a" || event.Seq == 0 {
+		t.Fatalf("new_message %+v with seq %d, want message %d with a seq", message, event.Seq, ack.ID)
+	}
+}
+
+func TestLoadHistory(t *testing.T) {
+	srv := newTestServer(t)
+	roomID := srv.createRoom(t, false, 1)
+
+	var ids []int
+	for i := range 5 {
+		ids = append(ids, srv.createMessage(t, roomID, 1, time.Duration(i)*time.Second, nil).ID)
+	}
+	// Replies stay out of room history.
+	srv.createMessage(t, roomID, 1, 10*time.Second, &ids[0])
+
+	tests := []struct {
+		name     string
+		load     ws.LoadHistoryPayload
+		wantIDs  []int
+		wantNext *int
+	}{
+		{
+			name:     "latest page",
+			load:     ws.LoadHistoryPayload{Limit: 2},
+			wantIDs:  ids[3:5],
+			wantNext: &ids[3],
+		},
+		{
+			name:     "before a message",
+			load:     ws.LoadHistoryPayload{BeforeID: &ids[3], Limit: 2},
+			wantIDs:  ids[1:3],
+			wantNext: &ids[1],
+		},
+		{
+			name:    "last page",
+			load:    ws.LoadHistoryPayload{BeforeID: &ids[1], Limit: 2},
+			wantIDs: ids[:1],
+		},
+		{
+			name:     "after a message",
+			load:     ws.LoadHistoryPayload{AfterID: &ids[0], Limit: 3},
+			wantIDs:  ids[1:4],
+			wantNext: &ids[3],
+		},
+		{
+			name:    "after the newest message",
+			load:    ws.LoadHistoryPayload{AfterID: &ids[4], Limit: 2},
+			wantIDs: []int{},
+		},
+	}
+
+	c := srv.dial(t, 1)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.load.RoomID = roomID
+			c.send(t, "load_history", tt.load)
+
+			var history ws.HistoryPayload
+			c.expect(t, "history", &history)
+
+			got := []int{}
+			for _, m := range history.Messages {
+				got = append(got, m.ID)
+			}
+			if !slices.Equal(got, tt.wantIDs) {
+				t.Fatalf("messages = %v, want %v", got, tt.wantIDs)
+			}
+			if (history.NextCursor == nil) != (tt.wantNext == nil) || (tt.wantNext != nil && *history.NextCursor != *tt.wantNext) {
+				t.Fatalf("next_cursor = %v, want %v", history.NextCursor, tt.wantNext)
+			}
+		})
+	}
+}
@@ -0,0 +1,96 @@
+package ws
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"time"
+)
+
+// Config holds the websocket settings shared by the Manager and its
+// clients.
+type Config struct {
+	// Upgrader buffer sizes in bytes.
+	ReadBufferSize  int `yaml:"read_buffer_size"`
+	WriteBufferSize int `yaml:"write_buffer_size"`
+
+	// SendBufferSize is how many events a client's Send channel holds
+	// before the slow consumer policy kicks in.
+	SendBufferSize int `yaml:"send_buffer_size"`
+
+	// ReadLimit is the largest client message accepted, in bytes.
+	ReadLimit int64 `yaml:"read_limit"`
+
+	// A client that sends nothing, not even a pong, for PongWait is
+	// dropped. Pings go out every PingInterval, which must be shorter.
+	PongWait     time.Duration `yaml:"pong_wait"`
+	PingInterval time.Duration `yaml:"ping_interval"`
+	WriteWait    time.Duration `yaml:"write_wait"`
+
+	HistoryLimit    int `yaml:"history_limit"`
+	MaxHistoryLimit int `yaml:"max_history_limit"`
+
+	RateLimits         map[string]RateLimit `yaml:"rate_limits"`
+	SlowConsumerPolicy SlowConsumerPolicy   `yaml:"slow_consumer_policy"`
+	OverflowLimit      int                  `yaml:"overflow_limit"`
+}
+
+func DefaultConfig() Config {
+	return Config{
+		ReadBufferSize:     1024,
+		WriteBufferSize:    1024,
+		SendBufferSize:     256,
+		ReadLimit:          512 * 1024,
+		PongWait:           60 * time.Second,
+		PingInterval:       54 * time.Second,
+		WriteWait:          10 * time.Second,
+		HistoryLimit:       DefaultHistoryLimit,
+		MaxHistoryLimit:    MaxHistoryLimit,
+		RateLimits:         maps.Clone(DefaultRateLimits),
+		SlowConsumerPolicy: SlowConsumerCoalesce,
+		OverflowLimit:      DefaultOverflowLimit,
+	}
+}
+
+func (c Config) Validate() error {
+	var errs []error
+
+	if c.ReadBufferSize <= 0 || c.WriteBufferSize <= 0 || c.SendBufferSize <= 0 {
+		errs = append(errs, errors.New("buffer sizes must be positive"))
+	}
+	if c.ReadLimit <= 0 {
+		errs = append(errs, errors.New("read_limit must be positive"))
+	}
+	if c.PongWait <= 0 || c.WriteWait <= 0 {
+		errs = append(errs, errors.New("pong_wait and write_wait must be positive"))
+	}
+	if c.PingInterval <= 0 || c.PingInterval >= c.PongWait {
+		errs = append(errs, errors.New("ping_interval must be positive and shorter than pong_wait"))
+	}
+	if c.HistoryLimit <= 0 || c.HistoryLimit > c.MaxHistoryLimit {
+		errs = append(errs, errors.New("history_limit must be positive and at most max_history_limit"))
+	}
+	for eventType, limit := range c.RateLimits {
+		if limit.Rate <= 0 || limit.Burst < 1 {
+			errs = append(errs, fmt.Errorf("rate limit for %s needs a positive rate and burst", eventType))
+		}
+	}
+	if _, err := ParseSlowConsumerPolicy(string(c.SlowConsumerPolicy)); err != nil {
+		errs = append(errs, err)
+	}
+	if c.OverflowLimit <= 0 {
+		errs = append(errs, errors.New("overflow_limit must be positive"))
+	}
+
+	return errors.Join(errs...)
+}
+
+// ClampHistoryLimit turns a requested page size into one within the
+// configured bounds: unset or invalid values get the default and larger
+// ones are capped at MaxHistoryLimit.
+func (c Config) ClampHistoryLimit(limit int) int {
+	if limit <= 0 {
+		return c.HistoryLimit
+	}
+	return min(limit, c.MaxHistoryLimit)
+}
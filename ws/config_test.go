@@ -0,0 +1,31 @@
+package ws_test
+
+import (
+	"testing"
+	"ws-whatever/ws"
+)
+
+func TestClampHistoryLimit(t *testing.T) {
+	cfg := ws.DefaultConfig()
+	cfg.HistoryLimit, cfg.MaxHistoryLimit = 50, 100
+
+	tests := []struct {
+		name  string
+		limit int
+		want  int
+	}{
+		{name: "unset", limit: 0, want: 50},
+		{name: "negative", limit: -1, want: 50},
+		{name: "within bounds", limit: 20, want: 20},
+		{name: "at the max", limit: 100, want: 100},
+		{name: "above the max", limit: 500, want: 100},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := cfg.ClampHistoryLimit(tt.limit); got != tt.want {
+				t.Fatalf("ClampHistoryLimit(%d) = %d, want %d", tt.limit, got, tt.want)
+			}
+		})
+	}
+}
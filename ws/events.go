@@ -1,34 +1,219 @@
 package ws
 
-import "time"
+import (
+	"encoding/json"
+	"time"
+)
 
 type Event struct {
 	Type    string      `json:"type"`
+	Seq     int64       `json:"seq,omitempty"`
 	Payload interface{} `json:"payload,omitempty"`
 }
 
 type SendMessagePayload struct {
-	Content   string `json:"content"`
-	ReplyToID *int   `json:"reply_to_id,omitempty"`
+	RoomID        int    `json:"room_id"`
+	Content       string `json:"content"`
+	ReplyToID     *int   `json:"reply_to_id,omitempty"`
+	AttachmentIDs []int  `json:"attachment_ids,omitempty"`
+	ClientMsgID   string `json:"client_msg_id,omitempty"`
 }
 
 type NewMessagePayload struct {
+	ID          int                 `json:"id"`
+	RoomID      int                 `json:"room_id"`
+	SenderID    int                 `json:"sender_id"`
+	Content     string              `json:"content"`
+	ReplyToID   *int                `json:"reply_to_id,omitempty"`
+	ReplyCount  int                 `json:"reply_count"`
+	LastReplyAt *time.Time          `json:"last_reply_at,omitempty"`
+	IsPinned    bool                `json:"is_pinned"`
+	IsEdited    bool                `json:"is_edited"`
+	CreatedAt   time.Time           `json:"created_at"`
+	UpdatedAt   *time.Time          `json:"updated_at,omitempty"`
+	Reactions   []ReactionSummary   `json:"reactions,omitempty"`
+	Attachments []AttachmentPayload `json:"attachments,omitempty"`
+	ClientMsgID string              `json:"client_msg_id,omitempty"`
+}
+
+type AttachmentPayload struct {
+	ID       int    `json:"id"`
+	FileName string `json:"file_name"`
+	FileType string `json:"file_type"`
+	FileMime string `json:"file_mime"`
+	FileSize int    `json:"file_size"`
+	URL      string `json:"url"`
+}
+
+type EditMessagePayload struct {
+	MessageID int    `json:"message_id"`
+	Content   string `json:"content"`
+}
+
+type MessageEditedPayload struct {
+	ID        int        `json:"id"`
+	RoomID    int        `json:"room_id"`
+	SenderID  int        `json:"sender_id"`
+	Content   string     `json:"content"`
+	UpdatedAt *time.Time `json:"updated_at"`
+}
+
+type ReactionPayload struct {
+	MessageID    int    `json:"message_id"`
+	ReactionType string `json:"reaction_type"`
+}
+
+type ReactionSummary struct {
+	Type        string `json:"type"`
+	Count       int    `json:"count"`
+	ReactedByMe bool   `json:"reacted_by_me"`
+}
+
+type ReactionUpdatedPayload struct {
+	MessageID    int            `json:"message_id"`
+	RoomID       int            `json:"room_id"`
+	UserID       int            `json:"user_id"`
+	ReactionType string         `json:"reaction_type"`
+	Added        bool           `json:"added"`
+	Counts       map[string]int `json:"counts"`
+}
+
+type MarkReadPayload struct {
+	MessageID int `json:"message_id"`
+}
+
+type ReadReceiptPayload struct {
+	RoomID    int       `json:"room_id"`
+	UserID    int       `json:"user_id"`
+	MessageID int       `json:"message_id"`
+	ReadAt    time.Time `json:"read_at"`
+}
+
+type PinPayload struct {
+	MessageID int `json:"message_id"`
+}
+
+type MessagePinnedPayload struct {
+	MessageID int `json:"message_id"`
+	RoomID    int `json:"room_id"`
+	UserID    int `json:"user_id"`
+}
+
+type MessageAckPayload struct {
+	ClientMsgID string    `json:"client_msg_id,omitempty"`
+	ID          int       `json:"id"`
+	RoomID      int       `json:"room_id"`
+	CreatedAt   time.Time `json:"created_at"`
+	Duplicate   bool      `json:"duplicate"`
+}
+
+type MessageNackPayload struct {
+	ClientMsgID string `json:"client_msg_id"`
+	RoomID      int    `json:"room_id"`
+	Code        string `json:"code"`
+	Reason      string `json:"reason"`
+}
+
+type ErrorPayload struct {
+	Code         string `json:"code"`
+	Message      string `json:"message"`
+	RetryAfterMs int64  `json:"retry_after_ms,omitempty"`
+}
+
+type PresencePayload struct {
+	UserID       int            `json:"user_id"`
+	Status       PresenceStatus `json:"status"`
+	LastActiveAt time.Time      `json:"last_active_at"`
+}
+
+type MessageDeletedPayload struct {
 	ID        int       `json:"id"`
 	RoomID    int       `json:"room_id"`
-	SenderID  int       `json:"sender_id"`
-	Content   string    `json:"content"`
-	ReplyToID *int      `json:"reply_to_id,omitempty"`
-	CreatedAt time.Time `json:"created_at"`
+	DeletedAt time.Time `json:"deleted_at"`
+}
+
+type SubscribedPayload struct {
+	RoomID int   `json:"room_id"`
+	Seq    int64 `json:"seq"`
+}
+
+type ResumePayload struct {
+	Rooms map[int]int64 `json:"rooms"`
+}
+
+type ReplayPayload struct {
+	RoomID    int               `json:"room_id"`
+	Events    []json.RawMessage `json:"events"`
+	Seq       int64             `json:"seq"`
+	Truncated bool              `json:"truncated"`
+}
+
+type ServerRestartingPayload struct {
+	ReconnectAfterMs int64 `json:"reconnect_after_ms"`
 }
 
 type JoinRoomPayload struct {
 	RoomID int `json:"room_id"`
 }
 
+type LoadHistoryPayload struct {
+	RoomID   int  `json:"room_id"`
+	BeforeID *int `json:"before_id,omitempty"`
+	AfterID  *int `json:"after_id,omitempty"`
+	Limit    int  `json:"limit,omitempty"`
+}
+
 type HistoryPayload struct {
-	Messages []NewMessagePayload `json:"messages"`
+	RoomID     int                 `json:"room_id"`
+	Messages   []NewMessagePayload `json:"messages"`
+	BeforeID   *int                `json:"before_id,omitempty"`
+	AfterID    *int                `json:"after_id,omitempty"`
+	NextCursor *int                `json:"next_cursor"`
+	Seq        int64               `json:"seq,omitempty"`
+}
+
+type SubscribePayload struct {
+	RoomID int `json:"room_id"`
+}
+
+// ThreadSubscribePayload subscribes to or unsubscribes from thread_reply
+// events for a thread. Live, those go to subscribers only, but they carry
+// the room's seq and resuming the room replays them with its other events.
+// Unsubscribing from the room also ends its thread subscriptions.
+type ThreadSubscribePayload struct {
+	MessageID int `json:"message_id"`
+}
+
+type ThreadUpdatedPayload struct {
+	MessageID   int        `json:"message_id"`
+	RoomID      int        `json:"room_id"`
+	ReplyCount  int        `json:"reply_count"`
+	LastReplyAt *time.Time `json:"last_reply_at"`
+}
+
+type TypingEventPayload struct {
+	RoomID int `json:"room_id"`
 }
 
 type TypingPayload struct {
+	RoomID  int   `json:"room_id"`
 	UserIDs []int `json:"user_ids"`
 }
+
+func newMessagePayload(msg Message) NewMessagePayload {
+	payload := NewMessagePayload{
+		ID:        msg.ID,
+		RoomID:    msg.RoomID,
+		SenderID:  msg.SenderID,
+		Content:   msg.Content,
+		ReplyToID: msg.ReplyToID,
+		IsPinned:  msg.IsPinned,
+		IsEdited:  msg.IsEdited,
+		CreatedAt: msg.CreatedAt,
+		UpdatedAt: msg.UpdatedAt,
+	}
+	if msg.ClientMsgID != nil {
+		payload.ClientMsgID = *msg.ClientMsgID
+	}
+	return payload
+}
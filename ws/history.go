@@ -0,0 +1,19 @@
+package ws
+
+import "errors"
+
+// Defaults for Config.HistoryLimit and Config.MaxHistoryLimit.
+const (
+	DefaultHistoryLimit = 50
+	MaxHistoryLimit     = 100
+)
+
+var ErrInvalidCursor = errors.New("cursor does not reference a message in this room")
+
+// HistoryCursor selects a page of room history. BeforeID pages back towards
+// older messages, AfterID pages forward towards newer ones; with neither set
+// the newest page is returned.
+type HistoryCursor struct {
+	BeforeID *int
+	AfterID  *int
+}
@@ -1,94 +1,187 @@
 package ws
 
 import (
+	"errors"
 	"log/slog"
 	"sync"
 	"time"
 
-	"gorm.io/gorm"
+	"github.com/google/uuid"
+	ws "github.com/gorilla/websocket"
 )
 
 type Manager struct {
 	sync.RWMutex
-	db     *gorm.DB
+	store  Stores
 	logger *slog.Logger
+	config Config
 
 	clients     map[*Client]bool
 	rooms       map[int]map[*Client]bool
-	clientRooms map[*Client]int
+	clientRooms map[*Client]map[int]bool
 	typing      map[int]map[int]time.Time
+	presence    map[int]*presenceState
+	// remoteTyping holds the other nodes' typers by room and node ID.
+	remoteTyping map[int]map[string]remoteTyping
+
+	// threads and clientThreads track thread subscriptions by root
+	// message ID; clientThreads maps each root to its room.
+	threads       map[int]map[*Client]bool
+	clientThreads map[*Client]map[int]int
+
+	draining bool
+	wg       sync.WaitGroup
+
+	limitMu  sync.Mutex
+	limiters map[int]*userLimiter
+
+	nodeID    string
+	broker    Broker
+	seenMu    sync.Mutex
+	seen      map[string]bool
+	seenOrder []string
 }
 
-func NewManager(db *gorm.DB, logger *slog.Logger) *Manager {
-	return &Manager{
-		db:          db,
-		logger:      logger,
-		clients:     make(map[*Client]bool),
-		rooms:       make(map[int]map[*Client]bool),
-		clientRooms: make(map[*Client]int),
-		typing:      make(map[int]map[int]time.Time),
+// NewManager creates a Manager. With a nil broker room events only reach
+// clients connected to this process.
+func NewManager(store Stores, logger *slog.Logger, broker Broker, config Config) *Manager {
+	m := &Manager{
+		store:         store,
+		logger:        logger,
+		config:        config,
+		clients:       make(map[*Client]bool),
+		rooms:         make(map[int]map[*Client]bool),
+		clientRooms:   make(map[*Client]map[int]bool),
+		threads:       make(map[int]map[*Client]bool),
+		clientThreads: make(map[*Client]map[int]int),
+		typing:        make(map[int]map[int]time.Time),
+		presence:      make(map[int]*presenceState),
+		remoteTyping:  make(map[int]map[string]remoteTyping),
+		limiters:      make(map[int]*userLimiter),
+		nodeID:        uuid.New().String(),
+		broker:        broker,
+		seen:          make(map[string]bool),
 	}
+
+	if broker != nil {
+		broker.Subscribe(m.handleBrokerMessage)
+	}
+
+	return m
 }
 
-func (m *Manager) AddClient(c *Client) {
+// AddClient registers a connection. Its ReadMessages and WriteMessages
+// pumps must be started once it returns without error.
+func (m *Manager) AddClient(c *Client) error {
 	m.Lock()
-	defer m.Unlock()
+	if m.draining {
+		m.Unlock()
+		return ErrShuttingDown
+	}
 
 	m.clients[c] = true
+	m.wg.Add(2)
+	connectedClients.Inc()
+	change := m.trackConnect(c)
+	m.Unlock()
+
+	c.logger.Info("client connected")
+
+	m.applyPresence(change)
+	return nil
 }
 
 func (m *Manager) RemoveClient(c *Client) {
+	m.removeClient(c, ws.CloseNormalClosure)
+}
+
+// removeClient unregisters the client and closes its Send channel. The
+// write pump then flushes what is queued, sends a close frame with
+// closeCode and closes the connection.
+func (m *Manager) removeClient(c *Client, closeCode int) {
 	m.Lock()
-	defer m.Unlock()
 
-	if _, ok := m.clients[c]; ok {
-		if roomID, inRoom := m.clientRooms[c]; inRoom {
-			delete(m.rooms[roomID], c)
-			if len(m.rooms[roomID]) == 0 {
-				delete(m.rooms, roomID)
-			}
-			delete(m.clientRooms, c)
+	if _, ok := m.clients[c]; !ok {
+		m.Unlock()
+		return
+	}
+
+	var stoppedTyping []int
+	for roomID := range m.clientRooms[c] {
+		m.leaveRoom(c, roomID)
+		if m.leaveTyping(roomID, c.UserID) {
+			stoppedTyping = append(stoppedTyping, roomID)
 		}
+	}
+	delete(m.clientRooms, c)
+	for rootID := range m.clientThreads[c] {
+		m.leaveThread(c, rootID)
+	}
+	delete(m.clientThreads, c)
+
+	c.closeCode = closeCode
+	c.outMu.Lock()
+	c.overflow = nil
+	c.hasOverflow.Store(false)
+	c.outMu.Unlock()
+	close(c.Send)
+	delete(m.clients, c)
+	connectedClients.Dec()
+	observeDisconnect(closeCode)
+	change := m.trackDisconnect(c)
+	draining := m.draining
+	m.Unlock()
+
+	c.logger.Info("client disconnected", "closeCode", closeCode)
+
+	for _, roomID := range stoppedTyping {
+		m.broadcastTyping(roomID)
+	}
 
-		close(c.Send)
-		c.Conn.Close()
-		delete(m.clients, c)
+	// Everyone is about to reconnect, possibly to another node, so going
+	// offline during a drain would only flicker. The other nodes forget
+	// this one once its heartbeats stop.
+	if !draining {
+		m.applyPresence(change)
 	}
 }
 
-func (m *Manager) JoinRoom(c *Client, roomID int) error {
-	var room Room
-	if err := m.db.First(&room, roomID).Error; err != nil {
-		return err
+// send queues data for a single client without blocking. It reports false
+// if the client is gone or had to be disconnected for falling behind.
+func (m *Manager) send(c *Client, data []byte) bool {
+	msg := m.newOutbound(data)
+
+	m.RLock()
+	if !m.clients[c] {
+		m.RUnlock()
+		return false
 	}
+	slow := m.enqueue(c, msg)
+	m.RUnlock()
 
-	var user User
-	if err := m.db.First(&user, c.UserID).Error; err != nil {
-		if err == gorm.ErrRecordNotFound {
-			user = User{ID: c.UserID}
-			if err := m.db.Create(&user).Error; err != nil {
-				return err
-			}
-		} else {
-			return err
-		}
+	if slow {
+		m.disconnectSlow([]*Client{c})
+		return false
 	}
+	return true
+}
 
-	var count int64
-	err := m.db.Model(&RoomParticipant{}).
-		Where("room_id = ? AND user_id = ?", roomID, c.UserID).
-		Count(&count).Error
+// Subscribe adds the room to the client's subscriptions so it receives the
+// room's events, making the user a member of public group rooms on first use.
+func (m *Manager) Subscribe(c *Client, roomID int) error {
+	participant, err := Authorize(m.store, c.UserID, roomID, ActionJoin)
 	if err != nil {
 		return err
 	}
 
-	if count == 0 {
-		participant := RoomParticipant{
+	if participant == nil {
+		participant = &RoomParticipant{
 			RoomID: roomID,
 			UserID: c.UserID,
-			Role:   "member",
+			Role:   RoleMember,
 		}
-		if err := m.db.Create(&participant).Error; err != nil {
+		// Joining from two tabs at once races to the same membership.
+		if err := m.store.Participants.AddParticipant(participant); err != nil && !errors.Is(err, ErrAlreadyParticipant) {
 			return err
 		}
 	}
@@ -96,60 +189,87 @@ func (m *Manager) JoinRoom(c *Client, roomID int) error {
 	m.Lock()
 	defer m.Unlock()
 
-	if oldRoomID, inRoom := m.clientRooms[c]; inRoom {
-		delete(m.rooms[oldRoomID], c)
-		if len(m.rooms[oldRoomID]) == 0 {
-			delete(m.rooms, oldRoomID)
-		}
+	// The client may have disconnected while we were talking to the database.
+	if !m.clients[c] {
+		return nil
 	}
 
 	if m.rooms[roomID] == nil {
 		m.rooms[roomID] = make(map[*Client]bool)
+		activeRooms.Inc()
 	}
 	m.rooms[roomID][c] = true
-	m.clientRooms[c] = roomID
-	c.RoomID = roomID
+
+	if m.clientRooms[c] == nil {
+		m.clientRooms[c] = make(map[int]bool)
+	}
+	m.clientRooms[c][roomID] = true
 
 	return nil
 }
 
-func (m *Manager) BroadcastToRoom(roomID int, data []byte) {
-	m.RLock()
-	recipients := make([]*Client, 0, len(m.rooms[roomID]))
-	for client := range m.rooms[roomID] {
-		recipients = append(recipients, client)
-	}
-	m.RUnlock()
+func (m *Manager) Unsubscribe(c *Client, roomID int) {
+	m.Lock()
+	m.leaveRoom(c, roomID)
+	delete(m.clientRooms[c], roomID)
+	m.leaveRoomThreads(c, roomID)
+	stopped := m.leaveTyping(roomID, c.UserID)
+	m.Unlock()
 
-	for _, client := range recipients {
-		select {
-		case client.Send <- data:
-		default:
-			m.logger.Warn("client send buffer full, skipping", "clientID", client.ID, "userID", client.UserID)
-		}
+	if stopped {
+		m.broadcastTyping(roomID)
 	}
 }
 
-func (m *Manager) SetTyping(roomID, userID int) {
-	m.Lock()
-	defer m.Unlock()
+func (m *Manager) IsSubscribed(c *Client, roomID int) bool {
+	m.RLock()
+	defer m.RUnlock()
+
+	return m.clientRooms[c][roomID]
+}
 
-	if m.typing[roomID] == nil {
-		m.typing[roomID] = make(map[int]time.Time)
+// leaveRoom removes the client from the room's fan-out set. Callers must
+// hold the lock.
+func (m *Manager) leaveRoom(c *Client, roomID int) {
+	room, ok := m.rooms[roomID]
+	if !ok {
+		return
 	}
-	m.typing[roomID][userID] = time.Now()
+
+	delete(room, c)
+	if len(room) == 0 {
+		delete(m.rooms, roomID)
+		activeRooms.Dec()
+	}
+}
+
+// BroadcastToRoom delivers data to the room's clients on this node and
+// publishes it to the other nodes through the broker.
+func (m *Manager) BroadcastToRoom(roomID int, data []byte) {
+	start := time.Now()
+	m.deliverToRoom(roomID, data)
+	m.publish(BrokerMessage{RoomID: roomID, Data: data})
+	broadcastDuration.Observe(time.Since(start).Seconds())
 }
 
-func (m *Manager) GetTypingUsers(roomID int) []int {
+func (m *Manager) deliverToRoom(roomID int, data []byte) {
+	msg := m.newOutbound(data)
+
+	// Sends never block, and holding the read lock keeps removeClient from
+	// closing a Send channel underneath us.
+	var slow []*Client
 	m.RLock()
-	defer m.RUnlock()
+	broadcastRecipients.Observe(float64(len(m.rooms[roomID])))
+	for client := range m.rooms[roomID] {
+		if client.holdBack(roomID, data) {
+			continue
+		}
 
-	var users []int
-	cutoff := time.Now().Add(-3 * time.Second)
-	for userID, lastTyped := range m.typing[roomID] {
-		if lastTyped.After(cutoff) {
-			users = append(users, userID)
+		if m.enqueue(client, msg) {
+			slow = append(slow, client)
 		}
 	}
-	return users
+	m.RUnlock()
+
+	m.disconnectSlow(slow)
 }
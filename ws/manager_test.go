@@ -0,0 +1,219 @@
+package ws_test
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+	"ws-whatever/internal/broker"
+	"ws-whatever/internal/store"
+	"ws-whatever/ws"
+
+	"github.com/gorilla/websocket"
+)
+
+// eventTimeout bounds how long a test waits for the server to send an
+// event.
+const eventTimeout = 2 * time.Second
+
+type testServer struct {
+	manager *ws.Manager
+	stores  ws.Stores
+	url     string
+}
+
+// newTestServer runs a Manager on in-memory stores behind a websocket
+// endpoint that trusts the user_id query parameter, like dev auth.
+func newTestServer(t *testing.T) *testServer {
+	t.Helper()
+
+	return newNode(t, store.NewMemory().Stores(), nil)
+}
+
+// newTestCluster runs nodes that share their stores and a memory broker,
+// like servers sharing a database.
+func newTestCluster(t *testing.T, nodes int) []*testServer {
+	t.Helper()
+
+	stores := store.NewMemory().Stores()
+	b := broker.NewMemory()
+	cluster := make([]*testServer, nodes)
+	for i := range cluster {
+		cluster[i] = newNode(t, stores, b)
+	}
+	return cluster
+}
+
+func newNode(t *testing.T, stores ws.Stores, b ws.Broker) *testServer {
+	t.Helper()
+
+	logger := slog.New(slog.DiscardHandler)
+	m := ws.NewManager(stores, logger, b, ws.DefaultConfig())
+
+	var upgrader websocket.Upgrader
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userID, err := strconv.Atoi(r.URL.Query().Get("user_id"))
+		if err != nil {
+			http.Error(w, "invalid user_id", http.StatusBadRequest)
+			return
+		}
+
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+
+		client := ws.NewClient(conn, m, userID, logger)
+		if err := m.AddClient(client); err != nil {
+			conn.Close()
+			return
+		}
+		go client.ReadMessages()
+		go client.WriteMessages()
+	}))
+	t.Cleanup(srv.Close)
+
+	return &testServer{manager: m, stores: stores, url: "ws" + strings.TrimPrefix(srv.URL, "http")}
+}
+
+// createRoom creates a group room with the given participants, the first
+// one as its owner.
+func (s *testServer) createRoom(t *testing.T, private bool, userIDs ...int) int {
+	t.Helper()
+
+	room := ws.Room{Name: "room", CommunityID: 1, Type: ws.RoomTypeGroup, IsPrivate: private}
+	participants := make([]ws.RoomParticipant, len(userIDs))
+	for i, userID := range userIDs {
+		participants[i] = ws.RoomParticipant{UserID: userID, Role: ws.RoleMember}
+	}
+	if len(participants) > 0 {
+		participants[0].Role = ws.RoleOwner
+	}
+
+	if err := s.stores.Rooms.CreateRoom(&room, participants); err != nil {
+		t.Fatalf("create room: %v", err)
+	}
+	return room.ID
+}
+
+// createMessage stores a message directly, created offset after a fixed
+// time so history order does not depend on the clock.
+func (s *testServer) createMessage(t *testing.T, roomID, senderID int, offset time.Duration, replyToID *int) ws.Message {
+	t.Helper()
+
+	message := ws.Message{
+		RoomID:    roomID,
+		SenderID:  senderID,
+		Content:   "message",
+		ReplyToID: replyToID,
+		CreatedAt: time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC).Add(offset),
+	}
+	if _, err := s.stores.Messages.CreateMessage(&message, nil); err != nil {
+		t.Fatalf("create message: %v", err)
+	}
+	return message
+}
+
+type received struct {
+	Type    string          `json:"type"`
+	Seq     int64           `json:"seq"`
+	Payload json.RawMessage `json:"payload"`
+}
+
+type testClient struct {
+	conn   *websocket.Conn
+	events chan received
+	// skipped holds events passed over by expect, in arrival order.
+	skipped []received
+}
+
+// dial connects as userID. Events are read in the background so the
+// connection never hits a read deadline between expectations.
+func (s *testServer) dial(t *testing.T, userID int) *testClient {
+	t.Helper()
+
+	conn, _, err := websocket.DefaultDialer.Dial(s.url+"?user_id="+strconv.Itoa(userID), nil)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	c := &testClient{conn: conn, events: make(chan received, 64)}
+	go func() {
+		defer close(c.events)
+		for {
+			var event received
+			if err := conn.ReadJSON(&event); err != nil {
+				return
+			}
+			c.events <- event
+		}
+	}()
+	return c
+}
+
+func (c *testClient) send(t *testing.T, eventType string, payload any) {
+	t.Helper()
+
+	if err := c.conn.WriteJSON(ws.Event{Type: eventType, Payload: payload}); err != nil {
+		t.Fatalf("send %s: %v", eventType, err)
+	}
+}
+
+// expect returns the first event of eventType, earlier skipped ones
+// included, and decodes its payload into payload, if given. Other events
+// are kept for later expectations, so the server's ordering between
+// different event types does not matter.
+func (c *testClient) expect(t *testing.T, eventType string, payload any) received {
+	t.Helper()
+
+	event, found := c.takeSkipped(eventType)
+	timeout := time.After(eventTimeout)
+	for !found {
+		select {
+		case e, ok := <-c.events:
+			if !ok {
+				t.Fatalf("connection closed waiting for %s", eventType)
+			}
+			if e.Type != eventType {
+				c.skipped = append(c.skipped, e)
+				continue
+			}
+			event, found = e, true
+		case <-timeout:
+			t.Fatalf("timed out waiting for %s", eventType)
+		}
+	}
+
+	if payload != nil {
+		if err := json.Unmarshal(event.Payload, payload); err != nil {
+			t.Fatalf("decode %s: %v", eventType, err)
+		}
+	}
+	return event
+}
+
+func (c *testClient) takeSkipped(eventType string) (received, bool) {
+	for i, event := range c.skipped {
+		if event.Type == eventType {
+			c.skipped = slices.Delete(c.skipped, i, i+1)
+			return event, true
+		}
+	}
+	return received{}, false
+}
+
+// subscribe subscribes to the room and returns its last sequence number.
+func (c *testClient) subscribe(t *testing.T, roomID int) int64 {
+	t.Helper()
+
+	c.send(t, "subscribe", ws.SubscribePayload{RoomID: roomID})
+	var subscribed ws.SubscribedPayload
+	c.expect(t, "subscribed", &subscribed)
+	return subscribed.Seq
+}
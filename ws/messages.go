@@ -0,0 +1,111 @@
+package ws
+
+import (
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+// maxClientMsgIDLength matches the messages.client_msg_id column.
+const maxClientMsgIDLength = 64
+
+var (
+	ErrEmptyContent       = errors.New("message content cannot be empty")
+	ErrMessageNotFound    = errors.New("message not found")
+	ErrNotMessageSender   = errors.New("can only edit or delete your own messages")
+	ErrInvalidClientMsgID = errors.New("invalid client_msg_id")
+)
+
+// EditMessage replaces the content of a message, keeping the previous
+// version in message_revisions, and notifies the room.
+func (m *Manager) EditMessage(messageID, userID int, content string) (*Message, error) {
+	if content == "" {
+		return nil, ErrEmptyContent
+	}
+
+	message, err := m.store.Messages.GetMessage(messageID)
+	if err != nil {
+		return nil, err
+	}
+
+	if message.SenderID != userID {
+		return nil, ErrNotMessageSender
+	}
+
+	if _, err := Authorize(m.store, userID, message.RoomID, ActionPost); err != nil {
+		return nil, err
+	}
+
+	if err := m.store.Messages.ReviseMessage(message, userID, content, time.Now()); err != nil {
+		return nil, err
+	}
+
+	err = m.BroadcastRoomEvent(message.RoomID, Event{
+		Type: "message_edited",
+		Payload: MessageEditedPayload{
+			ID:        message.ID,
+			RoomID:    message.RoomID,
+			SenderID:  message.SenderID,
+			Content:   message.Content,
+			UpdatedAt: message.UpdatedAt,
+		},
+	})
+	if err != nil {
+		m.logger.Error("failed to broadcast edit", "error", err, "messageID", message.ID)
+	}
+
+	return message, nil
+}
+
+// DeleteMessage soft-deletes a message sent by userID, who must still be
+// able to post in its room, and notifies the room.
+func (m *Manager) DeleteMessage(messageID, userID int) error {
+	message, err := m.store.Messages.GetMessage(messageID)
+	if err != nil {
+		return err
+	}
+
+	if message.SenderID != userID {
+		return ErrNotMessageSender
+	}
+
+	if _, err := Authorize(m.store, userID, message.RoomID, ActionPost); err != nil {
+		return err
+	}
+
+	now := time.Now()
+	if err := m.store.Messages.DeleteMessage(message.ID, now); err != nil {
+		return err
+	}
+
+	err = m.BroadcastRoomEvent(message.RoomID, Event{
+		Type: "message_deleted",
+		Payload: MessageDeletedPayload{
+			ID:        message.ID,
+			RoomID:    message.RoomID,
+			DeletedAt: now,
+		},
+	})
+	if err != nil {
+		m.logger.Error("failed to broadcast delete", "error", err, "messageID", message.ID)
+	}
+
+	if message.ReplyToID != nil {
+		if err := m.broadcastThreadUpdated(message.RoomID, *message.ReplyToID); err != nil {
+			m.logger.Error("failed to broadcast thread update", "error", err, "messageID", message.ID)
+		}
+	}
+
+	return nil
+}
+
+// BroadcastEvent encodes the event and sends it to everyone in the room.
+func (m *Manager) BroadcastEvent(roomID int, event Event) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	m.BroadcastToRoom(roomID, data)
+	return nil
+}
@@ -0,0 +1,115 @@
+package ws_test
+
+import (
+	"errors"
+	"testing"
+	"ws-whatever/ws"
+)
+
+func TestEditMessage(t *testing.T) {
+	tests := []struct {
+		name    string
+		userID  int
+		content string
+		wantErr error
+	}{
+		{name: "sender edits", userID: 1, content: "edited"},
+		{name: "someone else", userID: 2, content: "edited", wantErr: ws.ErrNotMessageSender},
+		{name: "empty content", userID: 1, wantErr: ws.ErrEmptyContent},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := newTestServer(t)
+			roomID := srv.createRoom(t, false, 1, 2)
+			message := srv.createMessage(t, roomID, 1, 0, nil)
+
+			c := srv.dial(t, 2)
+			c.subscribe(t, roomID)
+
+			_, err := srv.manager.EditMessage(message.ID, tt.userID, tt.content)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("EditMessage() error = %v, want %v", err, tt.wantErr)
+			}
+
+			stored, err := srv.stores.Messages.GetMessage(message.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if tt.wantErr != nil {
+				if stored.Content != message.Content || stored.IsEdited {
+					t.Fatalf("rejected edit changed the message to %q", stored.Content)
+				}
+				return
+			}
+
+			var edited ws.MessageEditedPayload
+			c.expect(t, "message_edited", &edited)
+			if edited.ID != message.ID || edited.Content != tt.content {
+				t.Fatalf("message_edited = %+v, want %q", edited, tt.content)
+			}
+			if stored.Content != tt.content || !stored.IsEdited {
+				t.Fatalf("stored content = %q, want edited %q", stored.Content, tt.content)
+			}
+
+			revisions, err := srv.stores.Messages.Revisions(message.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(revisions) != 1 || revisions[0].Content != message.Content {
+				t.Fatalf("revisions = %+v, want the original content", revisions)
+			}
+		})
+	}
+}
+
+func TestDeleteMessage(t *testing.T) {
+	tests := []struct {
+		name     string
+		senderID int
+		userID   int
+		wantErr  error
+	}{
+		{name: "sender deletes", senderID: 1, userID: 1},
+		{name: "someone else", senderID: 1, userID: 2, wantErr: ws.ErrNotMessageSender},
+		{name: "sender left the room", senderID: 3, userID: 3, wantErr: ws.ErrNotParticipant},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := newTestServer(t)
+			roomID := srv.createRoom(t, false, 1, 2)
+			message := srv.createMessage(t, roomID, tt.senderID, 0, nil)
+
+			c := srv.dial(t, 2)
+			c.subscribe(t, roomID)
+
+			err := srv.manager.DeleteMessage(message.ID, tt.userID)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("DeleteMessage() error = %v, want %v", err, tt.wantErr)
+			}
+
+			messages, _, err := srv.stores.Messages.History(roomID, ws.HistoryCursor{}, 10)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if tt.wantErr != nil {
+				if len(messages) != 1 {
+					t.Fatalf("history has %d messages after a rejected delete, want 1", len(messages))
+				}
+				return
+			}
+
+			var deleted ws.MessageDeletedPayload
+			c.expect(t, "message_deleted", &deleted)
+			if deleted.ID != message.ID {
+				t.Fatalf("message_deleted for %d, want %d", deleted.ID, message.ID)
+			}
+			if len(messages) != 0 {
+				t.Fatalf("history still has %d messages", len(messages))
+			}
+		})
+	}
+}
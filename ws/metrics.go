@@ -0,0 +1,84 @@
+package ws
+
+import (
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	connectedClients = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "ws_connected_clients",
+		Help: "Websocket clients currently connected to this node.",
+	})
+
+	activeRooms = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "ws_active_rooms",
+		Help: "Rooms with at least one subscriber on this node.",
+	})
+
+	disconnectsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "ws_disconnects_total",
+		Help: "Websocket clients removed, by close code.",
+	}, []string{"code"})
+
+	broadcastDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "ws_broadcast_duration_seconds",
+		Help:    "Time to deliver a room event locally and publish it to the broker.",
+		Buckets: prometheus.ExponentialBuckets(0.0001, 4, 8),
+	})
+
+	broadcastRecipients = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "ws_broadcast_recipients",
+		Help:    "Local subscribers a room event was delivered to.",
+		Buckets: prometheus.ExponentialBuckets(1, 4, 7),
+	})
+
+	// slowConsumerTotal counts how often each slow consumer path fires:
+	// "queued", "coalesced" and "disconnected".
+	slowConsumerTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "ws_slow_consumer_total",
+		Help: "Events for clients with a full send buffer, by what was done with them.",
+	}, []string{"action"})
+
+	eventsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "ws_events_total",
+		Help: "Client events handled, by type and result.",
+	}, []string{"type", "result"})
+
+	eventDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "ws_event_duration_seconds",
+		Help:    "Time to handle a client event, by type.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"type"})
+)
+
+// eventLabel keeps arbitrary client input out of metric labels.
+func eventLabel(eventType string) string {
+	if _, ok := eventHandlers[eventType]; ok {
+		return eventType
+	}
+	return "unknown"
+}
+
+func observeEvent(eventType string, start time.Time, err error) {
+	label := eventLabel(eventType)
+	eventDuration.WithLabelValues(label).Observe(time.Since(start).Seconds())
+
+	result := "ok"
+	var rateErr *RateLimitError
+	switch {
+	case errors.As(err, &rateErr):
+		result = "rate_limited"
+	case err != nil:
+		result = "error"
+	}
+	eventsTotal.WithLabelValues(label, result).Inc()
+}
+
+func observeDisconnect(closeCode int) {
+	disconnectsTotal.WithLabelValues(strconv.Itoa(closeCode)).Inc()
+}
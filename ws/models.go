@@ -1,6 +1,11 @@
 package ws
 
-import "time"
+import (
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/schema"
+)
 
 type RoomType string
 
@@ -9,15 +14,35 @@ const (
 	RoomTypeDirect RoomType = "direct"
 )
 
+// GormDBDataType stores room types in the room_type enum on Postgres and as
+// plain text on databases without enums.
+func (RoomType) GormDBDataType(db *gorm.DB, field *schema.Field) string {
+	if db.Dialector.Name() == "postgres" {
+		return "room_type"
+	}
+	return "text"
+}
+
+const (
+	RoleOwner  = "owner"
+	RoleAdmin  = "admin"
+	RoleMember = "member"
+)
+
 type Message struct {
-	ID        int       `gorm:"primaryKey"`
-	RoomID    int       `gorm:"not null;index:idx_messages_room_created_at"`
-	SenderID  int       `gorm:"not null"`
-	Content   string    `gorm:"type:text;not null"`
-	ReplyToID *int      `gorm:"index:idx_messages_reply_to_id"`
-	IsPinned  bool      `gorm:"default:false;index:idx_messages_room_pinned"`
-	IsEdited  bool      `gorm:"default:false"`
-	CreatedAt time.Time `gorm:"default:now();index:idx_messages_room_created_at"`
+	ID        int    `gorm:"primaryKey"`
+	RoomID    int    `gorm:"not null;index:idx_messages_room_created_at;index:idx_messages_room_pinned"`
+	SenderID  int    `gorm:"not null;uniqueIndex:idx_messages_sender_client_msg_id"`
+	Content   string `gorm:"type:text;not null"`
+	ReplyToID *int   `gorm:"index:idx_messages_reply_to_id"`
+	// ClientMsgID is the sender's own ID for the message, so retried sends
+	// are stored once.
+	ClientMsgID *string `gorm:"type:varchar(64);uniqueIndex:idx_messages_sender_client_msg_id"`
+	IsPinned    bool    `gorm:"default:false;index:idx_messages_room_pinned"`
+	IsEdited    bool    `gorm:"default:false"`
+	// CreatedAt is set by GORM rather than a column default, so history
+	// cursors compare timestamps written the same way on every database.
+	CreatedAt time.Time `gorm:"index:idx_messages_room_created_at"`
 	UpdatedAt *time.Time
 	DeletedAt *time.Time
 	Room      Room     `gorm:"foreignKey:RoomID"`
@@ -25,32 +50,58 @@ type Message struct {
 	ReplyTo   *Message `gorm:"foreignKey:ReplyToID"`
 }
 
-type MessageAttachment struct {
+type MessageRevision struct {
 	ID        int    `gorm:"primaryKey"`
-	MessageID int    `gorm:"not null"`
-	FilePath  string `gorm:"type:text;not null"`
-	FileType  string `gorm:"type:text;not null"`
-	FileSize  int
-	FileMime  string    `gorm:"type:text;not null"`
-	CreatedAt time.Time `gorm:"default:now()"`
-	Message   Message   `gorm:"foreignKey:MessageID"`
+	MessageID int    `gorm:"not null;index:idx_message_revisions_message"`
+	Content   string `gorm:"type:text;not null"`
+	EditedBy  int    `gorm:"not null"`
+	CreatedAt time.Time
+	Message   Message `gorm:"foreignKey:MessageID"`
+}
+
+type MessageAttachment struct {
+	ID         int    `gorm:"primaryKey"`
+	MessageID  *int   `gorm:"index:idx_message_attachments_message"`
+	RoomID     int    `gorm:"not null;index:idx_message_attachments_room"`
+	UploaderID int    `gorm:"not null"`
+	FileName   string `gorm:"type:text;not null"`
+	FilePath   string `gorm:"type:text;not null"`
+	FileType   string `gorm:"type:text;not null"`
+	FileSize   int
+	FileMime   string `gorm:"type:text;not null"`
+	CreatedAt  time.Time
+	Message    *Message `gorm:"foreignKey:MessageID"`
 }
 
 type Room struct {
-	ID          int       `gorm:"primaryKey"`
-	Name        string    `gorm:"type:text"`
-	CommunityID int       `gorm:"not null;index:idx_rooms_event"`
-	Type        RoomType  `gorm:"type:room_type;not null"`
-	CreatedAt   time.Time `gorm:"default:now()"`
+	ID          int      `gorm:"primaryKey"`
+	Name        string   `gorm:"type:text"`
+	CommunityID int      `gorm:"not null;index:idx_rooms_community"`
+	Type        RoomType `gorm:"not null"`
+	IsPrivate   bool     `gorm:"default:false"`
+	LastSeq     int64    `gorm:"not null;default:0"`
+	CreatedAt   time.Time
 	Community   Community `gorm:"foreignKey:CommunityID"`
 }
 
+// RoomEvent keeps a sequenced room event so reconnecting clients can
+// replay what they missed.
+type RoomEvent struct {
+	ID        int       `gorm:"primaryKey"`
+	RoomID    int       `gorm:"not null;uniqueIndex:idx_room_events_room_seq"`
+	Seq       int64     `gorm:"not null;uniqueIndex:idx_room_events_room_seq"`
+	Type      string    `gorm:"type:varchar(50);not null"`
+	Data      string    `gorm:"type:text;not null"`
+	CreatedAt time.Time `gorm:"index:idx_room_events_created_at"`
+	Room      Room      `gorm:"foreignKey:RoomID"`
+}
+
 type RoomParticipant struct {
 	ID       int       `gorm:"primaryKey"`
 	RoomID   int       `gorm:"not null;uniqueIndex:idx_room_participants_room_user;index:idx_room_participants_room"`
 	UserID   int       `gorm:"not null;uniqueIndex:idx_room_participants_room_user;index:idx_room_participants_user"`
 	Role     string    `gorm:"type:varchar(50);not null"`
-	JoinedAt time.Time `gorm:"default:now()"`
+	JoinedAt time.Time `gorm:"default:CURRENT_TIMESTAMP"`
 	Room     Room      `gorm:"foreignKey:RoomID"`
 	User     User      `gorm:"foreignKey:UserID"`
 }
@@ -65,13 +116,13 @@ type MessageRead struct {
 }
 
 type MessageReaction struct {
-	ID           int       `gorm:"primaryKey"`
-	MessageID    int       `gorm:"not null;index:idx_message_reactions_message;uniqueIndex:uniq_message_reactions_user_type"`
-	UserID       int       `gorm:"not null;uniqueIndex:idx_message_reactions_message_user;uniqueIndex:uniq_message_reactions_user_type"`
-	ReactionType string    `gorm:"type:varchar(50);not null;uniqueIndex:uniq_message_reactions_user_type"`
-	CreatedAt    time.Time `gorm:"default:now()"`
-	Message      Message   `gorm:"foreignKey:MessageID"`
-	User         User      `gorm:"foreignKey:UserID"`
+	ID           int    `gorm:"primaryKey"`
+	MessageID    int    `gorm:"not null;index:idx_message_reactions_message;index:idx_message_reactions_message_user;uniqueIndex:uniq_message_reactions_user_type"`
+	UserID       int    `gorm:"not null;index:idx_message_reactions_message_user;uniqueIndex:uniq_message_reactions_user_type"`
+	ReactionType string `gorm:"type:varchar(50);not null;uniqueIndex:uniq_message_reactions_user_type"`
+	CreatedAt    time.Time
+	Message      Message `gorm:"foreignKey:MessageID"`
+	User         User    `gorm:"foreignKey:UserID"`
 }
 
 type Community struct {
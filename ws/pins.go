@@ -0,0 +1,45 @@
+package ws
+
+import "errors"
+
+var ErrNotRoomAdmin = errors.New("requires the room admin or owner role")
+
+func (m *Manager) PinMessage(messageID, userID int) error {
+	return m.setPinned(messageID, userID, true)
+}
+
+func (m *Manager) UnpinMessage(messageID, userID int) error {
+	return m.setPinned(messageID, userID, false)
+}
+
+func (m *Manager) setPinned(messageID, userID int, pinned bool) error {
+	message, participant, err := AuthorizeMessage(m.store, userID, messageID, ActionRead)
+	if err != nil {
+		return err
+	}
+	if !isRoomAdmin(participant.Role) {
+		return ErrNotRoomAdmin
+	}
+
+	if message.IsPinned == pinned {
+		return nil
+	}
+
+	if err := m.store.Messages.SetPinned(message.ID, pinned); err != nil {
+		return err
+	}
+
+	eventType := "message_unpinned"
+	if pinned {
+		eventType = "message_pinned"
+	}
+
+	return m.BroadcastRoomEvent(message.RoomID, Event{
+		Type: eventType,
+		Payload: MessagePinnedPayload{
+			MessageID: message.ID,
+			RoomID:    message.RoomID,
+			UserID:    userID,
+		},
+	})
+}
@@ -0,0 +1,329 @@
+package ws
+
+import (
+	"context"
+	"time"
+)
+
+type PresenceStatus string
+
+const (
+	PresenceOnline  PresenceStatus = "online"
+	PresenceAway    PresenceStatus = "away"
+	PresenceOffline PresenceStatus = "offline"
+)
+
+const (
+	// awayAfter is how long all of a user's connections must be idle before
+	// they are shown as away.
+	awayAfter = 5 * time.Minute
+
+	sweepInterval = 30 * time.Second
+)
+
+// remotePresenceTTL is how long another node's report is trusted without a
+// heartbeat, so users on a node that died eventually go offline.
+const remotePresenceTTL = 3 * sweepInterval
+
+// presenceRetention is how long an offline user's last activity is kept
+// for presence lookups before the user is forgotten.
+const presenceRetention = 24 * time.Hour
+
+// NodePresence is one node's view of a user, shared through the broker so
+// every node can combine them.
+type NodePresence struct {
+	UserID       int            `json:"user_id"`
+	Status       PresenceStatus `json:"status"`
+	LastActiveAt time.Time      `json:"last_active_at"`
+	// Announced is the combined status the sending node announced with
+	// this change, if any.
+	Announced PresenceStatus `json:"announced,omitempty"`
+}
+
+// presenceState tracks a user's connections to this node and what the
+// other nodes last reported. A user's status is the most present of them.
+type presenceState struct {
+	clients    map[*Client]time.Time
+	status     PresenceStatus
+	lastActive time.Time
+	remote     map[string]remotePresence
+	// shown is the combined status last computed, which is what rooms
+	// were told.
+	shown PresenceStatus
+}
+
+type remotePresence struct {
+	status     PresenceStatus
+	lastActive time.Time
+	seenAt     time.Time
+}
+
+// presenceChange is what has to happen once the lock is released after a
+// user's state was updated.
+type presenceChange struct {
+	userID int
+	// local is this node's new status for the other nodes, if it changed.
+	local *NodePresence
+	// announce is set when the combined status changed.
+	announce bool
+}
+
+var presenceRank = map[PresenceStatus]int{
+	PresenceOffline: 0,
+	PresenceAway:    1,
+	PresenceOnline:  2,
+}
+
+// Run performs periodic housekeeping until ctx is cancelled.
+func (m *Manager) Run(ctx context.Context) {
+	presenceTicker := time.NewTicker(sweepInterval)
+	defer presenceTicker.Stop()
+
+	typingTicker := time.NewTicker(typingSweepInterval)
+	defer typingTicker.Stop()
+
+	pruneTicker := time.NewTicker(pruneInterval)
+	defer pruneTicker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-presenceTicker.C:
+			m.sweepPresence()
+			m.sweepLimiters()
+		case <-typingTicker.C:
+			m.sweepTyping()
+		case <-pruneTicker.C:
+			m.pruneEvents()
+		}
+	}
+}
+
+// Touch records activity on a connection, bringing an away user back online.
+func (m *Manager) Touch(c *Client) {
+	m.Lock()
+	state := m.presence[c.UserID]
+	if state == nil || state.clients[c].IsZero() {
+		m.Unlock()
+		return
+	}
+
+	now := time.Now()
+	state.clients[c] = now
+	state.lastActive = now
+	change := m.updatePresence(c.UserID, state)
+	m.Unlock()
+
+	m.applyPresence(change)
+}
+
+// UserPresence returns the user's status across all nodes and when they
+// were last active.
+func (m *Manager) UserPresence(userID int) (PresenceStatus, time.Time) {
+	m.RLock()
+	defer m.RUnlock()
+
+	state := m.presence[userID]
+	if state == nil {
+		return PresenceOffline, time.Time{}
+	}
+	return state.combined()
+}
+
+// combined merges this node's view of the user with the other nodes'.
+func (s *presenceState) combined() (PresenceStatus, time.Time) {
+	status, lastActive := s.status, s.lastActive
+	for _, r := range s.remote {
+		if presenceRank[r.status] > presenceRank[status] {
+			status = r.status
+		}
+		if r.lastActive.After(lastActive) {
+			lastActive = r.lastActive
+		}
+	}
+	return status, lastActive
+}
+
+// userPresenceState returns the user's state, creating it if needed.
+// Callers must hold the lock.
+func (m *Manager) userPresenceState(userID int) *presenceState {
+	state := m.presence[userID]
+	if state == nil {
+		state = &presenceState{status: PresenceOffline, shown: PresenceOffline}
+		m.presence[userID] = state
+	}
+	return state
+}
+
+// trackConnect and trackDisconnect must be called with the lock held.
+func (m *Manager) trackConnect(c *Client) presenceChange {
+	state := m.userPresenceState(c.UserID)
+
+	now := time.Now()
+	if state.clients == nil {
+		state.clients = make(map[*Client]time.Time)
+	}
+	state.clients[c] = now
+	state.lastActive = now
+	return m.updatePresence(c.UserID, state)
+}
+
+func (m *Manager) trackDisconnect(c *Client) presenceChange {
+	state := m.presence[c.UserID]
+	if state == nil {
+		return presenceChange{userID: c.UserID}
+	}
+
+	delete(state.clients, c)
+	return m.updatePresence(c.UserID, state)
+}
+
+// sweepPresence marks idle users away, forgets reports from nodes that
+// stopped sending heartbeats and users offline past presenceRetention, and
+// sends this node's heartbeat.
+func (m *Manager) sweepPresence() {
+	var changes []presenceChange
+	var heartbeat []NodePresence
+
+	m.Lock()
+	now := time.Now()
+	for userID, state := range m.presence {
+		for nodeID, r := range state.remote {
+			if now.Sub(r.seenAt) > remotePresenceTTL {
+				delete(state.remote, nodeID)
+				if r.lastActive.After(state.lastActive) {
+					state.lastActive = r.lastActive
+				}
+			}
+		}
+
+		// The heartbeat carries every local user, changed or not.
+		change := m.updatePresence(userID, state)
+		change.local = nil
+		if change.announce {
+			changes = append(changes, change)
+		}
+		if len(state.clients) > 0 {
+			heartbeat = append(heartbeat, NodePresence{UserID: userID, Status: state.status, LastActiveAt: state.lastActive})
+		} else if !change.announce && len(state.remote) == 0 && state.shown == PresenceOffline && now.Sub(state.lastActive) > presenceRetention {
+			delete(m.presence, userID)
+		}
+	}
+	m.Unlock()
+
+	for _, change := range changes {
+		m.applyPresence(change)
+	}
+	if len(heartbeat) > 0 {
+		m.publish(BrokerMessage{Presence: heartbeat})
+	}
+}
+
+// updatePresence recomputes the status from the user's connections on this
+// node and combines it with the other nodes'. Callers must hold the lock
+// and pass the result to applyPresence once they release it.
+func (m *Manager) updatePresence(userID int, state *presenceState) presenceChange {
+	status := PresenceOffline
+	for _, lastActive := range state.clients {
+		if time.Since(lastActive) < awayAfter {
+			status = PresenceOnline
+			break
+		}
+		status = PresenceAway
+	}
+
+	change := presenceChange{userID: userID}
+	localChanged := status != state.status
+	state.status = status
+
+	shown, _ := state.combined()
+	change.announce = shown != state.shown
+	state.shown = shown
+
+	if localChanged {
+		change.local = &NodePresence{UserID: userID, Status: status, LastActiveAt: state.lastActive}
+		if change.announce {
+			change.local.Announced = shown
+		}
+	}
+	return change
+}
+
+// applyPresence tells the other nodes about a local change and the rooms
+// about a change in the combined status.
+func (m *Manager) applyPresence(change presenceChange) {
+	if change.local != nil {
+		m.publish(BrokerMessage{Presence: []NodePresence{*change.local}})
+	}
+	if change.announce {
+		m.announcePresence(change.userID)
+	}
+}
+
+// handleRemotePresence records another node's view of its users. The
+// sending node announces changes it sees itself; this node only announces
+// when its combined status differs from what was announced, which happens
+// when both nodes lost a user's last connection at the same time or only
+// this node knows about a third one.
+func (m *Manager) handleRemotePresence(nodeID string, reports []NodePresence) {
+	var announce []int
+
+	m.Lock()
+	now := time.Now()
+	for _, p := range reports {
+		state := m.userPresenceState(p.UserID)
+		if p.Status == PresenceOffline {
+			// Keep when they were last seen there for the offline status.
+			delete(state.remote, nodeID)
+			if p.LastActiveAt.After(state.lastActive) {
+				state.lastActive = p.LastActiveAt
+			}
+		} else {
+			if state.remote == nil {
+				state.remote = make(map[string]remotePresence)
+			}
+			state.remote[nodeID] = remotePresence{status: p.Status, lastActive: p.LastActiveAt, seenAt: now}
+		}
+
+		shown, _ := state.combined()
+		if shown != state.shown {
+			state.shown = shown
+			if shown != p.Announced {
+				announce = append(announce, p.UserID)
+			}
+		}
+	}
+	m.Unlock()
+
+	for _, userID := range announce {
+		m.announcePresence(userID)
+	}
+}
+
+// announcePresence sends the user's current presence to every room they
+// belong to.
+func (m *Manager) announcePresence(userID int) {
+	roomIDs, err := m.store.Participants.RoomIDs(userID)
+	if err != nil {
+		m.logger.Error("failed to load rooms for presence", "error", err, "userID", userID)
+		return
+	}
+
+	status, lastActive := m.UserPresence(userID)
+	event := Event{
+		Type: "presence",
+		Payload: PresencePayload{
+			UserID:       userID,
+			Status:       status,
+			LastActiveAt: lastActive,
+		},
+	}
+
+	for _, roomID := range roomIDs {
+		if err := m.BroadcastEvent(roomID, event); err != nil {
+			m.logger.Error("failed to broadcast presence", "error", err, "userID", userID)
+			return
+		}
+	}
+}
@@ -0,0 +1,60 @@
+package ws
+
+import (
+	"log/slog"
+	"testing"
+	"time"
+)
+
+func TestSweepPresenceForgetsOfflineUsers(t *testing.T) {
+	now := time.Now()
+
+	tests := []struct {
+		name     string
+		state    presenceState
+		wantKept bool
+	}{
+		{
+			name:  "offline past retention",
+			state: presenceState{status: PresenceOffline, shown: PresenceOffline, lastActive: now.Add(-presenceRetention - time.Minute)},
+		},
+		{
+			name:     "recently offline",
+			state:    presenceState{status: PresenceOffline, shown: PresenceOffline, lastActive: now.Add(-time.Hour)},
+			wantKept: true,
+		},
+		{
+			name: "connected here",
+			state: presenceState{
+				clients:    map[*Client]time.Time{{}: now},
+				status:     PresenceOnline,
+				shown:      PresenceOnline,
+				lastActive: now.Add(-presenceRetention - time.Minute),
+			},
+			wantKept: true,
+		},
+		{
+			name: "connected to another node",
+			state: presenceState{
+				status:     PresenceOffline,
+				shown:      PresenceOnline,
+				lastActive: now.Add(-presenceRetention - time.Minute),
+				remote:     map[string]remotePresence{"node": {status: PresenceOnline, lastActive: now, seenAt: now}},
+			},
+			wantKept: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := NewManager(Stores{}, slog.New(slog.DiscardHandler), nil, DefaultConfig())
+			m.presence[1] = &tt.state
+
+			m.sweepPresence()
+
+			if _, kept := m.presence[1]; kept != tt.wantKept {
+				t.Fatalf("kept = %v, want %v", kept, tt.wantKept)
+			}
+		})
+	}
+}
@@ -0,0 +1,176 @@
+package ws
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// defaultLimitKey is the limit for event types without their own.
+	defaultLimitKey = "*"
+
+	// A connection that keeps sending after abuseThreshold rejections
+	// within abuseWindow is closed with a policy violation.
+	abuseThreshold = 20
+	abuseWindow    = 10 * time.Second
+
+	limiterIdleTimeout = time.Minute
+)
+
+// RateLimit is a token bucket: Rate events per second on average, with
+// bursts of up to Burst events.
+type RateLimit struct {
+	Rate  float64 `yaml:"rate"`
+	Burst int     `yaml:"burst"`
+}
+
+// DefaultRateLimits are applied per user across all of their connections.
+// Subscriptions get a large burst because clients resubscribe to every room
+// on reconnect.
+var DefaultRateLimits = map[string]RateLimit{
+	"send_message":    {Rate: 2, Burst: 10},
+	"edit_message":    {Rate: 1, Burst: 5},
+	"typing":          {Rate: 2, Burst: 4},
+	"typing_stop":     {Rate: 2, Burst: 4},
+	"add_reaction":    {Rate: 5, Burst: 10},
+	"remove_reaction": {Rate: 5, Burst: 10},
+	"mark_read":       {Rate: 5, Burst: 20},
+	"load_history":    {Rate: 2, Burst: 5},
+	"join_room":       {Rate: 5, Burst: 10},
+	"subscribe":       {Rate: 20, Burst: 200},
+	"unsubscribe":     {Rate: 20, Burst: 200},
+	"resume":          {Rate: 1, Burst: 3},
+	defaultLimitKey:   {Rate: 10, Burst: 20},
+}
+
+var errRateLimitAbuse = errors.New("too many rate limited events")
+
+type RateLimitError struct {
+	EventType  string
+	RetryAfter time.Duration
+}
+
+func (e *RateLimitError) Error() string {
+	return fmt.Sprintf("rate limit exceeded for %s, retry after %s", e.EventType, e.RetryAfter.Round(time.Millisecond))
+}
+
+// ParseRateLimits reads overrides of the form
+// "send_message=2:10,typing=1:3", where each value is rate:burst, and
+// returns them merged over base.
+func ParseRateLimits(base map[string]RateLimit, spec string) (map[string]RateLimit, error) {
+	limits := maps.Clone(base)
+	if limits == nil {
+		limits = make(map[string]RateLimit)
+	}
+
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		eventType, value, ok := strings.Cut(entry, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid rate limit %q, expected event=rate:burst", entry)
+		}
+		rate, burst, ok := strings.Cut(value, ":")
+		if !ok {
+			return nil, fmt.Errorf("invalid rate limit %q, expected event=rate:burst", entry)
+		}
+
+		r, err := strconv.ParseFloat(rate, 64)
+		if err != nil || r <= 0 {
+			return nil, fmt.Errorf("invalid rate in %q", entry)
+		}
+		b, err := strconv.Atoi(burst)
+		if err != nil || b < 1 {
+			return nil, fmt.Errorf("invalid burst in %q", entry)
+		}
+
+		limits[strings.TrimSpace(eventType)] = RateLimit{Rate: r, Burst: b}
+	}
+
+	return limits, nil
+}
+
+type bucket struct {
+	tokens  float64
+	updated time.Time
+}
+
+// userLimiter holds a user's buckets, shared by all of their connections.
+type userLimiter struct {
+	buckets  map[string]*bucket
+	lastUsed time.Time
+}
+
+// checkRate takes a token for the event from the user's bucket. Once a
+// connection piles up rejections it gets errRateLimitAbuse instead and
+// should be closed.
+func (m *Manager) checkRate(c *Client, eventType string) error {
+	m.limitMu.Lock()
+	defer m.limitMu.Unlock()
+
+	key := eventType
+	limit, ok := m.config.RateLimits[key]
+	if !ok {
+		key = defaultLimitKey
+		limit, ok = m.config.RateLimits[key]
+		if !ok {
+			return nil
+		}
+	}
+
+	now := time.Now()
+	limiter := m.limiters[c.UserID]
+	if limiter == nil {
+		limiter = &userLimiter{buckets: make(map[string]*bucket)}
+		m.limiters[c.UserID] = limiter
+	}
+	limiter.lastUsed = now
+
+	b := limiter.buckets[key]
+	if b == nil {
+		b = &bucket{tokens: float64(limit.Burst), updated: now}
+		limiter.buckets[key] = b
+	}
+
+	b.tokens = min(float64(limit.Burst), b.tokens+now.Sub(b.updated).Seconds()*limit.Rate)
+	b.updated = now
+
+	if b.tokens >= 1 {
+		b.tokens--
+		return nil
+	}
+
+	if now.Sub(c.violationsSince) > abuseWindow {
+		c.violations = 0
+		c.violationsSince = now
+	}
+	c.violations++
+	if c.violations > abuseThreshold {
+		return errRateLimitAbuse
+	}
+
+	return &RateLimitError{
+		EventType:  eventType,
+		RetryAfter: time.Duration((1 - b.tokens) / limit.Rate * float64(time.Second)),
+	}
+}
+
+// sweepLimiters forgets users whose buckets have long since refilled.
+func (m *Manager) sweepLimiters() {
+	m.limitMu.Lock()
+	defer m.limitMu.Unlock()
+
+	cutoff := time.Now().Add(-limiterIdleTimeout)
+	for userID, limiter := range m.limiters {
+		if limiter.lastUsed.Before(cutoff) {
+			delete(m.limiters, userID)
+		}
+	}
+}
@@ -0,0 +1,56 @@
+package ws
+
+import "errors"
+
+var ErrInvalidReaction = errors.New("reaction type must be between 1 and 50 characters")
+
+func (m *Manager) AddReaction(messageID, userID int, reactionType string) error {
+	return m.updateReaction(messageID, userID, reactionType, true)
+}
+
+func (m *Manager) RemoveReaction(messageID, userID int, reactionType string) error {
+	return m.updateReaction(messageID, userID, reactionType, false)
+}
+
+func (m *Manager) updateReaction(messageID, userID int, reactionType string, add bool) error {
+	if reactionType == "" || len(reactionType) > 50 {
+		return ErrInvalidReaction
+	}
+
+	message, _, err := AuthorizeMessage(m.store, userID, messageID, ActionPost)
+	if err != nil {
+		return err
+	}
+
+	var changed bool
+	if add {
+		changed, err = m.store.Messages.AddReaction(messageID, userID, reactionType)
+	} else {
+		changed, err = m.store.Messages.RemoveReaction(messageID, userID, reactionType)
+	}
+	if err != nil {
+		return err
+	}
+
+	// Repeated adds and removes are no-ops, so there is nothing to announce.
+	if !changed {
+		return nil
+	}
+
+	counts, err := m.store.Messages.ReactionCounts(messageID)
+	if err != nil {
+		return err
+	}
+
+	return m.BroadcastRoomEvent(message.RoomID, Event{
+		Type: "reaction_updated",
+		Payload: ReactionUpdatedPayload{
+			MessageID:    messageID,
+			RoomID:       message.RoomID,
+			UserID:       userID,
+			ReactionType: reactionType,
+			Added:        add,
+			Counts:       counts,
+		},
+	})
+}
@@ -0,0 +1,32 @@
+package ws
+
+import "time"
+
+// MarkRead records that userID has read messageID and every earlier message
+// in the same room.
+func (m *Manager) MarkRead(messageID, userID int) error {
+	message, _, err := AuthorizeMessage(m.store, userID, messageID, ActionRead)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	marked, err := m.store.Messages.MarkRead(message, userID, now)
+	if err != nil {
+		return err
+	}
+
+	if marked == 0 {
+		return nil
+	}
+
+	return m.BroadcastEvent(message.RoomID, Event{
+		Type: "read_receipt",
+		Payload: ReadReceiptPayload{
+			RoomID:    message.RoomID,
+			UserID:    userID,
+			MessageID: message.ID,
+			ReadAt:    now,
+		},
+	})
+}
@@ -0,0 +1,148 @@
+package ws
+
+import (
+	"encoding/json"
+	"time"
+)
+
+const (
+	// maxReplayEvents caps how much a resume replays per room. Clients that
+	// missed more are told to reload the room's history instead.
+	maxReplayEvents = 500
+	eventRetention  = 24 * time.Hour
+	pruneInterval   = time.Hour
+)
+
+// BroadcastRoomEvent assigns the event the room's next sequence number,
+// stores it for replay and sends it to everyone in the room. Use it for
+// events that change room state; ephemeral ones go through BroadcastEvent.
+func (m *Manager) BroadcastRoomEvent(roomID int, event Event) error {
+	data, err := m.store.Rooms.AppendEvent(roomID, event)
+	if err != nil {
+		return err
+	}
+
+	m.BroadcastToRoom(roomID, data)
+	return nil
+}
+
+// Resume subscribes the client to the room and replays the events after
+// lastSeq before live delivery continues. Live events that arrive while the
+// replay is loaded are held back and sent after it, minus any the replay
+// already covered.
+func (m *Manager) Resume(c *Client, roomID int, lastSeq int64) error {
+	c.beginResume(roomID)
+	replayed := lastSeq
+	defer func() { m.endResume(c, roomID, replayed) }()
+
+	if err := m.Subscribe(c, roomID); err != nil {
+		return err
+	}
+
+	seq, err := m.store.Rooms.LastSeq(roomID)
+	if err != nil {
+		return err
+	}
+
+	replay := ReplayPayload{RoomID: roomID, Events: []json.RawMessage{}, Seq: seq}
+
+	switch {
+	case lastSeq > seq, seq-lastSeq > maxReplayEvents:
+		replay.Truncated = true
+	case seq > lastSeq:
+		events, err := m.store.Rooms.EventsAfter(roomID, lastSeq, maxReplayEvents)
+		if err != nil {
+			return err
+		}
+
+		// Missing leading events were pruned; the client has to reload.
+		if len(events) == 0 || events[0].Seq != lastSeq+1 {
+			replay.Truncated = true
+			break
+		}
+
+		for _, event := range events {
+			replay.Events = append(replay.Events, json.RawMessage(event.Data))
+			replayed = event.Seq
+		}
+		if replayed > replay.Seq {
+			replay.Seq = replayed
+		}
+	}
+
+	if replay.Truncated {
+		replayed = 0
+	}
+
+	return c.sendEvent(Event{Type: "replay", Payload: replay})
+}
+
+// beginResume starts holding back live events for the room.
+func (c *Client) beginResume(roomID int) {
+	c.resumeMu.Lock()
+	defer c.resumeMu.Unlock()
+
+	if c.resuming == nil {
+		c.resuming = make(map[int][][]byte)
+	}
+	c.resuming[roomID] = [][]byte{}
+}
+
+// holdBack keeps data for later if the room is being resumed. Callers must
+// hold the manager's read lock.
+func (c *Client) holdBack(roomID int, data []byte) bool {
+	c.resumeMu.Lock()
+	defer c.resumeMu.Unlock()
+
+	held, ok := c.resuming[roomID]
+	if !ok {
+		return false
+	}
+	c.resuming[roomID] = append(held, data)
+	return true
+}
+
+// endResume flushes the events held back during a resume and switches the
+// room to live delivery. Events at or below replayed were part of the replay.
+func (m *Manager) endResume(c *Client, roomID int, replayed int64) {
+	if m.flushHeld(c, roomID, replayed) {
+		m.disconnectSlow([]*Client{c})
+	}
+}
+
+// flushHeld reports whether the client fell behind while being flushed.
+func (m *Manager) flushHeld(c *Client, roomID int, replayed int64) bool {
+	m.RLock()
+	defer m.RUnlock()
+
+	c.resumeMu.Lock()
+	defer c.resumeMu.Unlock()
+
+	held := c.resuming[roomID]
+	delete(c.resuming, roomID)
+
+	if !m.clients[c] {
+		return false
+	}
+
+	for _, data := range held {
+		var head struct {
+			Seq int64 `json:"seq"`
+		}
+		if json.Unmarshal(data, &head) == nil && head.Seq != 0 && head.Seq <= replayed {
+			continue
+		}
+
+		if m.enqueue(c, m.newOutbound(data)) {
+			return true
+		}
+	}
+	return false
+}
+
+// pruneEvents drops replay events past their retention.
+func (m *Manager) pruneEvents() {
+	if err := m.store.Rooms.PruneEvents(time.Now().Add(-eventRetention)); err != nil {
+		m.logger.Error("failed to prune room events", "error", err)
+	}
+}
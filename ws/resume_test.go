@@ -0,0 +1,124 @@
+package ws_test
+
+import (
+	"encoding/json"
+	"testing"
+	"ws-whatever/ws"
+)
+
+func TestResume(t *testing.T) {
+	tests := []struct {
+		name string
+		// lastSeq is relative to the room's sequence before two messages
+		// are sent.
+		lastSeq       int64
+		wantEvents    int
+		wantTruncated bool
+	}{
+		{name: "missed two messages", lastSeq: 0, wantEvents: 2},
+		{name: "missed one message", lastSeq: 1, wantEvents: 1},
+		{name: "caught up", lastSeq: 2},
+		{name: "ahead of the server", lastSeq: 5, wantTruncated: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := newTestServer(t)
+			roomID := srv.createRoom(t, false, 1, 2)
+
+			sender := srv.dial(t, 1)
+			seq := sender.subscribe(t, roomID)
+			for _, content := range []string{"one", "two"} {
+				sender.send(t, "send_message", ws.SendMessagePayload{RoomID: roomID, Content: content})
+				sender.expect(t, "message_ack", nil)
+			}
+
+			c := srv.dial(t, 2)
+			c.send(t, "resume", ws.ResumePayload{Rooms: map[int]int64{roomID: seq + tt.lastSeq}})
+
+			var replay ws.ReplayPayload
+			c.expect(t, "replay", &replay)
+			if replay.Truncated != tt.wantTruncated || len(replay.Events) != tt.wantEvents {
+				t.Fatalf("replay has %d events, truncated %v; want %d, %v",
+					len(replay.Events), replay.Truncated, tt.wantEvents, tt.wantTruncated)
+			}
+			if replay.Seq != seq+2 {
+				t.Fatalf("replay seq = %d, want %d", replay.Seq, seq+2)
+			}
+
+			for i, data := range replay.Events {
+				var event received
+				if err := json.Unmarshal(data, &event); err != nil {
+					t.Fatal(err)
+				}
+				wantSeq := seq + tt.lastSeq + int64(i) + 1
+				if event.Type != "new_message" || event.Seq != wantSeq {
+					t.Fatalf("event %d is %s with seq %d, want new_message with seq %d", i, event.Type, event.Seq, wantSeq)
+				}
+			}
+
+			// Resuming subscribes, so live events follow the replay.
+			sender.send(t, "send_message", ws.SendMessagePayload{RoomID: roomID, Content: "three"})
+			if event := c.expect(t, "new_message", nil); event.Seq != seq+3 {
+				t.Fatalf("live event seq = %d, want %d", event.Seq, seq+3)
+			}
+		})
+	}
+}
+
+func TestResumeReplaysThreadReplies(t *testing.T) {
+	tests := []struct {
+		name string
+		// lastSeq is relative to the room's sequence before the reply.
+		lastSeq    int64
+		wantEvents []string
+	}{
+		{name: "missed the reply", lastSeq: 0, wantEvents: []string{"thread_reply", "thread_updated"}},
+		{name: "missed the counts", lastSeq: 1, wantEvents: []string{"thread_updated"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := newTestServer(t)
+			roomID := srv.createRoom(t, false, 1, 2)
+			root := srv.createMessage(t, roomID, 1, 0, nil)
+
+			sender := srv.dial(t, 1)
+			seq := sender.subscribe(t, roomID)
+			sender.send(t, "send_message", ws.SendMessagePayload{RoomID: roomID, Content: "reply", ReplyToID: &root.ID})
+			var ack ws.MessageAckPayload
+			sender.expect(t, "message_ack", &ack)
+			sender.expect(t, "thread_updated", nil)
+
+			c := srv.dial(t, 2)
+			c.send(t, "resume", ws.ResumePayload{Rooms: map[int]int64{roomID: seq + tt.lastSeq}})
+
+			var replay ws.ReplayPayload
+			c.expect(t, "replay", &replay)
+			if len(replay.Events) != len(tt.wantEvents) {
+				t.Fatalf("replay has %d events, want %v", len(replay.Events), tt.wantEvents)
+			}
+
+			for i, data := range replay.Events {
+				var event received
+				if err := json.Unmarshal(data, &event); err != nil {
+					t.Fatal(err)
+				}
+				if event.Type != tt.wantEvents[i] {
+					t.Fatalf("event %d is %s, want %s", i, event.Type, tt.wantEvents[i])
+				}
+				if event.Type != "thread_reply" {
+					continue
+				}
+
+				var reply ws.NewMessagePayload
+				if err := json.Unmarshal(event.Payload, &reply); err != nil {
+					t.Fatal(err)
+				}
+				if reply.ID != ack.ID || event.Seq != seq+1 {
+					t.Fatalf("replayed thread_reply for message %d with seq %d, want %d with seq %d", reply.ID, event.Seq, ack.ID, seq+1)
+				}
+			}
+		})
+	}
+}
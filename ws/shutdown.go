@@ -0,0 +1,66 @@
+package ws
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"math/rand/v2"
+	"time"
+
+	ws "github.com/gorilla/websocket"
+)
+
+var ErrShuttingDown = errors.New("server is shutting down")
+
+// Draining reports whether Shutdown has started.
+func (m *Manager) Draining() bool {
+	m.RLock()
+	defer m.RUnlock()
+
+	return m.draining
+}
+
+// Shutdown stops accepting clients, tells every connected client to
+// reconnect after about reconnectAfter and closes it with 1012 (Service
+// Restart). It then waits until every client has flushed its queued events
+// and finished the event it was handling, or ctx expires, in which case the
+// remaining connections are closed outright.
+func (m *Manager) Shutdown(ctx context.Context, reconnectAfter time.Duration) error {
+	m.Lock()
+	m.draining = true
+	clients := make([]*Client, 0, len(m.clients))
+	for c := range m.clients {
+		clients = append(clients, c)
+	}
+	m.Unlock()
+
+	for _, c := range clients {
+		// Spread reconnects out so the remaining nodes are not hit at once.
+		delay := reconnectAfter + rand.N(reconnectAfter+1)
+		data, err := json.Marshal(Event{
+			Type:    "server_restarting",
+			Payload: ServerRestartingPayload{ReconnectAfterMs: delay.Milliseconds()},
+		})
+		if err == nil {
+			m.send(c, data)
+		}
+
+		m.removeClient(c, ws.CloseServiceRestart)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		m.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		for _, c := range clients {
+			c.Conn.Close()
+		}
+		return ctx.Err()
+	}
+}
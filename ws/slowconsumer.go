@@ -0,0 +1,178 @@
+package ws
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// CloseSlowConsumer is the close code sent to clients that fell too far
+// behind. They are expected to reconnect and resume from their last seen
+// sequence numbers.
+const CloseSlowConsumer = 4008
+
+// DefaultOverflowLimit bounds the overflow queue of a single client.
+const DefaultOverflowLimit = 1024
+
+// SlowConsumerPolicy decides what happens to an event for a client whose
+// Send buffer is full.
+type SlowConsumerPolicy string
+
+const (
+	// SlowConsumerDisconnect closes the client right away.
+	SlowConsumerDisconnect SlowConsumerPolicy = "disconnect"
+	// SlowConsumerQueue spills events to a bounded overflow queue and
+	// closes the client once that is full too.
+	SlowConsumerQueue SlowConsumerPolicy = "queue"
+	// SlowConsumerCoalesce queues like SlowConsumerQueue, but a queued typing
+	// or presence event is replaced by a newer one for the same room or
+	// user instead of queueing both.
+	SlowConsumerCoalesce SlowConsumerPolicy = "coalesce"
+)
+
+func ParseSlowConsumerPolicy(s string) (SlowConsumerPolicy, error) {
+	switch policy := SlowConsumerPolicy(s); policy {
+	case SlowConsumerDisconnect, SlowConsumerQueue, SlowConsumerCoalesce:
+		return policy, nil
+	default:
+		return "", fmt.Errorf("unknown slow consumer policy %q", s)
+	}
+}
+
+// outbound is an encoded event waiting for a client. Events with a key
+// may be coalesced with newer ones carrying the same key.
+type outbound struct {
+	data []byte
+	key  string
+}
+
+func (m *Manager) newOutbound(data []byte) outbound {
+	if m.config.SlowConsumerPolicy != SlowConsumerCoalesce {
+		return outbound{data: data}
+	}
+	return outbound{data: data, key: coalesceKey(data)}
+}
+
+// coalesceKey identifies ephemeral events where only the latest one
+// matters. Everything else gets an empty key and is never dropped.
+func coalesceKey(data []byte) string {
+	var head struct {
+		Type    string `json:"type"`
+		Payload struct {
+			RoomID int `json:"room_id"`
+			UserID int `json:"user_id"`
+		} `json:"payload"`
+	}
+	if json.Unmarshal(data, &head) != nil {
+		return ""
+	}
+
+	switch head.Type {
+	case "typing":
+		return fmt.Sprintf("typing:%d", head.Payload.RoomID)
+	case "presence":
+		return fmt.Sprintf("presence:%d", head.Payload.UserID)
+	default:
+		return ""
+	}
+}
+
+// enqueue hands msg to the client's write pump, applying the slow consumer
+// policy if its Send buffer is full. It reports whether the client has to
+// be disconnected, which the caller must do after releasing the lock.
+// Callers must hold the read lock and have checked the client is still
+// registered.
+func (m *Manager) enqueue(c *Client, msg outbound) bool {
+	c.outMu.Lock()
+	defer c.outMu.Unlock()
+
+	// Already on its way out; the caller that flagged it disconnects it.
+	if c.slow {
+		return false
+	}
+
+	// Anything queued goes first to keep events in order.
+	if len(c.overflow) == 0 {
+		select {
+		case c.Send <- msg.data:
+			return false
+		default:
+		}
+	}
+
+	switch m.config.SlowConsumerPolicy {
+	case SlowConsumerDisconnect:
+		c.slow = true
+		slowConsumerTotal.WithLabelValues("disconnected").Inc()
+		return true
+	case SlowConsumerCoalesce:
+		if msg.key != "" {
+			for i := range c.overflow {
+				if c.overflow[i].key == msg.key {
+					c.overflow[i] = msg
+					slowConsumerTotal.WithLabelValues("coalesced").Inc()
+					return false
+				}
+			}
+		}
+	}
+
+	if len(c.overflow) >= m.config.OverflowLimit {
+		c.slow = true
+		c.overflow = nil
+		slowConsumerTotal.WithLabelValues("disconnected").Inc()
+		return true
+	}
+
+	c.overflow = append(c.overflow, msg)
+	c.hasOverflow.Store(true)
+	slowConsumerTotal.WithLabelValues("queued").Inc()
+
+	// The write pump may have emptied Send since the attempt above.
+	c.moveOverflow()
+	return false
+}
+
+// flushOverflow moves queued events into Send as room frees up. The write
+// pump calls it after every write.
+func (m *Manager) flushOverflow(c *Client) {
+	if !c.hasOverflow.Load() {
+		return
+	}
+
+	m.RLock()
+	defer m.RUnlock()
+
+	if !m.clients[c] {
+		return
+	}
+
+	c.outMu.Lock()
+	defer c.outMu.Unlock()
+
+	c.moveOverflow()
+}
+
+// moveOverflow needs outMu held and Send open.
+func (c *Client) moveOverflow() {
+	for len(c.overflow) > 0 {
+		select {
+		case c.Send <- c.overflow[0].data:
+			c.overflow[0] = outbound{}
+			c.overflow = c.overflow[1:]
+		default:
+			return
+		}
+	}
+
+	c.overflow = nil
+	c.hasOverflow.Store(false)
+}
+
+// disconnectSlow closes clients that enqueue flagged. They can resume
+// where they left off, so nothing is silently lost.
+func (m *Manager) disconnectSlow(clients []*Client) {
+	for _, c := range clients {
+		c.logger.Warn("disconnecting slow consumer")
+		m.removeClient(c, CloseSlowConsumer)
+	}
+}
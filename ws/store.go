@@ -0,0 +1,160 @@
+package ws
+
+import (
+	"errors"
+	"time"
+)
+
+var (
+	ErrAttachmentNotFound = errors.New("attachment not found")
+	ErrAlreadyParticipant = errors.New("user already in room")
+)
+
+// MessageStore persists messages along with their revisions, reactions,
+// read receipts and attachments.
+type MessageStore interface {
+	// CreateMessage stores the message, filling in its ID and CreatedAt,
+	// and links the pending attachments to it in the same transaction. It
+	// returns ErrInvalidAttachments if any of them cannot be claimed.
+	CreateMessage(message *Message, attachmentIDs []int) ([]AttachmentPayload, error)
+	// GetMessage returns a message that has not been deleted, or
+	// ErrMessageNotFound.
+	GetMessage(messageID int) (*Message, error)
+	// FindByClientMsgID returns the sender's message carrying clientMsgID,
+	// or nil if there is none.
+	FindByClientMsgID(senderID int, clientMsgID string) (*Message, error)
+	// ReviseMessage keeps the current content as a revision by editorID and
+	// replaces it with content, updating message to match. It returns
+	// ErrMessageNotFound if the message was deleted in the meantime.
+	ReviseMessage(message *Message, editorID int, content string, at time.Time) error
+	DeleteMessage(messageID int, at time.Time) error
+	SetPinned(messageID int, pinned bool) error
+
+	// History returns up to limit messages of a room in chronological
+	// order, leaving thread replies to their threads. The returned cursor
+	// is the ID to pass as BeforeID (or AfterID when paging forward) for
+	// the next page, and is nil once there is nothing more in that
+	// direction.
+	History(roomID int, cursor HistoryCursor, limit int) ([]Message, *int, error)
+	// ThreadReplies pages through the replies to root like History.
+	ThreadReplies(root Message, cursor HistoryCursor, limit int) ([]Message, *int, error)
+	// ThreadSummaries is keyed by message ID and leaves out messages
+	// without replies.
+	ThreadSummaries(messageIDs []int) (map[int]ThreadSummary, error)
+	PinnedMessages(roomID int) ([]Message, error)
+	Revisions(messageID int) ([]MessageRevision, error)
+	// SearchMessages returns matches newest first with the before_id of the
+	// next page, or with ByRank best first with the offset of the next
+	// page. The next page is nil when there is none.
+	SearchMessages(query MessageQuery) ([]SearchResult, *int, error)
+
+	// AddReaction and RemoveReaction report whether anything changed.
+	AddReaction(messageID, userID int, reactionType string) (bool, error)
+	RemoveReaction(messageID, userID int, reactionType string) (bool, error)
+	ReactionCounts(messageID int) (map[string]int, error)
+	// ReactionSummaries aggregates reactions for the given messages,
+	// marking the types userID has reacted with.
+	ReactionSummaries(userID int, messageIDs []int) (map[int][]ReactionSummary, error)
+
+	// MarkRead records that userID has read message and every earlier one
+	// in its room, skipping their own. It reports how many were new.
+	MarkRead(message Message, userID int, at time.Time) (int, error)
+	// UnreadCounts and LastReadMessages are keyed by room ID.
+	UnreadCounts(userID int) (map[int]int, error)
+	LastReadMessages(userID int) (map[int]int, error)
+
+	CreateAttachment(attachment *MessageAttachment) error
+	// GetAttachment returns the attachment or ErrAttachmentNotFound.
+	GetAttachment(attachmentID int) (*MessageAttachment, error)
+	// Attachments returns the attachments of the given messages keyed by
+	// message ID.
+	Attachments(messageIDs []int) (map[int][]AttachmentPayload, error)
+	// UnclaimedAttachments returns up to limit uploads created before
+	// before that no message has claimed, oldest first.
+	UnclaimedAttachments(before time.Time, limit int) ([]MessageAttachment, error)
+	// DeleteUnclaimedAttachment deletes the upload unless a message has
+	// claimed it, and reports whether it did.
+	DeleteUnclaimedAttachment(attachmentID int) (bool, error)
+}
+
+// MessageQuery is a message search on behalf of UserID, which only ever
+// covers rooms the user is in. The other fields narrow it down further
+// when set.
+type MessageQuery struct {
+	UserID   int
+	Text     string
+	RoomID   int
+	SenderID int
+	// Since and Until bound created_at, Until exclusive.
+	Since          time.Time
+	Until          time.Time
+	HasAttachments bool
+	Pinned         bool
+	// BeforeID pages through results by date. With ByRank, results are
+	// ordered by relevance and paged with Offset instead.
+	BeforeID *int
+	ByRank   bool
+	Offset   int
+	Limit    int
+}
+
+// ThreadSummary counts the replies to a message that have not been
+// deleted.
+type ThreadSummary struct {
+	ReplyCount  int
+	LastReplyAt *time.Time
+}
+
+// SearchResult is a message matching a search. Headline is the HTML
+// escaped content with the matching terms wrapped in <mark>. Rank is only
+// meaningful within one search.
+type SearchResult struct {
+	Message  Message
+	Rank     float64
+	Headline string
+}
+
+// RoomStore persists rooms and their sequenced events.
+type RoomStore interface {
+	// CreateRoom stores the room and its first participants, creating
+	// their users as needed.
+	CreateRoom(room *Room, participants []RoomParticipant) error
+	// GetRoom returns the room or ErrRoomNotFound.
+	GetRoom(roomID int) (*Room, error)
+	// ListRooms returns the public group rooms plus every room userID is in.
+	ListRooms(userID int) ([]Room, error)
+	// FindDirectRoom returns the direct room between the two users in the
+	// community, or nil if there is none.
+	FindDirectRoom(communityID, userA, userB int) (*Room, error)
+
+	// AppendEvent assigns the event the room's next sequence number, stores
+	// it and returns its encoding.
+	AppendEvent(roomID int, event Event) ([]byte, error)
+	// LastSeq returns the sequence number of the room's latest event.
+	LastSeq(roomID int) (int64, error)
+	// EventsAfter returns up to limit of the room's events following seq.
+	EventsAfter(roomID int, seq int64, limit int) ([]RoomEvent, error)
+	PruneEvents(before time.Time) error
+}
+
+// ParticipantStore persists room membership.
+type ParticipantStore interface {
+	// GetParticipant returns the user's membership of the room, or nil if
+	// they are not in it.
+	GetParticipant(roomID, userID int) (*RoomParticipant, error)
+	// AddParticipant creates the user as needed and adds them to the room,
+	// returning ErrAlreadyParticipant if they are in it already.
+	AddParticipant(participant *RoomParticipant) error
+	// Participants returns the room's members ordered by user ID.
+	Participants(roomID int) ([]RoomParticipant, error)
+	// UserRooms returns the user's memberships with their Room loaded.
+	UserRooms(userID int) ([]RoomParticipant, error)
+	RoomIDs(userID int) ([]int, error)
+}
+
+// Stores bundles the stores the Manager and the HTTP handlers work with.
+type Stores struct {
+	Messages     MessageStore
+	Rooms        RoomStore
+	Participants ParticipantStore
+}
@@ -0,0 +1,189 @@
+package ws
+
+import "errors"
+
+var (
+	ErrInvalidReply = errors.New("reply_to_id must reference a message in the same room")
+	ErrNestedReply  = errors.New("reply_to_id must reference the thread's first message, not a reply")
+)
+
+// checkThreadRoot verifies that a reply in roomID can be threaded under
+// replyToID. Threads are one level deep, so clients replying within a
+// thread reply to its root.
+func (m *Manager) checkThreadRoot(roomID, replyToID int) error {
+	parent, err := m.store.Messages.GetMessage(replyToID)
+	if errors.Is(err, ErrMessageNotFound) {
+		return ErrInvalidReply
+	}
+	if err != nil {
+		return err
+	}
+
+	if parent.RoomID != roomID {
+		return ErrInvalidReply
+	}
+	if parent.ReplyToID != nil {
+		return ErrNestedReply
+	}
+	return nil
+}
+
+// SubscribeThread sends the client thread_reply events for replies to
+// rootID. Room subscribers only get the thread's counts.
+func (m *Manager) SubscribeThread(c *Client, rootID int) error {
+	root, _, err := AuthorizeMessage(m.store, c.UserID, rootID, ActionRead)
+	if err != nil {
+		return err
+	}
+
+	m.Lock()
+	defer m.Unlock()
+
+	// The client may have disconnected while we were talking to the database.
+	if !m.clients[c] {
+		return nil
+	}
+
+	if m.threads[rootID] == nil {
+		m.threads[rootID] = make(map[*Client]bool)
+	}
+	m.threads[rootID][c] = true
+
+	if m.clientThreads[c] == nil {
+		m.clientThreads[c] = make(map[int]int)
+	}
+	m.clientThreads[c][rootID] = root.RoomID
+
+	return nil
+}
+
+func (m *Manager) UnsubscribeThread(c *Client, rootID int) {
+	m.Lock()
+	defer m.Unlock()
+
+	m.leaveThread(c, rootID)
+	delete(m.clientThreads[c], rootID)
+}
+
+// leaveRoomThreads drops the client's subscriptions to threads in roomID.
+// Callers must hold the lock.
+func (m *Manager) leaveRoomThreads(c *Client, roomID int) {
+	for rootID, threadRoomID := range m.clientThreads[c] {
+		if threadRoomID == roomID {
+			m.leaveThread(c, rootID)
+			delete(m.clientThreads[c], rootID)
+		}
+	}
+}
+
+// leaveThread removes the client from the thread's fan-out set. Callers
+// must hold the lock.
+func (m *Manager) leaveThread(c *Client, rootID int) {
+	delete(m.threads[rootID], c)
+	if len(m.threads[rootID]) == 0 {
+		delete(m.threads, rootID)
+	}
+}
+
+// broadcastThreadReply sends a new reply to the thread's subscribers on
+// every node and the updated counts to the room. Like BroadcastRoomEvent it
+// sequences and stores the reply, so resuming the room replays it, but live
+// it only reaches the thread's subscribers.
+func (m *Manager) broadcastThreadReply(reply NewMessagePayload) error {
+	data, err := m.store.Rooms.AppendEvent(reply.RoomID, Event{Type: "thread_reply", Payload: reply})
+	if err != nil {
+		return err
+	}
+
+	rootID := *reply.ReplyToID
+	m.deliverToThread(reply.RoomID, rootID, data)
+	m.publish(BrokerMessage{RoomID: reply.RoomID, ThreadID: rootID, Data: data})
+
+	return m.broadcastThreadUpdated(reply.RoomID, rootID)
+}
+
+// broadcastThreadUpdated tells the room how many replies the thread has
+// now. It is a room event, so clients that resume catch up on it.
+func (m *Manager) broadcastThreadUpdated(roomID, rootID int) error {
+	summaries, err := m.store.Messages.ThreadSummaries([]int{rootID})
+	if err != nil {
+		return err
+	}
+
+	summary := summaries[rootID]
+	return m.BroadcastRoomEvent(roomID, Event{
+		Type: "thread_updated",
+		Payload: ThreadUpdatedPayload{
+			MessageID:   rootID,
+			RoomID:      roomID,
+			ReplyCount:  summary.ReplyCount,
+			LastReplyAt: summary.LastReplyAt,
+		},
+	})
+}
+
+// deliverToThread sends data to the thread's subscribers on this node.
+// Subscribers may have lost access to the room since subscribing, so each
+// user is checked again and those who can no longer read it are dropped.
+func (m *Manager) deliverToThread(roomID, rootID int, data []byte) {
+	m.RLock()
+	subscribers := make([]*Client, 0, len(m.threads[rootID]))
+	for client := range m.threads[rootID] {
+		subscribers = append(subscribers, client)
+	}
+	m.RUnlock()
+
+	access := make(map[int]error)
+	for _, client := range subscribers {
+		if _, ok := access[client.UserID]; ok {
+			continue
+		}
+		_, err := Authorize(m.store, client.UserID, roomID, ActionRead)
+		if err != nil && !isAccessLost(err) {
+			m.logger.Error("failed to check thread access", "error", err, "userID", client.UserID, "roomID", roomID)
+		}
+		access[client.UserID] = err
+	}
+
+	msg := m.newOutbound(data)
+
+	var slow, revoked []*Client
+	m.RLock()
+	for _, client := range subscribers {
+		// The client may have unsubscribed or disconnected since.
+		if !m.threads[rootID][client] {
+			continue
+		}
+		if err := access[client.UserID]; err != nil {
+			if isAccessLost(err) {
+				revoked = append(revoked, client)
+			}
+			continue
+		}
+		if client.holdBack(roomID, data) {
+			continue
+		}
+
+		if m.enqueue(client, msg) {
+			slow = append(slow, client)
+		}
+	}
+	m.RUnlock()
+
+	if len(revoked) > 0 {
+		m.Lock()
+		for _, client := range revoked {
+			m.leaveThread(client, rootID)
+			delete(m.clientThreads[client], rootID)
+		}
+		m.Unlock()
+	}
+
+	m.disconnectSlow(slow)
+}
+
+// isAccessLost reports whether err means the user can no longer read the
+// room, as opposed to the check itself failing.
+func isAccessLost(err error) bool {
+	return IsForbidden(err) || errors.Is(err, ErrRoomNotFound)
+}
@@ -0,0 +1,177 @@
+package ws_test
+
+import (
+	"sync"
+	"testing"
+	"ws-whatever/internal/store"
+	"ws-whatever/ws"
+)
+
+func TestThreadReplies(t *testing.T) {
+	tests := []struct {
+		name string
+		// replyTo picks the message replied to from the root and an
+		// existing reply to it.
+		replyTo  func(root, reply ws.Message) int
+		wantNack string
+	}{
+		{name: "reply to the root", replyTo: func(root, _ ws.Message) int { return root.ID }},
+		{name: "reply to a reply", replyTo: func(_, reply ws.Message) int { return reply.ID }, wantNack: "invalid_request"},
+		{name: "missing message", replyTo: func(root, reply ws.Message) int { return root.ID + reply.ID + 100 }, wantNack: "invalid_request"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := newTestServer(t)
+			roomID := srv.createRoom(t, false, 1, 2)
+			root := srv.createMessage(t, roomID, 1, 0, nil)
+			reply := srv.createMessage(t, roomID, 1, 1, &root.ID)
+
+			sender := srv.dial(t, 1)
+			sender.subscribe(t, roomID)
+
+			follower := srv.dial(t, 2)
+			follower.send(t, "subscribe_thread", ws.ThreadSubscribePayload{MessageID: root.ID})
+			follower.expect(t, "thread_subscribed", nil)
+
+			replyToID := tt.replyTo(root, reply)
+			sender.send(t, "send_message", ws.SendMessagePayload{
+				RoomID:      roomID,
+				Content:     "reply",
+				ReplyToID:   &replyToID,
+				ClientMsgID: "r",
+			})
+
+			if tt.wantNack != "" {
+				var nack ws.MessageNackPayload
+				sender.expect(t, "message_nack", &nack)
+				if nack.Code != tt.wantNack {
+					t.Fatalf("nack code = %q, want %q", nack.Code, tt.wantNack)
+				}
+				return
+			}
+
+			var ack ws.MessageAckPayload
+			sender.expect(t, "message_ack", &ack)
+
+			// Thread subscribers get the reply, the room only the counts.
+			var threadReply ws.NewMessagePayload
+			follower.expect(t, "thread_reply", &threadReply)
+			if threadReply.ID != ack.ID || threadReply.ReplyToID == nil || *threadReply.ReplyToID != root.ID {
+				t.Fatalf("thread_reply = %+v, want message %d in thread %d", threadReply, ack.ID, root.ID)
+			}
+
+			var updated ws.ThreadUpdatedPayload
+			event := sender.expect(t, "thread_updated", &updated)
+			if updated.MessageID != root.ID || updated.ReplyCount != 2 || event.Seq == 0 {
+				t.Fatalf("thread_updated = %+v with seq %d, want 2 replies to %d", updated, event.Seq, root.ID)
+			}
+
+			messages, _, err := srv.stores.Messages.History(roomID, ws.HistoryCursor{}, 10)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(messages) != 1 || messages[0].ID != root.ID {
+				t.Fatalf("history = %+v, want only the root", messages)
+			}
+
+			replies, _, err := srv.stores.Messages.ThreadReplies(root, ws.HistoryCursor{}, 10)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(replies) != 2 || replies[1].ID != ack.ID {
+				t.Fatalf("thread has %d replies, want the new one last", len(replies))
+			}
+		})
+	}
+}
+
+// revocableParticipants hides revoked users' memberships, as if they had
+// been removed from their rooms.
+type revocableParticipants struct {
+	ws.ParticipantStore
+
+	mu      sync.Mutex
+	revoked map[int]bool
+}
+
+func (p *revocableParticipants) revoke(userID int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.revoked[userID] = true
+}
+
+func (p *revocableParticipants) GetParticipant(roomID, userID int) (*ws.RoomParticipant, error) {
+	p.mu.Lock()
+	revoked := p.revoked[userID]
+	p.mu.Unlock()
+
+	if revoked {
+		return nil, nil
+	}
+	return p.ParticipantStore.GetParticipant(roomID, userID)
+}
+
+func TestThreadSubscriptionAccess(t *testing.T) {
+	tests := []struct {
+		name string
+		// after runs once the follower is subscribed to the thread.
+		after     func(t *testing.T, follower *testClient, roomID int, participants *revocableParticipants)
+		wantReply bool
+	}{
+		{
+			name:      "subscribed",
+			after:     func(*testing.T, *testClient, int, *revocableParticipants) {},
+			wantReply: true,
+		},
+		{
+			name: "unsubscribed from the room",
+			after: func(t *testing.T, follower *testClient, roomID int, _ *revocableParticipants) {
+				follower.subscribe(t, roomID)
+				follower.send(t, "unsubscribe", ws.SubscribePayload{RoomID: roomID})
+				follower.expect(t, "unsubscribed", nil)
+			},
+		},
+		{
+			name: "removed from the room",
+			after: func(_ *testing.T, _ *testClient, _ int, participants *revocableParticipants) {
+				participants.revoke(2)
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stores := store.NewMemory().Stores()
+			participants := &revocableParticipants{ParticipantStore: stores.Participants, revoked: make(map[int]bool)}
+			stores.Participants = participants
+			srv := newNode(t, stores, nil)
+
+			roomID := srv.createRoom(t, false, 1, 2, 3)
+			root := srv.createMessage(t, roomID, 1, 0, nil)
+
+			follower := srv.dial(t, 2)
+			watcher := srv.dial(t, 3)
+			for _, c := range []*testClient{follower, watcher} {
+				c.send(t, "subscribe_thread", ws.ThreadSubscribePayload{MessageID: root.ID})
+				c.expect(t, "thread_subscribed", nil)
+			}
+			tt.after(t, follower, roomID, participants)
+
+			sender := srv.dial(t, 1)
+			sender.send(t, "send_message", ws.SendMessagePayload{RoomID: roomID, Content: "reply", ReplyToID: &root.ID})
+			sender.expect(t, "message_ack", nil)
+			watcher.expect(t, "thread_reply", nil)
+
+			// The reply was handed out before the watcher got it, and
+			// events reach a client in order, so it is either skipped
+			// by now or was never sent.
+			follower.send(t, "unsubscribe_thread", ws.ThreadSubscribePayload{MessageID: root.ID})
+			follower.expect(t, "thread_unsubscribed", nil)
+			if _, got := follower.takeSkipped("thread_reply"); got != tt.wantReply {
+				t.Fatalf("follower got thread_reply: %v, want %v", got, tt.wantReply)
+			}
+		})
+	}
+}
@@ -0,0 +1,224 @@
+package ws
+
+import (
+	"encoding/json"
+	"slices"
+	"sort"
+	"time"
+)
+
+const (
+	// typingTimeout is how long a typing indicator lasts without a refresh.
+	// It is also how long another node's report is trusted without a
+	// heartbeat.
+	typingTimeout = 3 * time.Second
+
+	typingSweepInterval = time.Second
+)
+
+// NodeTyping is who is typing in a room on one node. Each node reports its
+// own typers through the broker and combines the other nodes' reports into
+// the list its clients see.
+type NodeTyping struct {
+	RoomID  int   `json:"room_id"`
+	UserIDs []int `json:"user_ids"`
+}
+
+type remoteTyping struct {
+	userIDs []int
+	seenAt  time.Time
+}
+
+// SetTyping marks the user as typing in the room and reports whether they
+// just started. Refreshes while already typing are not broadcast, so a fast
+// typist costs the room one event rather than one per keystroke.
+func (m *Manager) SetTyping(roomID, userID int) bool {
+	m.Lock()
+	defer m.Unlock()
+
+	if m.typing[roomID] == nil {
+		m.typing[roomID] = make(map[int]time.Time)
+	}
+
+	lastTyped, ok := m.typing[roomID][userID]
+	m.typing[roomID][userID] = time.Now()
+	return !ok || time.Since(lastTyped) >= typingTimeout
+}
+
+// StopTyping clears the user's typing state and reports whether it was set.
+func (m *Manager) StopTyping(roomID, userID int) bool {
+	m.Lock()
+	defer m.Unlock()
+
+	return m.clearTyping(roomID, userID)
+}
+
+// GetTypingUsers returns who is typing in the room on any node.
+func (m *Manager) GetTypingUsers(roomID int) []int {
+	m.RLock()
+	defer m.RUnlock()
+
+	return m.typingUsers(roomID)
+}
+
+// localTypingUsers returns who is typing in the room on this node. Callers
+// must hold the lock.
+func (m *Manager) localTypingUsers(roomID int) []int {
+	users := []int{}
+	cutoff := time.Now().Add(-typingTimeout)
+	for userID, lastTyped := range m.typing[roomID] {
+		if lastTyped.After(cutoff) {
+			users = append(users, userID)
+		}
+	}
+	sort.Ints(users)
+	return users
+}
+
+// typingUsers combines this node's typers with the other nodes'. Callers
+// must hold the lock.
+func (m *Manager) typingUsers(roomID int) []int {
+	users := m.localTypingUsers(roomID)
+	for _, r := range m.remoteTyping[roomID] {
+		users = append(users, r.userIDs...)
+	}
+	sort.Ints(users)
+	return slices.Compact(users)
+}
+
+// clearTyping must be called with the lock held.
+func (m *Manager) clearTyping(roomID, userID int) bool {
+	if _, ok := m.typing[roomID][userID]; !ok {
+		return false
+	}
+
+	delete(m.typing[roomID], userID)
+	if len(m.typing[roomID]) == 0 {
+		delete(m.typing, roomID)
+	}
+	return true
+}
+
+// leaveTyping stops userID typing in the room once their last connection
+// has left it, so another tab that is still subscribed keeps the indicator.
+// Callers must hold the lock and have already removed the leaving client.
+func (m *Manager) leaveTyping(roomID, userID int) bool {
+	for client := range m.rooms[roomID] {
+		if client.UserID == userID {
+			return false
+		}
+	}
+	return m.clearTyping(roomID, userID)
+}
+
+// sweepTyping prunes expired typing entries, forgets reports from nodes
+// that stopped sending heartbeats and tells the affected rooms. Rooms with
+// typers here are reported again so the other nodes keep showing them.
+func (m *Manager) sweepTyping() {
+	var changed, expired []int
+	var heartbeat []NodeTyping
+	now := time.Now()
+	cutoff := now.Add(-typingTimeout)
+
+	m.Lock()
+	for roomID, users := range m.typing {
+		stopped := false
+		for userID, lastTyped := range users {
+			if !lastTyped.After(cutoff) {
+				delete(users, userID)
+				stopped = true
+			}
+		}
+		if len(users) == 0 {
+			delete(m.typing, roomID)
+		}
+		if stopped {
+			changed = append(changed, roomID)
+		} else {
+			heartbeat = append(heartbeat, NodeTyping{RoomID: roomID, UserIDs: m.localTypingUsers(roomID)})
+		}
+	}
+
+	for roomID, nodes := range m.remoteTyping {
+		before := m.typingUsers(roomID)
+		for nodeID, r := range nodes {
+			if !r.seenAt.After(cutoff) {
+				delete(nodes, nodeID)
+			}
+		}
+		if len(nodes) == 0 {
+			delete(m.remoteTyping, roomID)
+		}
+		if !slices.Contains(changed, roomID) && !slices.Equal(before, m.typingUsers(roomID)) {
+			expired = append(expired, roomID)
+		}
+	}
+	m.Unlock()
+
+	for _, roomID := range changed {
+		m.broadcastTyping(roomID)
+	}
+	for _, roomID := range expired {
+		m.deliverTyping(roomID, m.GetTypingUsers(roomID))
+	}
+	if len(heartbeat) > 0 {
+		m.publish(BrokerMessage{Typing: heartbeat})
+	}
+}
+
+// broadcastTyping sends the room's typers to its clients here and this
+// node's own typers to the other nodes, which combine them with theirs.
+func (m *Manager) broadcastTyping(roomID int) {
+	m.RLock()
+	users := m.typingUsers(roomID)
+	local := m.localTypingUsers(roomID)
+	m.RUnlock()
+
+	m.deliverTyping(roomID, users)
+	m.publish(BrokerMessage{Typing: []NodeTyping{{RoomID: roomID, UserIDs: local}}})
+}
+
+// handleRemoteTyping records another node's typers and tells the rooms
+// here whose combined list changed.
+func (m *Manager) handleRemoteTyping(nodeID string, reports []NodeTyping) {
+	changed := make(map[int][]int)
+
+	m.Lock()
+	now := time.Now()
+	for _, r := range reports {
+		before := m.typingUsers(r.RoomID)
+		if len(r.UserIDs) == 0 {
+			delete(m.remoteTyping[r.RoomID], nodeID)
+			if len(m.remoteTyping[r.RoomID]) == 0 {
+				delete(m.remoteTyping, r.RoomID)
+			}
+		} else {
+			if m.remoteTyping[r.RoomID] == nil {
+				m.remoteTyping[r.RoomID] = make(map[string]remoteTyping)
+			}
+			m.remoteTyping[r.RoomID][nodeID] = remoteTyping{userIDs: r.UserIDs, seenAt: now}
+		}
+
+		if after := m.typingUsers(r.RoomID); !slices.Equal(before, after) {
+			changed[r.RoomID] = after
+		}
+	}
+	m.Unlock()
+
+	for roomID, users := range changed {
+		m.deliverTyping(roomID, users)
+	}
+}
+
+// deliverTyping sends the room's typers to its clients on this node only.
+func (m *Manager) deliverTyping(roomID int, userIDs []int) {
+	data, err := json.Marshal(Event{
+		Type:    "typing",
+		Payload: TypingPayload{RoomID: roomID, UserIDs: userIDs},
+	})
+	if err != nil {
+		m.logger.Error("failed to encode typing", "error", err, "roomID", roomID)
+		return
+	}
+	m.deliverToRoom(roomID, data)
+}
@@ -0,0 +1,81 @@
+package ws_test
+
+import (
+	"slices"
+	"testing"
+	"ws-whatever/ws"
+)
+
+// expectTyping waits for a typing event listing exactly userIDs, skipping
+// the ones before it.
+func (c *testClient) expectTyping(t *testing.T, userIDs ...int) {
+	t.Helper()
+
+	var last []int
+	for range 10 {
+		var typing ws.TypingPayload
+		c.expect(t, "typing", &typing)
+		if slices.Equal(typing.UserIDs, userIDs) {
+			return
+		}
+		last = typing.UserIDs
+	}
+	t.Fatalf("typing = %v, want %v", last, userIDs)
+}
+
+func TestTypingAcrossNodes(t *testing.T) {
+	tests := []struct {
+		name string
+		stop func(t *testing.T, c *testClient, roomID int)
+	}{
+		{
+			name: "typing_stop",
+			stop: func(t *testing.T, c *testClient, roomID int) {
+				c.send(t, "typing_stop", ws.TypingEventPayload{RoomID: roomID})
+			},
+		},
+		{
+			name: "sending the message",
+			stop: func(t *testing.T, c *testClient, roomID int) {
+				c.send(t, "send_message", ws.SendMessagePayload{RoomID: roomID, Content: "hello"})
+			},
+		},
+		{
+			name: "unsubscribing",
+			stop: func(t *testing.T, c *testClient, roomID int) {
+				c.send(t, "unsubscribe", ws.SubscribePayload{RoomID: roomID})
+			},
+		},
+		{
+			name: "disconnecting",
+			stop: func(t *testing.T, c *testClient, _ int) {
+				c.conn.Close()
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			nodes := newTestCluster(t, 2)
+			roomID := nodes[0].createRoom(t, false, 1, 2, 3)
+
+			first := nodes[0].dial(t, 1)
+			watcher := nodes[1].dial(t, 2)
+			second := nodes[1].dial(t, 3)
+			for _, c := range []*testClient{first, watcher, second} {
+				c.subscribe(t, roomID)
+			}
+
+			first.send(t, "typing", ws.TypingEventPayload{RoomID: roomID})
+			watcher.expectTyping(t, 1)
+
+			second.send(t, "typing", ws.TypingEventPayload{RoomID: roomID})
+			watcher.expectTyping(t, 1, 3)
+			first.expectTyping(t, 1, 3)
+
+			// Stopping on one node leaves the other node's typers shown.
+			tt.stop(t, first, roomID)
+			watcher.expectTyping(t, 3)
+		})
+	}
+}